@@ -0,0 +1,201 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/secure"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage: migrate <command> [flags]
+
+Commands:
+  encrypt   Encrypt plaintext personal data in place (users, scores, queued
+            notifications, webhook deliveries and CHSI sessions), re-wrap values that use an old
+            key under the primary key (key rotation) and recompute InfoHash
+            as a keyed HMAC. Safe to run repeatedly.
+
+Flags:
+`
+
+// rawUser reads the stored columns without going through the serializer
+type rawUser struct {
+	ID       uint
+	Name     string
+	IDCard   string
+	ExamID   string
+	InfoHash string
+	PurgedAt *time.Time
+}
+
+func main() {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	if len(os.Args) < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	fs.Parse(os.Args[2:])
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	logger.Init(cfg.LogLevel)
+
+	database, err := db.Init(cfg)
+	if err != nil {
+		log.Fatalf("Database init failed: %v", err)
+	}
+	defer db.Close(database)
+
+	switch command {
+	case "encrypt":
+		if !secure.Active().Enabled() {
+			logger.Warn("No field encryption key configured, only InfoHash will be recomputed")
+		}
+		if err := encryptUsers(database, *dryRun); err != nil {
+			log.Fatalf("Encrypting users failed: %v", err)
+		}
+		for _, c := range encryptedColumns {
+			if err := encryptColumn(database, c.table, c.column, *dryRun); err != nil {
+				log.Fatalf("Encrypting %s.%s failed: %v", c.table, c.column, err)
+			}
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// encryptUsers migrates every user row, including soft-deleted ones.
+// Anonymized rows hold no personal data and keep their placeholder InfoHash.
+func encryptUsers(database *gorm.DB, dryRun bool) error {
+	kr := secure.Active()
+	var rows []rawUser
+	migrated := 0
+
+	err := database.Table("users").Select("id, name, id_card, exam_id, info_hash, purged_at").
+		FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				if row.PurgedAt != nil || (row.Name == "" && row.IDCard == "" && row.ExamID == "") {
+					continue
+				}
+				plain := map[string]string{}
+				needsMigration := false
+				for column, value := range map[string]string{"name": row.Name, "id_card": row.IDCard, "exam_id": row.ExamID} {
+					decrypted, err := kr.Decrypt(value)
+					if err != nil {
+						return fmt.Errorf("user %d %s: %w", row.ID, column, err)
+					}
+					plain[column] = decrypted
+					needsMigration = needsMigration || kr.NeedsMigration(value)
+				}
+				infoHash := kr.InfoHash(plain["name"], plain["id_card"], plain["exam_id"])
+				if !needsMigration && infoHash == row.InfoHash {
+					continue
+				}
+
+				updates := map[string]interface{}{"info_hash": infoHash}
+				for column, value := range plain {
+					encrypted, err := kr.Encrypt(value)
+					if err != nil {
+						return fmt.Errorf("user %d %s: %w", row.ID, column, err)
+					}
+					updates[column] = encrypted
+				}
+
+				migrated++
+				if dryRun {
+					continue
+				}
+				err := database.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Table("users").Where("id = ?", row.ID).Updates(updates).Error; err != nil {
+						return err
+					}
+					// 导入记录随InfoHash一起更新，否则已导入的条目会被再次导入
+					return tx.Model(&model.ImportRecord{}).Where("entry_hash = ?", row.InfoHash).
+						Update("entry_hash", infoHash).Error
+				})
+				if err != nil {
+					return fmt.Errorf("user %d: %w", row.ID, err)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Users migrated: %d (dry run: %v)", migrated, dryRun)
+	return nil
+}
+
+// encryptedColumns each hold a single encrypted value
+var encryptedColumns = []struct{ table, column string }{
+	{"chsi_sessions", "cookies"},
+	{"users", "score"},
+	{"notifications", "result"},
+	{"webhook_deliveries", "payload"},
+}
+
+// rawColumn reads one stored column without the serializer
+type rawColumn struct {
+	ID    uint
+	Value string
+}
+
+// encryptColumn migrates a column holding a single encrypted value, such as
+// session cookies or a JSON encoded score
+func encryptColumn(database *gorm.DB, table, column string, dryRun bool) error {
+	kr := secure.Active()
+	var rows []rawColumn
+	migrated := 0
+
+	err := database.Table(table).Select("id, "+column+" AS value").
+		Where(column+" IS NOT NULL AND "+column+" <> ''").
+		FindInBatches(&rows, 200, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				if !kr.NeedsMigration(row.Value) {
+					continue
+				}
+				decrypted, err := kr.Decrypt(row.Value)
+				if err != nil {
+					return fmt.Errorf("%s %d: %w", table, row.ID, err)
+				}
+				encrypted, err := kr.Encrypt(decrypted)
+				if err != nil {
+					return fmt.Errorf("%s %d: %w", table, row.ID, err)
+				}
+
+				migrated++
+				if dryRun {
+					continue
+				}
+				if err := database.Table(table).Where("id = ?", row.ID).Update(column, encrypted).Error; err != nil {
+					return fmt.Errorf("%s %d: %w", table, row.ID, err)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	logger.Info("%s.%s migrated: %d (dry run: %v)", table, column, migrated, dryRun)
+	return nil
+}
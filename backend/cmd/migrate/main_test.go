@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	database "chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/secure"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestEncryptUsersSkipsPurgedRows(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	// Rows are written as plaintext before a key is configured
+	secure.Use(&secure.Keyring{})
+	now := time.Now()
+	users := []*model.User{
+		{Name: "张三", IDCard: "110101199001011237", ExamID: "100010000000001", InfoHash: "legacy"},
+		{InfoHash: "purged-2", PurgedAt: &now},
+		{InfoHash: "purged-3", PurgedAt: &now},
+	}
+	for _, user := range users {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.Create(&model.ImportRecord{EntryHash: "legacy"})
+
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	kr, err := secure.NewKeyring("k1:"+key, "", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure.Use(kr)
+	t.Cleanup(func() { secure.Use(&secure.Keyring{}) })
+
+	if err := encryptUsers(db, false); err != nil {
+		t.Fatalf("encryptUsers() error = %v", err)
+	}
+
+	var rows []rawUser
+	db.Table("users").Select("id, name, id_card, exam_id, info_hash, purged_at").Order("id").Scan(&rows)
+	if !secure.IsEncrypted(rows[0].Name) || rows[0].InfoHash != kr.InfoHash("张三", "110101199001011237", "100010000000001") {
+		t.Errorf("live user = %+v, want encrypted with a keyed hash", rows[0])
+	}
+	var record model.ImportRecord
+	if db.First(&record).Error != nil || record.EntryHash != rows[0].InfoHash {
+		t.Errorf("import record = %+v, want the new InfoHash", record)
+	}
+	for _, row := range rows[1:] {
+		if row.InfoHash != fmt.Sprintf("purged-%d", row.ID) || row.Name != "" {
+			t.Errorf("purged user = %+v, want untouched", row)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
-"log"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-"chsi-auto-score-query/internal/api"
-"chsi-auto-score-query/internal/db"
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/pkg/config"
+	"chsi-auto-score-query/internal/api"
+	"chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/pkg/config"
 )
 
 func main() {
@@ -20,6 +26,9 @@ func main() {
 	logger.Init(cfg.LogLevel)
 	logger.Info("Application starting")
 
+	// 通知渠道的目标地址由提交者填写，默认只允许访问公网地址
+	notify.AllowPrivateAddresses(cfg.NotifyAllowPrivate)
+
 	// 初始化数据库
 	database, err := db.Init(cfg)
 	if err != nil {
@@ -28,10 +37,41 @@ func main() {
 	}
 	logger.Info("Database initialized")
 
+	// 监听退出信号
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 启动API服务
 	server := api.NewServer(cfg, database)
-	if err := server.Start(); err != nil {
-		logger.Error("Failed to start server: %v", err)
-		log.Fatalf("Server start failed: %v", err)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	var startErr error
+	select {
+	case startErr = <-serverErr:
+		if startErr != nil {
+			logger.Error("Failed to start server: %v", startErr)
+		}
+	case <-ctx.Done():
+		logger.Info("Shutdown signal received")
+	}
+	stop()
+
+	// 优雅退出：等待进行中的请求和查询完成；监听失败时调度器已启动，同样需要停止
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Stop(shutdownCtx); err != nil {
+		logger.Warn("Graceful shutdown incomplete: %v", err)
+	}
+
+	// 关闭数据库
+	if err := db.Close(database); err != nil {
+		logger.Error("Failed to close database: %v", err)
+	}
+	if startErr != nil {
+		log.Fatalf("Server start failed: %v", startErr)
 	}
+	logger.Info("Application stopped")
 }
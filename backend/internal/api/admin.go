@@ -0,0 +1,388 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/internal/service"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// requireAdmin protects operator endpoints with one of the ADMIN_TOKEN bearer
+// tokens or with ADMIN_USERNAME / ADMIN_PASSWORD basic auth. Admin endpoints
+// are disabled when neither is configured.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	var tokens []string
+	for _, token := range strings.Split(s.cfg.AdminToken, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	basicAuth := s.cfg.AdminUsername != "" && s.cfg.AdminPassword != ""
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(tokens) == 0 && !basicAuth {
+			respondError(w, http.StatusNotFound, "Admin API is disabled")
+			return
+		}
+
+		authorized := false
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					authorized = true
+				}
+			}
+		} else if username, password, ok := r.BasicAuth(); ok && basicAuth {
+			userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.cfg.AdminUsername)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.AdminPassword)) == 1
+			authorized = userOK && passOK
+		}
+		if !authorized {
+			if len(tokens) > 0 {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="admin"`)
+			}
+			if basicAuth {
+				w.Header().Add("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			}
+			respondError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// pagination reads page (from 1) and page_size from the query string
+func pagination(r *http.Request) (page, pageSize int) {
+	page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ = strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	page, pageSize := pagination(r)
+	userID, _ := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 64)
+	status := r.URL.Query().Get("status")
+
+	deliveries, total, err := s.webhooks.ListDeliveries(uint(userID), status, pageSize, (page-1)*pageSize)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"items":     deliveries,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (s *Server) handleReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid delivery id")
+		return
+	}
+
+	delivery, err := s.webhooks.Replay(r.Context(), uint(id))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if delivery == nil {
+		respondError(w, http.StatusNotFound, "Delivery not found")
+		return
+	}
+
+	logger.Info("Webhook delivery %d replayed: %s", delivery.ID, delivery.Status)
+	respondSuccess(w, delivery)
+}
+
+func (s *Server) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	page, pageSize := pagination(r)
+	userID, _ := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 64)
+	status := r.URL.Query().Get("status")
+
+	notifications, total, err := s.outbox.List(uint(userID), status, pageSize, (page-1)*pageSize)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"items":     notifications,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// handleRetryNotification moves a dead-lettered notification back into the
+// outbox; the scheduler delivers it on its next dispatch round.
+func (s *Server) handleRetryNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid notification id")
+		return
+	}
+
+	n, err := s.outbox.FindByID(uint(id))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if n == nil {
+		respondError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if n.Status == model.NotificationSent {
+		respondError(w, http.StatusConflict, "Notification already sent")
+		return
+	}
+	if err := s.outbox.Requeue(n); err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	logger.Info("Notification %d requeued", n.ID)
+	respondSuccess(w, n)
+}
+
+// handleListUsers lists submissions, filtered by status, school_code and the
+// last query time (queried_after / queried_before, RFC 3339)
+func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	page, pageSize := pagination(r)
+	query := r.URL.Query()
+	filter := repo.UserFilter{
+		Status:     model.QueryStatus(query.Get("status")),
+		SchoolCode: query.Get("school_code"),
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		respondError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	for param, target := range map[string]**time.Time{
+		"queried_after":  &filter.QueriedAfter,
+		"queried_before": &filter.QueriedBefore,
+	} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "Invalid "+param+", expected RFC 3339 time")
+				return
+			}
+			*target = &t
+		}
+	}
+
+	users, total, err := s.userRepo.List(filter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	items := make([]map[string]interface{}, len(users))
+	for i := range users {
+		items[i] = adminUserView(&users[i])
+	}
+	respondSuccess(w, map[string]interface{}{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// handleGetUser shows one submission with the notifications its query
+// results produced
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	notifications, _, err := s.outbox.List(user.ID, "", maxPageSize, 0)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"user":          adminUserView(user),
+		"notifications": notifications,
+	})
+}
+
+// handleQueryUser queries one user immediately, ignoring its backoff. Only
+// verified users that are still being polled can be queried.
+func (s *Server) handleQueryUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.scheduler.QueryUser(user.ID)
+	if errors.Is(err, service.ErrNotQueryable) {
+		respondError(w, http.StatusConflict, "User is not being queried, resume or reset it first")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if user == nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	respondSuccess(w, adminUserView(user))
+}
+
+// handleListAttempts pages through the query history of a user
+func (s *Server) handleListAttempts(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.adminUser(w, r)
+	if !ok {
+		return
+	}
+	page, pageSize := pagination(r)
+
+	attempts, total, err := s.attempts.ListByUser(user.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"items":     attempts,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// handleAttemptSnapshot returns the CHSI page stored with an attempt. It is
+// served as plain text so the page is never rendered in the admin's browser.
+func (s *Server) handleAttemptSnapshot(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid attempt id")
+		return
+	}
+	attempt, err := s.attempts.FindByID(uint(id))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if attempt == nil || attempt.Snapshot == "" {
+		respondError(w, http.StatusNotFound, "Snapshot not found")
+		return
+	}
+	page, err := attempt.SnapshotPage()
+	if err != nil {
+		logger.Error("Failed to read snapshot of query attempt %d: %v", attempt.ID, err)
+		respondError(w, http.StatusInternalServerError, "Snapshot is corrupted")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, page)
+}
+
+// handleQueryAll starts a batch for all pending users without waiting for
+// the next interval
+func (s *Server) handleQueryAll(w http.ResponseWriter, r *http.Request) {
+	if !s.scheduler.Trigger() {
+		respondError(w, http.StatusServiceUnavailable, "Scheduler is not running")
+		return
+	}
+
+	logger.Info("Query batch triggered by admin")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ScoreResponse{Code: 0, Message: "Query batch scheduled"})
+}
+
+// ResetRequest selects the failed users to reset; empty resets all of them
+type ResetRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+// handleResetFailed gives failed users a fresh retry budget
+func (s *Server) handleResetFailed(w http.ResponseWriter, r *http.Request) {
+	var req ResetRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			respondError(w, http.StatusBadRequest, "Invalid request")
+			return
+		}
+	}
+
+	count, err := s.userRepo.ResetFailed(req.IDs...)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	logger.Info("Admin reset %d failed user(s)", count)
+	if count > 0 {
+		s.scheduler.Trigger()
+	}
+	respondSuccess(w, map[string]interface{}{
+		"reset": count,
+	})
+}
+
+// adminUser loads the user named by the id path value, writing the error
+// response if there is none
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid user id")
+		return nil, false
+	}
+	user, err := s.userRepo.FindByID(uint(id))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return nil, false
+	}
+	if user == nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// adminUserView adds the scheduling state operators need to the owner's view
+func adminUserView(user *model.User) map[string]interface{} {
+	view := submissionView(user)
+	view["attempts"] = user.Attempts
+	view["next_attempt_at"] = user.NextAttemptAt
+	view["notified_at"] = user.NotifiedAt
+	view["purged_at"] = user.PurgedAt
+	view["created_at"] = user.CreatedAt
+	return view
+}
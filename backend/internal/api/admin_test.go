@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	s := newTestServer(t)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	request := func(h http.HandlerFunc, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+		if auth != nil {
+			auth(r)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(username, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+
+	// Without credentials the admin API does not exist
+	if w := request(s.requireAdmin(ok), bearer("")); w.Code != http.StatusNotFound {
+		t.Errorf("disabled admin API = %d, want 404", w.Code)
+	}
+	s.cfg.AdminUsername = "admin"
+	if w := request(s.requireAdmin(ok), basic("admin", "")); w.Code != http.StatusNotFound {
+		t.Errorf("admin API with a username only = %d, want 404", w.Code)
+	}
+
+	s.cfg.AdminToken = "first, second"
+	s.cfg.AdminPassword = "secret"
+	h := s.requireAdmin(ok)
+	for _, tc := range []struct {
+		name string
+		auth func(r *http.Request)
+		want int
+	}{
+		{"no credentials", nil, http.StatusUnauthorized},
+		{"first token", bearer("first"), http.StatusNoContent},
+		{"second token", bearer("second"), http.StatusNoContent},
+		{"wrong token", bearer("third"), http.StatusUnauthorized},
+		{"empty token", bearer(""), http.StatusUnauthorized},
+		{"basic auth", basic("admin", "secret"), http.StatusNoContent},
+		{"wrong password", basic("admin", "wrong"), http.StatusUnauthorized},
+		{"wrong username", basic("root", "secret"), http.StatusUnauthorized},
+	} {
+		w := request(h, tc.auth)
+		if w.Code != tc.want {
+			t.Errorf("%s = %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if w.Code == http.StatusUnauthorized && len(w.Header().Values("WWW-Authenticate")) != 2 {
+			t.Errorf("%s challenges = %v, want bearer and basic", tc.name, w.Header().Values("WWW-Authenticate"))
+		}
+	}
+
+	// Basic auth is only accepted when configured
+	s.cfg.AdminPassword = ""
+	if w := request(s.requireAdmin(ok), basic("admin", "")); w.Code != http.StatusUnauthorized {
+		t.Errorf("basic auth without a password configured = %d, want 401", w.Code)
+	}
+}
+
+func TestForcedQueryRejectsUsersNotPolled(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+	unverified := &model.User{Email: "a@example.com", InfoHash: "h1"}
+	purged := &model.User{Email: "b@example.com", InfoHash: "h2", VerifiedAt: &now, PurgedAt: &now}
+	stopped := &model.User{Email: "c@example.com", InfoHash: "h3", VerifiedAt: &now, Status: model.QueryStatusStopped}
+	failed := &model.User{Email: "d@example.com", InfoHash: "h4", VerifiedAt: &now, Status: model.QueryStatusFailed}
+	for _, u := range []*model.User{unverified, purged, stopped, failed} {
+		if err := s.userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(id string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+id+"/query", nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		s.handleQueryUser(w, r)
+		return w.Code
+	}
+	for _, u := range []*model.User{unverified, purged, stopped, failed} {
+		if code := query(strconv.Itoa(int(u.ID))); code != http.StatusConflict {
+			t.Errorf("forced query of %s = %d, want 409", u.Email, code)
+		}
+	}
+	if code := query("999"); code != http.StatusNotFound {
+		t.Errorf("forced query of an unknown user = %d, want 404", code)
+	}
+	if code := query("x"); code != http.StatusBadRequest {
+		t.Errorf("forced query with an invalid id = %d, want 400", code)
+	}
+
+	var attempts int64
+	s.db.Model(&model.QueryAttempt{}).Count(&attempts)
+	if attempts != 0 {
+		t.Errorf("%d CHSI queries made", attempts)
+	}
+	if stored, _ := s.userRepo.FindByID(failed.ID); stored.Status != model.QueryStatusFailed {
+		t.Errorf("failed user moved to %q", stored.Status)
+	}
+}
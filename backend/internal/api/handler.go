@@ -1,29 +1,94 @@
 package api
 
 import (
-"crypto/md5"
-"encoding/json"
-"fmt"
-"io"
-"net/http"
-"time"
-
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/internal/model"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/mail"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/secure"
+	"chsi-auto-score-query/internal/service"
+	"chsi-auto-score-query/internal/validate"
 )
 
+const verifyTokenPurpose = "verify"
+
 type SubmitRequest struct {
 	Name       string `json:"name"`
 	IDCard     string `json:"id_card"`
 	ExamID     string `json:"exam_id"`
 	Email      string `json:"email"`
 	SchoolCode string `json:"school_code"`
+	// 通知语言：zh-CN（默认）或 en
+	Locale string `json:"locale"`
+	// 通知渠道，可选多个；为空时默认通过邮件通知
+	Channels []ChannelRequest `json:"channels"`
+	// 本提交单独注册的Webhook，可选
+	Webhooks []WebhookRequest `json:"webhooks"`
+	// 额外的通知邮箱（如家长），可选
+	Recipients []string `json:"recipients"`
+	// 通知偏好，可选；为空时只通知成绩发布
+	Notify *NotificationSettings `json:"notify"`
+}
+
+// NotificationSettings chooses the events that are notified. Omitted fields
+// keep their current or default setting.
+type NotificationSettings struct {
+	ScoreReleased *bool `json:"score_released"`
+	StatusChange  *bool `json:"status_change"`
+	DailyDigest   *bool `json:"daily_digest"`
+}
+
+// apply sets the chosen fields on pref
+func (n *NotificationSettings) apply(pref *model.NotificationPreference) {
+	if n.ScoreReleased != nil {
+		pref.ScoreReleased = *n.ScoreReleased
+	}
+	if n.StatusChange != nil {
+		pref.StatusChange = *n.StatusChange
+	}
+	if n.DailyDigest != nil {
+		pref.DailyDigest = *n.DailyDigest
+	}
 }
 
+// ChannelRequest selects a notification channel and its settings, for
+// example {"type": "bark", "settings": {"device_key": "..."}}
+type ChannelRequest struct {
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings"`
+}
+
+// WebhookRequest registers a webhook URL for one submission. Without a
+// secret, a random one is generated and returned once in the submit response.
+type WebhookRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// maxIdempotencyKeyLength limits the Idempotency-Key header of a submit
+const maxIdempotencyKeyLength = 255
+
+const (
+	submittedMessage = "Your info has been submitted. We'll send you the score when available."
+	verifyMessage    = "Your info has been submitted. Please open the link in the verification email to start querying."
+)
+
 type ScoreResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	// Errors lists the rejected fields of an invalid submission
+	Errors validate.Errors `json:"errors,omitempty"`
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -53,71 +118,458 @@ func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 验证必填字段
-	if req.Name == "" || req.IDCard == "" || req.ExamID == "" || req.Email == "" || req.SchoolCode == "" {
-		respondError(w, http.StatusBadRequest, "Missing required fields")
+	idempotencyKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		respondError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return
+	}
+
+	user, err := s.newSubmission(req)
+	if err != nil {
+		respondInvalid(w, err)
+		return
+	}
+
+	// 客户端重试：同一Idempotency-Key与相同信息返回原提交
+	if idempotencyKey != "" {
+		user.SubmitKeyHash = service.HashIdempotencyKey(idempotencyKey)
+		previous, err := s.userRepo.FindBySubmitKey(user.SubmitKeyHash)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if previous != nil {
+			s.replaySubmit(w, previous, user)
+			return
+		}
+	}
+
+	// 自助管理令牌只返回这一次，数据库中仅保存摘要
+	manageToken, manageTokenHash := service.NewManageToken()
+	user.ManageTokenHash = manageTokenHash
+
+	if !s.cfg.EmailVerification {
+		now := time.Now()
+		user.VerifiedAt = &now
+	}
+
+	existing, err := s.userRepo.FindAnyByInfoHash(user.InfoHash)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	verificationSent := false
+	switch {
+	case existing == nil:
+		if err := s.userRepo.Create(user); err != nil {
+			// 并发提交相同信息时由唯一索引拦截
+			if existing, _ = s.userRepo.FindAnyByInfoHash(user.InfoHash); existing != nil {
+				respondDuplicate(w, existing)
+				return
+			}
+			respondError(w, http.StatusInternalServerError, "Failed to save user info")
+			return
+		}
+	case reactivatable(existing, user):
+		// 先发送验证链接再替换原记录，发送失败时原提交保持不变
+		if s.cfg.EmailVerification {
+			user.ID = existing.ID
+			if err := s.sendVerification(r.Context(), user); err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to send verification email")
+				return
+			}
+			verificationSent = true
+		}
+		if err := s.userRepo.Reactivate(existing, user); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to save user info")
+			return
+		}
+		logger.Info("User %d resubmitted, reactivating the previous submission", user.ID)
+	case s.cfg.EmailVerification && unverifiedResubmit(existing, user):
+		s.resendVerification(w, r, existing)
+		return
+	default:
+		respondDuplicate(w, existing)
+		return
+	}
+
+	if !s.cfg.EmailVerification {
+		logger.Info("User submitted: %s (%s)", secure.MaskName(req.Name), req.Email)
+		respondSuccess(w, submitResponse(user, manageToken, submittedMessage))
 		return
 	}
 
-	// 生成InfoHash以防止重复
-	hash := md5.Sum([]byte(req.Name + ":" + req.IDCard + ":" + req.ExamID))
-	infoHash := fmt.Sprintf("%x", hash)
+	// 发送验证链接，验证通过后才开始查询
+	if !verificationSent {
+		if err := s.sendVerification(r.Context(), user); err != nil {
+			if err := s.userRepo.HardDelete(user.ID); err != nil {
+				logger.Error("Failed to remove unverified user %d: %v", user.ID, err)
+			}
+			respondError(w, http.StatusInternalServerError, "Failed to send verification email")
+			return
+		}
+	}
+
+	logger.Info("User submitted, awaiting email verification: %s (%s)", secure.MaskName(req.Name), req.Email)
+	respondSuccess(w, submitResponse(user, manageToken, verifyMessage))
+}
+
+// submitResponse is the answer to a new submission. The management token and
+// the webhook signing secrets are only shown here.
+func submitResponse(user *model.User, manageToken, message string) map[string]interface{} {
+	resp := map[string]interface{}{
+		"user_id":      user.ID,
+		"manage_token": manageToken,
+		"message":      message,
+	}
+	if len(user.Webhooks) > 0 {
+		webhooks := make([]map[string]string, 0, len(user.Webhooks))
+		for _, endpoint := range user.Webhooks {
+			webhooks = append(webhooks, map[string]string{"url": endpoint.URL, "secret": endpoint.Secret})
+		}
+		resp["webhooks"] = webhooks
+	}
+	return resp
+}
+
+// replaySubmit answers a retried submit whose Idempotency-Key was already
+// used. The same data gets the original submission back with a new
+// management token, since only the hash of the first one is stored; the key
+// may not be reused for different data.
+func (s *Server) replaySubmit(w http.ResponseWriter, previous, user *model.User) {
+	if previous.InfoHash != user.InfoHash || previous.Email != user.Email {
+		respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different submission")
+		return
+	}
+
+	manageToken, manageTokenHash := service.NewManageToken()
+	if err := s.userRepo.UpdateManageToken(previous.ID, manageTokenHash); err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	message := submittedMessage
+	if previous.VerifiedAt == nil {
+		message = verifyMessage
+	}
+	logger.Info("Replayed submit of user %d for a retried Idempotency-Key", previous.ID)
+	w.Header().Set("Idempotent-Replayed", "true")
+	respondSuccess(w, map[string]interface{}{
+		"user_id":      previous.ID,
+		"manage_token": manageToken,
+		"message":      message,
+	})
+}
+
+// reactivatable reports whether a new submit may take over an existing
+// submission with the same information: deleted ones, and those no longer
+// queried when resubmitted with the same email. Submissions still in
+// progress, or finished ones of another address, are left to their owner.
+func reactivatable(existing, user *model.User) bool {
+	if existing.DeletedAt.Valid {
+		return true
+	}
+	status := existing.Status
+	if status == "" {
+		status = model.QueryStatusPending
+	}
+	return !status.Polling() && strings.EqualFold(existing.Email, user.Email)
+}
+
+// unverifiedResubmit reports whether a submit repeats a submission whose
+// email was never verified, from the same address
+func unverifiedResubmit(existing, user *model.User) bool {
+	return !existing.DeletedAt.Valid && existing.VerifiedAt == nil && strings.EqualFold(existing.Email, user.Email)
+}
+
+// resendVerification sends the verification link of an unverified
+// submission again, at most once per minute for each address. No new
+// management token is issued, the submission is only reachable through the
+// link sent to its address.
+func (s *Server) resendVerification(w http.ResponseWriter, r *http.Request, existing *model.User) {
+	if ok, retry := s.linkLimiter.Allow(strings.ToLower(existing.Email)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)+1))
+		respondError(w, http.StatusTooManyRequests, "A verification email was sent recently, please check your inbox")
+		return
+	}
+	if err := s.sendVerification(r.Context(), existing); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	logger.Info("Resent verification email for unverified user %d", existing.ID)
+	respondSuccess(w, map[string]interface{}{
+		"user_id": existing.ID,
+		"message": verifyMessage,
+	})
+}
+
+// respondDuplicate rejects a submit whose information is already being
+// queried with 409 Conflict and the public status of that submission. The
+// owner can manage it with the management token or link.
+func respondDuplicate(w http.ResponseWriter, existing *model.User) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(ScoreResponse{
+		Code:    http.StatusConflict,
+		Message: "A submission with this information already exists",
+		Data: map[string]interface{}{
+			"status":       existing.Status,
+			"verified":     existing.VerifiedAt != nil,
+			"submitted_at": existing.CreatedAt,
+		},
+	})
+}
+
+// sendVerification emails the double opt-in link for the user's address
+func (s *Server) sendVerification(ctx context.Context, user *model.User) error {
+	ttl := time.Duration(s.cfg.VerifyTokenTTL) * time.Second
+	token := s.tokens.Sign(verifyTokenPurpose, user.ID, ttl)
+	link := strings.TrimRight(s.cfg.PublicBaseURL, "/") + "/api/verify/" + token
+	if err := s.emailSvc.SendVerification(ctx, user.Email, user.Name, user.Locale, link, time.Now().Add(ttl)); err != nil {
+		logger.Error("Failed to send verification email: %v", err)
+		return err
+	}
+	return nil
+}
+
+// newSubmission validates a submission and builds the user to store. The
+// error messages are meant for the client.
+func (s *Server) newSubmission(req SubmitRequest) (*model.User, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.IDCard = validate.NormalizeIDCard(req.IDCard)
+	req.ExamID = strings.TrimSpace(req.ExamID)
+	req.Email = strings.TrimSpace(req.Email)
+	req.SchoolCode = strings.TrimSpace(req.SchoolCode)
+
+	// 校验证件号、考生编号、院校代码和邮箱格式，避免无效信息进入查询队列
+	if err := (validate.Submission{
+		Name:       req.Name,
+		IDCard:     req.IDCard,
+		ExamID:     req.ExamID,
+		Email:      req.Email,
+		SchoolCode: req.SchoolCode,
+	}).Check(); err != nil {
+		return nil, err
+	}
+	emails, fieldErr := validate.Recipients(req.Email, req.Recipients)
+	if fieldErr != nil {
+		return nil, validate.Errors{fieldErr}
+	}
+	recipients := s.newRecipients(emails)
+	var pref *model.NotificationPreference
+	if req.Notify != nil {
+		pref = model.DefaultNotificationPreference(0)
+		req.Notify.apply(pref)
+	}
+
+	if !mail.SupportedLocale(req.Locale) {
+		return nil, errors.New("Unsupported locale")
+	}
+
+	// 校验通知渠道设置
+	channels := make([]model.NotificationChannel, 0, len(req.Channels))
+	for _, c := range req.Channels {
+		channel := model.NotificationChannel{Type: strings.TrimSpace(c.Type)}
+		// 邮件渠道只发送到已验证的提交邮箱，其他地址需作为额外收件人添加
+		if to := strings.TrimSpace(c.Settings["to"]); channel.Type == service.ChannelEmail && to != "" {
+			if !strings.EqualFold(to, req.Email) {
+				return nil, errors.New("Invalid notification channel: email channel only sends to the submission email, add other addresses as recipients")
+			}
+			delete(c.Settings, "to")
+		}
+		channel.SetSettings(c.Settings)
+		if _, err := s.notifiers.New(channel.Type, service.ChannelSettings(req.Email, channel)); err != nil {
+			return nil, errors.New("Invalid notification channel: " + err.Error())
+		}
+		channels = append(channels, channel)
+	}
+
+	webhooks := make([]model.WebhookEndpoint, 0, len(req.Webhooks))
+	for _, wh := range req.Webhooks {
+		u, err := url.Parse(strings.TrimSpace(wh.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("Invalid webhook URL")
+		}
+		secret := wh.Secret
+		if secret == "" {
+			secret = service.NewWebhookSecret()
+		}
+		webhooks = append(webhooks, model.WebhookEndpoint{URL: u.String(), Secret: secret})
+	}
 
-	// 创建用户记录
-	user := &model.User{
+	return &model.User{
 		Name:       req.Name,
 		IDCard:     req.IDCard,
 		ExamID:     req.ExamID,
 		Email:      req.Email,
 		SchoolCode: req.SchoolCode,
-		InfoHash:   infoHash,
+		Locale:     mail.MatchLocale(req.Locale),
+		// 生成InfoHash以防止重复（密钥化HMAC，不可由明文直接推算）
+		InfoHash:   secure.InfoHash(req.Name, req.IDCard, req.ExamID),
+		Channels:   channels,
+		Webhooks:   webhooks,
+		Recipients: recipients,
+		Preference: pref,
 		CreatedAt:  time.Now(),
+	}, nil
+}
+
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	respondSuccess(w, map[string]interface{}{
+		"channels": s.notifiers.Channels(),
+	})
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	id, err := s.tokens.Verify(verifyTokenPurpose, r.PathValue("token"))
+	if errors.Is(err, service.ErrTokenExpired) {
+		respondError(w, http.StatusGone, "Verification link has expired, please submit again")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid verification link")
+		return
+	}
+
+	previous, err := s.userRepo.FindByID(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	user, err := s.userRepo.Verify(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if previous == nil || user == nil {
+		respondError(w, http.StatusNotFound, "Submission not found")
+		return
+	}
+
+	// 提交者验证邮箱后再请求额外收件人确认，未验证的提交不会向他人发信
+	if previous.VerifiedAt == nil {
+		if recipients, err := s.userRepo.FindRecipients(user.ID); err == nil {
+			s.sendRecipientVerifications(user, recipients)
+		}
+	}
+
+	logger.Info("Email verified for user %d (%s)", user.ID, user.Email)
+	respondSuccess(w, map[string]interface{}{
+		"user_id": user.ID,
+		"message": "Your email has been verified. We'll send you the score when available.",
+	})
+}
+
+// handleUnsubscribe serves the List-Unsubscribe link of notification emails.
+// GET only shows a confirmation page; POST, sent by that page or as the
+// RFC 8058 one-click request, stops the submission and cancels its queued
+// notifications.
+func (s *Server) handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	id, err := s.tokens.Verify(service.UnsubscribeTokenPurpose, r.PathValue("token"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderConfirm(w, confirmData{
+			Title:   "退订成绩通知 / Unsubscribe",
+			Message: "确认后将停止查询并不再发送通知。Confirm to stop querying and receiving notifications for this submission.",
+			Button:  "确认退订 / Unsubscribe",
+		})
+		return
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
-		logger.Error("Failed to create user: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to save user info")
+	user, err := s.userRepo.Stop(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+	if user == nil {
+		respondError(w, http.StatusNotFound, "Submission not found")
 		return
 	}
 
-	logger.Info("User submitted: %s (%s)", req.Name, req.Email)
+	logger.Info("User %d unsubscribed", user.ID)
 	respondSuccess(w, map[string]interface{}{
-"user_id": user.ID,
-"message": "Your info has been submitted. We'll send you the score when available.",
-})
+		"user_id": user.ID,
+		"message": "You have been unsubscribed. No further queries or notifications will be sent.",
+	})
 }
 
+// ScoreLookupRequest identifies a submission by its email plus a second
+// factor: the management token, or the exam ID and the last four characters
+// of the ID card number.
+type ScoreLookupRequest struct {
+	Email       string `json:"email"`
+	ManageToken string `json:"manage_token"`
+	ExamID      string `json:"exam_id"`
+	IDCardLast4 string `json:"id_card_last4"`
+}
+
+// handleQueryScore returns the result of a submission to its owner. Unknown
+// emails and wrong factors get the same response, so the endpoint cannot be
+// used to find out who submitted.
 func (s *Server) handleQueryScore(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	var req ScoreLookupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request")
 		return
 	}
-
-	email := r.PathValue("email")
-	if email == "" {
+	req.Email = strings.TrimSpace(req.Email)
+	req.ManageToken = strings.TrimSpace(req.ManageToken)
+	req.ExamID = strings.TrimSpace(req.ExamID)
+	req.IDCardLast4 = strings.ToUpper(strings.TrimSpace(req.IDCardLast4))
+	if req.Email == "" {
 		respondError(w, http.StatusBadRequest, "Email is required")
 		return
 	}
+	if req.ManageToken == "" && (req.ExamID == "" || len(req.IDCardLast4) != 4) {
+		respondError(w, http.StatusBadRequest, "manage_token or exam_id and id_card_last4 are required")
+		return
+	}
 
-	user, err := s.userRepo.FindByEmail(email)
+	users, err := s.userRepo.FindAllByEmail(req.Email)
 	if err != nil {
-		logger.Error("Failed to query user: %v", err)
 		respondError(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
+	var user *model.User
+	for i := range users {
+		if ownsSubmission(&users[i], req) {
+			user = &users[i]
+			break
+		}
+	}
 	if user == nil {
-		respondError(w, http.StatusNotFound, "User not found")
+		respondError(w, http.StatusNotFound, "Submission not found")
 		return
 	}
 
 	respondSuccess(w, map[string]interface{}{
-"name":       user.Name,
-"email":      user.Email,
-"score":      user.Score,
-"notice":     user.Notice,
-"query_time": user.LastQueryAt,
-})
+		"name":       user.Name,
+		"email":      user.Email,
+		"score":      user.Score,
+		"status":     user.Status,
+		"notice":     user.Notice,
+		"query_time": user.LastQueryAt,
+	})
+}
+
+// ownsSubmission checks the second factor of a score lookup
+func ownsSubmission(user *model.User, req ScoreLookupRequest) bool {
+	if req.ManageToken != "" {
+		return user.ManageTokenHash != "" &&
+			subtle.ConstantTimeCompare([]byte(service.HashManageToken(req.ManageToken)), []byte(user.ManageTokenHash)) == 1
+	}
+	idCard := strings.ToUpper(user.IDCard)
+	if len(idCard) < 4 {
+		return false
+	}
+	examOK := subtle.ConstantTimeCompare([]byte(req.ExamID), []byte(user.ExamID)) == 1
+	idOK := subtle.ConstantTimeCompare([]byte(req.IDCardLast4), []byte(idCard[len(idCard)-4:])) == 1
+	return examOK && idOK
 }
 
 func respondSuccess(w http.ResponseWriter, data interface{}) {
@@ -133,3 +585,17 @@ func respondError(w http.ResponseWriter, code int, message string) {
 	resp := ScoreResponse{Code: code, Message: message}
 	json.NewEncoder(w).Encode(resp)
 }
+
+// respondInvalid rejects a request with 400 Bad Request. Field validation
+// errors are listed with their codes so clients can point at the field.
+func respondInvalid(w http.ResponseWriter, err error) {
+	var fieldErrs validate.Errors
+	if !errors.As(err, &fieldErrs) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	resp := ScoreResponse{Code: http.StatusBadRequest, Message: "Invalid fields", Errors: fieldErrs}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/service"
+	"chsi-auto-score-query/pkg/config"
+)
+
+func TestOwnsSubmission(t *testing.T) {
+	token, hash := service.NewManageToken()
+	user := &model.User{IDCard: "11010119900101123x", ExamID: "100010000000001", ManageTokenHash: hash}
+
+	for _, tc := range []struct {
+		name string
+		req  ScoreLookupRequest
+		want bool
+	}{
+		{"token", ScoreLookupRequest{ManageToken: token}, true},
+		{"wrong token", ScoreLookupRequest{ManageToken: "x", ExamID: user.ExamID, IDCardLast4: "123X"}, false},
+		{"exam id and id card", ScoreLookupRequest{ExamID: user.ExamID, IDCardLast4: "123X"}, true},
+		{"wrong id card", ScoreLookupRequest{ExamID: user.ExamID, IDCardLast4: "1234"}, false},
+		{"wrong exam id", ScoreLookupRequest{ExamID: "100010000000002", IDCardLast4: "123X"}, false},
+	} {
+		if got := ownsSubmission(user, tc.req); got != tc.want {
+			t.Errorf("ownsSubmission(%s) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSubmitDuplicatesAndIdempotency(t *testing.T) {
+	s := newTestServer(t)
+	submit := func(key, email string) (int, ScoreResponse) {
+		body := `{"name":"张三","id_card":"110101199001011237","exam_id":"100010000000001","email":"` + email + `","school_code":"10001"}`
+		r := httptest.NewRequest(http.MethodPost, "/api/submit", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		s.handleSubmit(w, r)
+		var resp ScoreResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	if code, resp := submit("key-1", "a@example.com"); code != http.StatusOK {
+		t.Fatalf("first submit = %d %+v", code, resp)
+	}
+	if code, resp := submit("key-1", "a@example.com"); code != http.StatusOK || resp.Data.(map[string]interface{})["manage_token"] == "" {
+		t.Errorf("replayed submit = %d %+v", code, resp)
+	}
+	if code, _ := submit("key-1", "b@example.com"); code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with other data = %d, want 422", code)
+	}
+	code, resp := submit("", "b@example.com")
+	if code != http.StatusConflict || resp.Data.(map[string]interface{})["status"] != "pending" {
+		t.Errorf("duplicate submit = %d %+v, want 409 with status", code, resp)
+	}
+
+	// 已删除的提交可以重新提交，沿用原记录
+	user, _ := s.userRepo.FindByEmail("a@example.com")
+	if err := s.userRepo.Delete(user.ID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if code, resp := submit("", "b@example.com"); code != http.StatusOK {
+		t.Fatalf("resubmit after delete = %d %+v", code, resp)
+	}
+	reactivated, _ := s.userRepo.FindByID(user.ID)
+	if reactivated == nil || reactivated.Email != "b@example.com" || reactivated.Status != model.QueryStatusPending {
+		t.Errorf("reactivated user = %+v", reactivated)
+	}
+
+	// 已结束查询的提交只能由原邮箱重新激活
+	if _, err := s.userRepo.Stop(user.ID); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if code, _ := submit("", "c@example.com"); code != http.StatusConflict {
+		t.Errorf("resubmit of a finished submission with another email = %d, want 409", code)
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Email != "b@example.com" || stored.Status != model.QueryStatusStopped {
+		t.Errorf("finished submission taken over: %q %q", stored.Email, stored.Status)
+	}
+	if code, _ := submit("", "B@example.com"); code != http.StatusOK {
+		t.Errorf("resubmit after stop with the same email = %d", code)
+	}
+
+	// 验证邮件发送失败时原提交保持不变
+	if _, err := s.userRepo.Stop(user.ID); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	s.cfg.EmailVerification = true
+	s.emailSvc = service.NewEmailService(&config.Config{SMTPServer: "127.0.0.1", SMTPPort: 1, SMTPUser: "u", SMTPPass: "p", SMTPTLSMode: "none"})
+	if code, _ := submit("", "b@example.com"); code != http.StatusInternalServerError {
+		t.Errorf("resubmit with failing email = %d, want 500", code)
+	}
+	stored, _ := s.userRepo.FindByID(user.ID)
+	if stored == nil || stored.Status != model.QueryStatusStopped || stored.VerifiedAt == nil {
+		t.Errorf("submission after failed verification email = %+v", stored)
+	}
+}
+
+func TestUnsubscribeNeedsConfirmation(t *testing.T) {
+	s := newTestServer(t)
+	user, _ := newOwnedUser(t, s)
+	if err := s.outbox.Enqueue(&model.Notification{UserID: user.ID, Event: model.NotificationEventDigest, IdempotencyKey: "digest"}); err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/unsubscribe/" + s.tokens.Sign(service.UnsubscribeTokenPurpose, user.ID, time.Hour)
+
+	// Opening the link, e.g. by a mail scanner, only shows the confirmation
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), `method="post"`) {
+		t.Errorf("GET unsubscribe = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Status == model.QueryStatusStopped {
+		t.Fatal("GET unsubscribe stopped the submission")
+	}
+
+	if code, _ := serve(s, http.MethodPost, target, "", strings.NewReader("List-Unsubscribe=One-Click")); code != http.StatusOK {
+		t.Fatalf("POST unsubscribe = %d", code)
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Status != model.QueryStatusStopped {
+		t.Errorf("status after unsubscribe = %q", stored.Status)
+	}
+	if due, _ := s.outbox.FindDue(time.Now(), 10); len(due) != 0 {
+		t.Errorf("%d notifications still queued after unsubscribe", len(due))
+	}
+}
+
+func TestSubmitGeneratesWebhookSecrets(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"name":"张三","id_card":"110101199001011237","exam_id":"100010000000001","email":"a@example.com","school_code":"10001",
+		"webhooks":[{"url":"https://example.com/hook"},{"url":"https://example.com/own","secret":"own-secret"}]}`
+	code, resp := serve(s, http.MethodPost, "/api/submit", "", strings.NewReader(body))
+	if code != http.StatusOK {
+		t.Fatalf("submit = %d %+v", code, resp)
+	}
+	webhooks, _ := resp.Data.(map[string]interface{})["webhooks"].([]interface{})
+	if len(webhooks) != 2 {
+		t.Fatalf("submit response webhooks = %v", resp.Data)
+	}
+	generated := webhooks[0].(map[string]interface{})["secret"].(string)
+	if !strings.HasPrefix(generated, "whsec_") || webhooks[1].(map[string]interface{})["secret"] != "own-secret" {
+		t.Errorf("webhook secrets = %v", webhooks)
+	}
+
+	var endpoint model.WebhookEndpoint
+	s.db.Where("url = ?", "https://example.com/hook").First(&endpoint)
+	if endpoint.Secret != generated {
+		t.Errorf("stored secret = %q, want the one returned", endpoint.Secret)
+	}
+}
+
+func TestResubmitResendsVerification(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.EmailVerification = true
+	submit := func(email string) (int, ScoreResponse) {
+		body := `{"name":"张三","id_card":"110101199001011237","exam_id":"100010000000001","email":"` + email + `","school_code":"10001"}`
+		return serve(s, http.MethodPost, "/api/submit", "", strings.NewReader(body))
+	}
+	if code, resp := submit("a@example.com"); code != http.StatusOK {
+		t.Fatalf("submit = %d %+v", code, resp)
+	}
+
+	// The verification mail got lost, submitting again sends a new one
+	code, resp := submit("A@example.com")
+	if code != http.StatusOK || resp.Data.(map[string]interface{})["message"] != verifyMessage {
+		t.Fatalf("resubmit of an unverified submission = %d %+v", code, resp)
+	}
+	if _, ok := resp.Data.(map[string]interface{})["manage_token"]; ok {
+		t.Error("resubmit handed out a management token")
+	}
+	if code, _ := submit("a@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("second resend within a minute = %d, want 429", code)
+	}
+	if code, _ := submit("b@example.com"); code != http.StatusConflict {
+		t.Errorf("resubmit with another email = %d, want 409", code)
+	}
+}
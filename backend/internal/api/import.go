@@ -0,0 +1,314 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+)
+
+// Outcomes of an imported row
+const (
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportSkipped   = "skipped"
+	ImportInvalid   = "invalid"
+)
+
+// maxImportSize limits the body of POST /api/admin/import
+const maxImportSize = 10 << 20
+
+// importColumns is the field order of inline entries and the recognised CSV
+// header names
+var importColumns = []string{"name", "id_card", "exam_id", "email", "school_code", "locale"}
+
+// importRow is a parsed row, or the reason it could not be parsed
+type importRow struct {
+	req SubmitRequest
+	err error
+}
+
+// ImportResult is the outcome of one row, numbered from 1 without the CSV
+// header. Personal data is left out so the report can be logged.
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	UserID uint   `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarises an import
+type ImportReport struct {
+	Created   int            `json:"created"`
+	Duplicate int            `json:"duplicate"`
+	Skipped   int            `json:"skipped"`
+	Invalid   int            `json:"invalid"`
+	Rows      []ImportResult `json:"rows"`
+}
+
+// importInitialEntries imports INITIAL_USER_ENTRIES at startup. The value is
+// a path to a .csv or .jsonl file, or inline entries separated by ";" or
+// newlines with the fields of importColumns separated by ",". Each entry is
+// imported only once: later starts skip it, even after the user was deleted
+// or purged.
+func (s *Server) importInitialEntries() {
+	entries := strings.TrimSpace(s.cfg.InitialUserEntries)
+	if entries == "" {
+		return
+	}
+
+	var rows []importRow
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(entries)); ext {
+	case ".csv", ".jsonl", ".ndjson":
+		var f *os.File
+		if f, err = os.Open(entries); err == nil {
+			defer f.Close()
+			if ext == ".csv" {
+				rows, err = parseImportCSV(f)
+			} else {
+				rows, err = parseImportJSONL(f)
+			}
+		}
+	default:
+		rows = parseImportInline(entries)
+	}
+	if err != nil {
+		logger.Error("Failed to read INITIAL_USER_ENTRIES: %v", err)
+		return
+	}
+
+	report, err := s.importRows(rows)
+	if err != nil {
+		logger.Error("Initial user import aborted: %v", err)
+	}
+	for _, row := range report.Rows {
+		if row.Status != ImportCreated {
+			logger.Warn("Initial user entry %d: %s %s", row.Row, row.Status, row.Error)
+		}
+	}
+	logger.Info("Initial user import finished [Created: %d, Duplicate: %d, Skipped: %d, Invalid: %d]",
+		report.Created, report.Duplicate, report.Skipped, report.Invalid)
+}
+
+// handleImport imports users from a CSV (text/csv), JSON Lines
+// (application/x-ndjson) or JSON array (application/json) body
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var rows []importRow
+	var err error
+	switch mediaType {
+	case "text/csv":
+		rows, err = parseImportCSV(body)
+	case "application/x-ndjson", "application/jsonl":
+		rows, err = parseImportJSONL(body)
+	case "application/json":
+		var reqs []SubmitRequest
+		if err = json.NewDecoder(body).Decode(&reqs); err == nil {
+			for _, req := range reqs {
+				rows = append(rows, importRow{req: req})
+			}
+		}
+	default:
+		respondError(w, http.StatusUnsupportedMediaType, "Content-Type must be text/csv, application/x-ndjson or application/json")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid import file: "+err.Error())
+		return
+	}
+
+	report, err := s.importRows(rows)
+	if err != nil {
+		// 已创建的用户不会回滚，返回已处理部分的报告
+		logger.Error("Admin import aborted after %d rows: %v", len(report.Rows), err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ScoreResponse{Code: http.StatusInternalServerError, Message: "Database error", Data: report})
+		return
+	}
+
+	logger.Info("Admin import finished [Created: %d, Duplicate: %d, Skipped: %d, Invalid: %d]",
+		report.Created, report.Duplicate, report.Skipped, report.Invalid)
+	respondSuccess(w, report)
+}
+
+// importRows stores each row with the validation and InfoHash deduplication
+// of POST /api/submit. Imported users are added by the operator and count as
+// verified. Entries handled by an earlier import are skipped, so users that
+// were deleted or purged since are not created again. A database error stops
+// the import; the report then covers the rows handled so far.
+func (s *Server) importRows(rows []importRow) (*ImportReport, error) {
+	report := &ImportReport{Rows: make([]ImportResult, 0, len(rows))}
+	add := func(result ImportResult) {
+		switch result.Status {
+		case ImportCreated:
+			report.Created++
+		case ImportDuplicate:
+			report.Duplicate++
+		case ImportSkipped:
+			report.Skipped++
+		case ImportInvalid:
+			report.Invalid++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	for i, row := range rows {
+		result := ImportResult{Row: i + 1}
+		if row.err != nil {
+			result.Status, result.Error = ImportInvalid, row.err.Error()
+			add(result)
+			continue
+		}
+
+		user, err := s.newSubmission(row.req)
+		if err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+			add(result)
+			continue
+		}
+
+		imported, err := s.userRepo.WasImported(user.InfoHash)
+		if err != nil {
+			return report, err
+		}
+		if imported {
+			result.Status = ImportSkipped
+			add(result)
+			continue
+		}
+
+		exists, err := s.userRepo.InfoHashExists(user.InfoHash)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			// 已有的提交被删除后同样不再导入
+			if err := s.userRepo.MarkImported(user.InfoHash); err != nil {
+				return report, err
+			}
+			result.Status = ImportDuplicate
+			add(result)
+			continue
+		}
+
+		now := time.Now()
+		user.VerifiedAt = &now
+		if err := s.userRepo.CreateImported(user); err != nil {
+			return report, err
+		}
+		result.Status, result.UserID = ImportCreated, user.ID
+		add(result)
+	}
+	return report, nil
+}
+
+// parseImportCSV reads a CSV file whose header names the columns
+func parseImportCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range importColumns[:5] {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("header is missing column %q", required)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, importRow{req: SubmitRequest{
+			Name:       field("name"),
+			IDCard:     field("id_card"),
+			ExamID:     field("exam_id"),
+			Email:      field("email"),
+			SchoolCode: field("school_code"),
+			Locale:     field("locale"),
+		}})
+	}
+}
+
+// parseImportJSONL reads one submission object per line; blank lines are skipped
+func parseImportJSONL(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var rows []importRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req SubmitRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			rows = append(rows, importRow{err: fmt.Errorf("invalid JSON: %w", err)})
+			continue
+		}
+		rows = append(rows, importRow{req: req})
+	}
+	return rows, scanner.Err()
+}
+
+// parseImportInline reads entries separated by ";" or newlines, each with
+// the fields of importColumns in order, separated by ","
+func parseImportInline(entries string) []importRow {
+	var rows []importRow
+	for _, entry := range strings.FieldsFunc(entries, func(r rune) bool { return r == ';' || r == '\n' }) {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		if len(fields) < 5 || len(fields) > len(importColumns) {
+			rows = append(rows, importRow{err: fmt.Errorf("expected fields %s", strings.Join(importColumns, ","))})
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		fields = append(fields, make([]string, len(importColumns)-len(fields))...)
+		rows = append(rows, importRow{req: SubmitRequest{
+			Name:       fields[0],
+			IDCard:     fields[1],
+			ExamID:     fields[2],
+			Email:      fields[3],
+			SchoolCode: fields[4],
+			Locale:     fields[5],
+		}})
+	}
+	return rows
+}
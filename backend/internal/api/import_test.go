@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	database "chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	s := NewServer(&config.Config{AppSecret: "test-secret", ManageLinkTTL: 3600}, db)
+	s.registerRoutes()
+	return s
+}
+
+func TestParseImport(t *testing.T) {
+	csvRows, err := parseImportCSV(strings.NewReader("\ufeffemail,name,id_card,exam_id,school_code\na@example.com,张三,110101199001011237,100010000000001,10001\n"))
+	if err != nil || len(csvRows) != 1 || csvRows[0].req.Name != "张三" || csvRows[0].req.Email != "a@example.com" {
+		t.Errorf("parseImportCSV() = %+v, %v", csvRows, err)
+	}
+	if _, err := parseImportCSV(strings.NewReader("name,email\n")); err == nil {
+		t.Error("parseImportCSV() accepted a header without required columns")
+	}
+
+	jsonRows, err := parseImportJSONL(strings.NewReader("{\"name\":\"张三\",\"locale\":\"en\"}\n\nnot json\n"))
+	if err != nil || len(jsonRows) != 2 || jsonRows[0].req.Locale != "en" || jsonRows[1].err == nil {
+		t.Errorf("parseImportJSONL() = %+v, %v", jsonRows, err)
+	}
+
+	inline := parseImportInline("张三,110101199001011237,100010000000001,a@example.com,10001;李四,1,2\n")
+	if len(inline) != 2 || inline[0].req.SchoolCode != "10001" || inline[1].err == nil {
+		t.Errorf("parseImportInline() = %+v", inline)
+	}
+}
+
+func TestImportRows(t *testing.T) {
+	s := newTestServer(t)
+	rows := parseImportInline(strings.Join([]string{
+		"张三,110101199001011237,100010000000001,a@example.com,10001",
+		"张三,110101199001011237,100010000000001,a@example.com,10001",
+		"李四,110101199003076632,100010000000002,b@example.com,10001",
+		"王五,11010519491231002X,100010000000003,,10001",
+		"李四,110101199003076632,100010000000002,b@example.com,10001,fr",
+	}, ";"))
+
+	// 李四 already submitted through the form
+	existing, err := s.newSubmission(rows[2].req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.userRepo.Create(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.importRows(rows)
+	if err != nil {
+		t.Fatalf("importRows() error = %v", err)
+	}
+	if report.Created != 1 || report.Skipped != 1 || report.Duplicate != 1 || report.Invalid != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.Rows[1].Status != ImportSkipped || report.Rows[2].Status != ImportDuplicate || report.Rows[4].Error != "Unsupported locale" {
+		t.Errorf("rows = %+v", report.Rows)
+	}
+
+	user, _ := s.userRepo.FindByID(report.Rows[0].UserID)
+	if user == nil || user.VerifiedAt == nil {
+		t.Errorf("imported user = %+v, want verified", user)
+	}
+
+	// Deleted users are not created again by a later import
+	if err := s.userRepo.HardDelete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.userRepo.HardDelete(existing.ID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.importRows(rows)
+	if err != nil || again.Created != 0 || again.Skipped != 3 {
+		t.Errorf("second import = %+v, %v", again, err)
+	}
+}
+
+func TestImportReportsPartialProgressOnDatabaseError(t *testing.T) {
+	s := newTestServer(t)
+	// The second insert fails
+	s.db.Exec("CREATE TRIGGER fail_second BEFORE INSERT ON users WHEN (SELECT COUNT(*) FROM users) > 0 " +
+		"BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+
+	body := `[{"name":"张三","id_card":"110101199001011237","exam_id":"100010000000001","email":"a@example.com","school_code":"10001"},
+		{"name":"李四","id_card":"110101199003076632","exam_id":"100010000000002","email":"b@example.com","school_code":"10001"}]`
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.handleImport(w, r)
+
+	var resp struct {
+		Data ImportReport `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if resp.Data.Created != 1 || len(resp.Data.Rows) != 1 || resp.Data.Rows[0].UserID == 0 {
+		t.Errorf("partial report = %+v", resp.Data)
+	}
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/mail"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/internal/secure"
+	"chsi-auto-score-query/internal/service"
+	"chsi-auto-score-query/internal/validate"
+)
+
+const manageTokenPurpose = "manage"
+
+// manageLinkSendTimeout bounds sending the management links of one request
+const manageLinkSendTimeout = time.Minute
+
+type ManageLinkRequest struct {
+	Email string `json:"email"`
+}
+
+// UpdateRequest lists the fields to change; omitted fields are kept
+type UpdateRequest struct {
+	Name       *string `json:"name"`
+	IDCard     *string `json:"id_card"`
+	ExamID     *string `json:"exam_id"`
+	Email      *string `json:"email"`
+	SchoolCode *string `json:"school_code"`
+	Locale     *string `json:"locale"`
+}
+
+// NotificationsRequest changes who is notified and about which events;
+// omitted fields are kept and an empty recipients list removes them all
+type NotificationsRequest struct {
+	Recipients *[]string             `json:"recipients"`
+	Notify     *NotificationSettings `json:"notify"`
+}
+
+// ownerHandler handles a request for the submission its caller owns
+type ownerHandler func(w http.ResponseWriter, r *http.Request, user *model.User)
+
+// requireOwner resolves the submission the caller proves ownership of. The
+// token is either a management link from POST /api/manage/link or the
+// management token returned at submit, sent as a bearer token or as the
+// token query parameter of the link.
+func (s *Server) requireOwner(next ownerHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		token = strings.TrimSpace(token)
+		if token == "" {
+			respondError(w, http.StatusUnauthorized, "Management token required")
+			return
+		}
+
+		var user *model.User
+		var err error
+		if strings.Contains(token, ".") {
+			id, verifyErr := s.tokens.Verify(manageTokenPurpose, token)
+			if errors.Is(verifyErr, service.ErrTokenExpired) {
+				respondError(w, http.StatusUnauthorized, "Management link has expired, please request a new one")
+				return
+			}
+			if verifyErr == nil {
+				user, err = s.userRepo.FindByID(id)
+			}
+		} else {
+			user, err = s.userRepo.FindByManageToken(service.HashManageToken(token))
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if user == nil {
+			respondError(w, http.StatusUnauthorized, "Invalid management token")
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+// handleManageLink emails a management link for every submission of the
+// address. The response is the same whether or not any submission exists:
+// links are sent in the background so the response time does not tell, and
+// each address gets at most one link mail per minute.
+func (s *Server) handleManageLink(w http.ResponseWriter, r *http.Request) {
+	var req ManageLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		respondError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+
+	users, err := s.userRepo.FindAllByEmail(email)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if ok, _ := s.linkLimiter.Allow(strings.ToLower(email)); ok && len(users) > 0 {
+		s.background.Add(1)
+		go func() {
+			defer s.background.Done()
+			s.sendManageLinks(users)
+		}()
+	}
+
+	respondSuccess(w, map[string]interface{}{
+		"message": "If a submission exists for this email, a management link has been sent.",
+	})
+}
+
+// sendManageLinks mails a management link for each submission
+func (s *Server) sendManageLinks(users []model.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), manageLinkSendTimeout)
+	defer cancel()
+
+	ttl := time.Duration(s.cfg.ManageLinkTTL) * time.Second
+	for _, user := range users {
+		token := s.tokens.Sign(manageTokenPurpose, user.ID, ttl)
+		link := strings.TrimRight(s.cfg.PublicBaseURL, "/") + "/api/manage?token=" + token
+		if err := s.emailSvc.SendManageLink(ctx, user.Email, user.Name, user.Locale, link, time.Now().Add(ttl)); err != nil {
+			logger.Error("Failed to send management link to user %d: %v", user.ID, err)
+		}
+	}
+}
+
+func (s *Server) handleGetSubmission(w http.ResponseWriter, r *http.Request, user *model.User) {
+	respondSuccess(w, submissionView(user))
+}
+
+// handleUpdateSubmission corrects submitted data. Changed fields are
+// validated like a new submission. Changing the name, ID card, exam ID or
+// school code recalculates the info hash and restarts querying; a new email
+// address has to be verified again.
+func (s *Server) handleUpdateSubmission(w http.ResponseWriter, r *http.Request, user *model.User) {
+	var req UpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	requery := false
+	fields := []struct {
+		name     string
+		value    *string
+		target   *string
+		identity bool
+	}{
+		{"name", req.Name, &user.Name, true},
+		{"id_card", req.IDCard, &user.IDCard, true},
+		{"exam_id", req.ExamID, &user.ExamID, true},
+		{"school_code", req.SchoolCode, &user.SchoolCode, true},
+		{"email", req.Email, &user.Email, false},
+	}
+	emailChanged := false
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*f.value)
+		if f.name == "id_card" {
+			value = validate.NormalizeIDCard(value)
+		}
+		if value == *f.target {
+			continue
+		}
+		*f.target = value
+		if f.identity {
+			requery = true
+		} else {
+			emailChanged = true
+		}
+	}
+	if requery || emailChanged {
+		if err := (validate.Submission{
+			Name:       user.Name,
+			IDCard:     user.IDCard,
+			ExamID:     user.ExamID,
+			Email:      user.Email,
+			SchoolCode: user.SchoolCode,
+		}).Check(); err != nil {
+			respondInvalid(w, err)
+			return
+		}
+	}
+	if req.Locale != nil {
+		if !mail.SupportedLocale(*req.Locale) {
+			respondError(w, http.StatusBadRequest, "Unsupported locale")
+			return
+		}
+		user.Locale = mail.MatchLocale(*req.Locale)
+	}
+
+	if requery {
+		status := user.Status
+		if status == "" {
+			status = model.QueryStatusPending
+		}
+		if !status.CanTransitionTo(model.QueryStatusPending) {
+			respondError(w, http.StatusConflict, "Submission already has a result and cannot be changed")
+			return
+		}
+
+		infoHash := secure.InfoHash(user.Name, user.IDCard, user.ExamID)
+		existing, err := s.userRepo.FindAnyByInfoHash(infoHash)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if existing != nil && existing.ID != user.ID {
+			respondError(w, http.StatusConflict, "Another submission with this information already exists")
+			return
+		}
+		user.InfoHash = infoHash
+	}
+
+	// 新邮箱需要重新验证，验证邮件发送失败时不保存修改
+	if emailChanged && s.cfg.EmailVerification {
+		user.VerifiedAt = nil
+		if err := s.sendVerification(r.Context(), user); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to send verification email")
+			return
+		}
+	}
+
+	if err := s.userRepo.UpdateInfo(user, requery); errors.Is(err, repo.ErrStatusChanged) {
+		respondError(w, http.StatusConflict, "Submission already has a result and cannot be changed")
+		return
+	} else if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update submission")
+		return
+	}
+
+	logger.Info("User %d updated their submission (requery: %v, email changed: %v)", user.ID, requery, emailChanged)
+	respondSuccess(w, submissionView(user))
+}
+
+func (s *Server) handlePauseSubmission(w http.ResponseWriter, r *http.Request, user *model.User) {
+	user, err := s.userRepo.Stop(user.ID)
+	if errors.Is(err, repo.ErrStatusChanged) {
+		respondError(w, http.StatusConflict, "Submission is being updated, please try again")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to pause querying")
+		return
+	}
+	if user == nil {
+		respondError(w, http.StatusNotFound, "Submission not found")
+		return
+	}
+
+	logger.Info("User %d paused querying", user.ID)
+	respondSuccess(w, submissionView(user))
+}
+
+func (s *Server) handleResumeSubmission(w http.ResponseWriter, r *http.Request, user *model.User) {
+	user, err := s.userRepo.Resume(user.ID)
+	var transitionErr *model.InvalidTransitionError
+	if errors.As(err, &transitionErr) {
+		respondError(w, http.StatusConflict, "Querying cannot be resumed in the current state")
+		return
+	}
+	if errors.Is(err, repo.ErrStatusChanged) {
+		respondError(w, http.StatusConflict, "Submission is being updated, please try again")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to resume querying")
+		return
+	}
+	if user == nil {
+		respondError(w, http.StatusNotFound, "Submission not found")
+		return
+	}
+
+	logger.Info("User %d resumed querying", user.ID)
+	respondSuccess(w, submissionView(user))
+}
+
+// handleDeleteSubmission permanently removes the submission and its channels
+func (s *Server) handleDeleteSubmission(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if err := s.userRepo.HardDelete(user.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete submission")
+		return
+	}
+
+	logger.Info("User %d deleted their submission", user.ID)
+	respondSuccess(w, map[string]interface{}{
+		"user_id": user.ID,
+		"message": "Your submission and personal data have been deleted.",
+	})
+}
+
+func (s *Server) handleGetNotificationSettings(w http.ResponseWriter, r *http.Request, user *model.User) {
+	s.respondNotificationSettings(w, user)
+}
+
+// handleUpdateNotificationSettings replaces the additional recipients and
+// changes the notification preference of the submission
+func (s *Server) handleUpdateNotificationSettings(w http.ResponseWriter, r *http.Request, user *model.User) {
+	var req NotificationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	var recipients []model.Recipient
+	if req.Recipients != nil {
+		emails, fieldErr := validate.Recipients(user.Email, *req.Recipients)
+		if fieldErr != nil {
+			respondInvalid(w, validate.Errors{fieldErr})
+			return
+		}
+		recipients = s.newRecipients(emails)
+	}
+	var pref *model.NotificationPreference
+	if req.Notify != nil {
+		var err error
+		if pref, err = s.userRepo.FindPreference(user.ID); err != nil {
+			respondError(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		req.Notify.apply(pref)
+	}
+
+	added, err := s.userRepo.SaveNotificationSettings(user.ID, pref, recipients)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update notification settings")
+		return
+	}
+	if user.VerifiedAt != nil {
+		s.sendRecipientVerifications(user, added)
+	}
+
+	logger.Info("User %d updated their notification settings", user.ID)
+	s.respondNotificationSettings(w, user)
+}
+
+func (s *Server) respondNotificationSettings(w http.ResponseWriter, user *model.User) {
+	recipients, err := s.userRepo.FindRecipients(user.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	pref, err := s.userRepo.FindPreference(user.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	emails := make([]string, len(recipients))
+	unconfirmed := []string{}
+	for i, recipient := range recipients {
+		emails[i] = recipient.Email
+		if recipient.VerifiedAt == nil {
+			unconfirmed = append(unconfirmed, recipient.Email)
+		}
+	}
+	respondSuccess(w, map[string]interface{}{
+		"email":      user.Email,
+		"recipients": emails,
+		// 尚未确认的收件人不会收到通知
+		"unconfirmed_recipients": unconfirmed,
+		"notify": map[string]bool{
+			"score_released": pref.ScoreReleased,
+			"status_change":  pref.StatusChange,
+			"daily_digest":   pref.DailyDigest,
+		},
+	})
+}
+
+// handleQueryHistory lists the CHSI queries made for the submission, newest
+// first. Error details and page snapshots are left to the admin API.
+func (s *Server) handleQueryHistory(w http.ResponseWriter, r *http.Request, user *model.User) {
+	page, pageSize := pagination(r)
+	attempts, total, err := s.attempts.ListByUser(user.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	items := make([]map[string]interface{}, len(attempts))
+	for i, attempt := range attempts {
+		items[i] = map[string]interface{}{
+			"started_at":  attempt.StartedAt,
+			"duration_ms": attempt.DurationMs,
+			"http_status": attempt.HTTPStatus,
+			"status":      attempt.Status,
+			"error_class": attempt.ErrorClass,
+		}
+	}
+	respondSuccess(w, map[string]interface{}{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// submissionView is what the owner sees of a submission. The ID card number
+// is masked since the token may travel in a link.
+func submissionView(user *model.User) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":     user.ID,
+		"name":        user.Name,
+		"id_card":     secure.MaskID(user.IDCard),
+		"exam_id":     user.ExamID,
+		"email":       user.Email,
+		"school_code": user.SchoolCode,
+		"locale":      user.Locale,
+		"verified":    user.VerifiedAt != nil,
+		"status":      user.Status,
+		"notice":      user.Notice,
+		"score":       user.Score,
+		"query_time":  user.LastQueryAt,
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/service"
+)
+
+// newOwnedUser stores a verified submission and returns its management token
+func newOwnedUser(t *testing.T, s *Server) (*model.User, string) {
+	t.Helper()
+	token, hash := service.NewManageToken()
+	now := time.Now()
+	user := &model.User{
+		Name: "张三", IDCard: "110101199001011237", ExamID: "100010000000001", SchoolCode: "10001",
+		Email: "a@example.com", InfoHash: "h1", ManageTokenHash: hash, VerifiedAt: &now,
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	return user, token
+}
+
+// serve sends a request through the routes and decodes the response
+func serve(s *Server, method, target, token string, body io.Reader) (int, ScoreResponse) {
+	r := httptest.NewRequest(method, target, body)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	var resp ScoreResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	return w.Code, resp
+}
+
+func TestRequireOwner(t *testing.T) {
+	s := newTestServer(t)
+	user, token := newOwnedUser(t, s)
+
+	if code, _ := serve(s, http.MethodGet, "/api/manage", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("without token = %d, want 401", code)
+	}
+	if code, _ := serve(s, http.MethodGet, "/api/manage", "wrong", nil); code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d, want 401", code)
+	}
+	expired := s.tokens.Sign(manageTokenPurpose, user.ID, -time.Minute)
+	if code, _ := serve(s, http.MethodGet, "/api/manage", expired, nil); code != http.StatusUnauthorized {
+		t.Errorf("expired link = %d, want 401", code)
+	}
+	verify := s.tokens.Sign("verify", user.ID, time.Hour)
+	if code, _ := serve(s, http.MethodGet, "/api/manage", verify, nil); code != http.StatusUnauthorized {
+		t.Errorf("token of another purpose = %d, want 401", code)
+	}
+
+	code, resp := serve(s, http.MethodGet, "/api/manage", token, nil)
+	if code != http.StatusOK || resp.Data.(map[string]interface{})["id_card"] != "**************1237" {
+		t.Errorf("management token = %d %+v", code, resp)
+	}
+	link := s.tokens.Sign(manageTokenPurpose, user.ID, time.Hour)
+	if code, _ := serve(s, http.MethodGet, "/api/manage?token="+link, "", nil); code != http.StatusOK {
+		t.Errorf("management link = %d, want 200", code)
+	}
+}
+
+func TestManageSubmission(t *testing.T) {
+	s := newTestServer(t)
+	user, token := newOwnedUser(t, s)
+
+	code, resp := serve(s, http.MethodPatch, "/api/manage", token, strings.NewReader(`{"exam_id":"100010000000002"}`))
+	if code != http.StatusOK {
+		t.Fatalf("PATCH = %d %+v", code, resp)
+	}
+	stored, _ := s.userRepo.FindByID(user.ID)
+	if stored.ExamID != "100010000000002" || stored.InfoHash == user.InfoHash || stored.Status != model.QueryStatusPending {
+		t.Errorf("updated user = %q %q %q", stored.ExamID, stored.InfoHash, stored.Status)
+	}
+	if code, resp := serve(s, http.MethodPatch, "/api/manage", token, strings.NewReader(`{"exam_id":"200010000000002"}`)); code != http.StatusBadRequest || len(resp.Errors) != 1 {
+		t.Errorf("PATCH invalid exam id = %d %+v, want 400", code, resp)
+	}
+
+	if code, _ := serve(s, http.MethodPost, "/api/manage/pause", token, nil); code != http.StatusOK {
+		t.Errorf("pause = %d", code)
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Status != model.QueryStatusStopped {
+		t.Errorf("status after pause = %q", stored.Status)
+	}
+	if code, _ := serve(s, http.MethodPost, "/api/manage/resume", token, nil); code != http.StatusOK {
+		t.Errorf("resume = %d", code)
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Status != model.QueryStatusPending {
+		t.Errorf("status after resume = %q", stored.Status)
+	}
+	s.db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", model.QueryStatusAdmitted)
+	if code, _ := serve(s, http.MethodPost, "/api/manage/resume", token, nil); code != http.StatusConflict {
+		t.Errorf("resume after admission = %d, want 409", code)
+	}
+
+	if code, _ := serve(s, http.MethodDelete, "/api/manage", token, nil); code != http.StatusOK {
+		t.Errorf("delete = %d", code)
+	}
+	var count int64
+	s.db.Unscoped().Model(&model.User{}).Where("id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Error("deleted submission is still stored")
+	}
+	if code, _ := serve(s, http.MethodGet, "/api/manage", token, nil); code != http.StatusUnauthorized {
+		t.Errorf("token after delete = %d, want 401", code)
+	}
+}
+
+func TestManageLinkRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.manageLimiter.perMinute = 2
+	newOwnedUser(t, s)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		code, _ := serve(s, http.MethodPost, "/api/manage/link", "", strings.NewReader(`{"email":"a@example.com"}`))
+		if code != want {
+			t.Errorf("request %d = %d, want %d", i+1, code, want)
+		}
+	}
+	s.background.Wait()
+}
+
+func TestRecipientLinks(t *testing.T) {
+	s := newTestServer(t)
+	s.cfg.EmailVerification = true
+	user, token := newOwnedUser(t, s)
+
+	code, resp := serve(s, http.MethodPut, "/api/manage/notifications", token, strings.NewReader(`{"recipients":["b@example.com"]}`))
+	if code != http.StatusOK {
+		t.Fatalf("update recipients = %d %+v", code, resp)
+	}
+	s.background.Wait()
+	if unconfirmed := resp.Data.(map[string]interface{})["unconfirmed_recipients"].([]interface{}); len(unconfirmed) != 1 {
+		t.Errorf("unconfirmed recipients = %v", unconfirmed)
+	}
+	recipients, _ := s.userRepo.FindRecipients(user.ID)
+	if len(recipients) != 1 || recipients[0].VerifiedAt != nil {
+		t.Fatalf("recipients = %+v, want one unconfirmed", recipients)
+	}
+	id := recipients[0].ID
+
+	if code, _ := serve(s, http.MethodGet, "/api/recipients/verify/"+s.tokens.Sign(verifyTokenPurpose, id, time.Hour), "", nil); code != http.StatusBadRequest {
+		t.Errorf("verify with a submission token = %d, want 400", code)
+	}
+	if code, _ := serve(s, http.MethodGet, "/api/recipients/verify/"+s.tokens.Sign(recipientVerifyTokenPurpose, id, time.Hour), "", nil); code != http.StatusOK {
+		t.Errorf("verify recipient = %d", code)
+	}
+	if recipients, _ := s.userRepo.FindRecipients(user.ID); recipients[0].VerifiedAt == nil {
+		t.Error("recipient not confirmed")
+	}
+
+	// The owner's one-click unsubscribe link does not work for recipients
+	if code, _ := serve(s, http.MethodPost, "/api/recipients/unsubscribe/"+s.tokens.Sign(service.UnsubscribeTokenPurpose, id, time.Hour), "", nil); code != http.StatusBadRequest {
+		t.Errorf("unsubscribe with the owner's token = %d, want 400", code)
+	}
+	unsubscribe := "/api/recipients/unsubscribe/" + s.tokens.Sign(service.RecipientUnsubscribeTokenPurpose, id, time.Hour)
+	// Opening the link only shows the confirmation page
+	if code, _ := serve(s, http.MethodGet, unsubscribe, "", nil); code != http.StatusOK {
+		t.Errorf("GET recipient unsubscribe = %d", code)
+	}
+	if recipients, _ := s.userRepo.FindRecipients(user.ID); len(recipients) != 1 {
+		t.Fatalf("GET recipient unsubscribe removed the recipient")
+	}
+	if code, _ := serve(s, http.MethodPost, unsubscribe, "", nil); code != http.StatusOK {
+		t.Errorf("unsubscribe recipient = %d", code)
+	}
+	if recipients, _ := s.userRepo.FindRecipients(user.ID); len(recipients) != 0 {
+		t.Errorf("recipients after unsubscribe = %+v", recipients)
+	}
+	if stored, _ := s.userRepo.FindByID(user.ID); stored.Status != model.QueryStatusPending {
+		t.Errorf("submission status after recipient unsubscribe = %q", stored.Status)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+
+	"chsi-auto-score-query/internal/logger"
+)
+
+// confirmPage asks the reader of an emailed link to confirm the action. Mail
+// scanners and prefetchers open links without anyone clicking, so GET only
+// shows this page and the form posts to the same URL to act.
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+<form method="post">
+<button type="submit">{{.Button}}</button>
+</form>
+</body>
+</html>
+`))
+
+// confirmData fills confirmPage
+type confirmData struct {
+	Title   string
+	Message string
+	Button  string
+}
+
+// renderConfirm writes confirmPage
+func renderConfirm(w http.ResponseWriter, data confirmData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := confirmPage.Execute(w, data); err != nil {
+		logger.Error("Failed to render confirmation page: %v", err)
+	}
+}
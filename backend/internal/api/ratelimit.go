@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ipLimiter allows each client IP a burst of perMinute requests, refilled
+// evenly over a minute. A non-positive rate disables limiting.
+type ipLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPLimiter(perMinute int) *ipLimiter {
+	return &ipLimiter{perMinute: perMinute, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for ip. When none is left it returns false and how
+// long until the next one.
+func (l *ipLimiter) Allow(ip string) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+	rate := float64(l.perMinute) / float64(time.Minute)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(l.perMinute) {
+		b.tokens = float64(l.perMinute)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets clients whose bucket has been full again for a while
+func (l *ipLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// limit rejects requests over the client's rate with 429 Too Many Requests
+func (s *Server) limit(limiter *ipLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := limiter.Allow(s.clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)+1))
+			respondError(w, http.StatusTooManyRequests, "Too many requests, please try again later")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP returns the address of the client. Proxy headers are only used
+// when TRUST_PROXY is set, since clients can send them freely. The proxy
+// appends the address it received the request from to X-Forwarded-For, so
+// only the right-most entry is trusted; the ones before it come from the
+// client.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.cfg.TrustProxy {
+		forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+		if i := strings.LastIndex(forwarded, ","); i >= 0 {
+			forwarded = forwarded[i+1:]
+		}
+		if forwarded = strings.TrimSpace(forwarded); forwarded != "" {
+			return forwarded
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
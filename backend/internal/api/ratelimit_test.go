@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/pkg/config"
+)
+
+func TestIPLimiter(t *testing.T) {
+	limiter := newIPLimiter(3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d rejected within the burst", i+1)
+		}
+	}
+	ok, retry := limiter.Allow("1.2.3.4")
+	if ok || retry <= 0 || retry > 20*time.Second {
+		t.Errorf("Allow() over the limit = %v, %v; want false and about 20s", ok, retry)
+	}
+	if ok, _ := limiter.Allow("5.6.7.8"); !ok {
+		t.Error("other IP rejected")
+	}
+
+	if ok, _ := newIPLimiter(0).Allow("1.2.3.4"); !ok {
+		t.Error("disabled limiter rejected a request")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/score", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+
+	s := &Server{cfg: &config.Config{}}
+	if ip := s.clientIP(r); ip != "10.0.0.1" {
+		t.Errorf("clientIP() = %q, want the remote address", ip)
+	}
+	s.cfg.TrustProxy = true
+	for _, tc := range []struct {
+		name      string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"proxy entry", []string{"1.2.3.4"}, "", "1.2.3.4"},
+		// The client sent its own X-Forwarded-For, the proxy appended the real address
+		{"spoofed entries", []string{"9.9.9.9, 8.8.8.8, 1.2.3.4"}, "", "1.2.3.4"},
+		{"several headers", []string{"9.9.9.9", "1.2.3.4"}, "", "1.2.3.4"},
+		{"trailing empty entry", []string{"1.2.3.4, "}, "5.6.7.8", "5.6.7.8"},
+		{"real ip", nil, "5.6.7.8", "5.6.7.8"},
+		{"no headers", nil, "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("POST", "/api/score", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		for _, v := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if ip := s.clientIP(r); ip != tc.want {
+			t.Errorf("clientIP(%s) behind proxy = %q, want %q", tc.name, ip, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/service"
+)
+
+const recipientVerifyTokenPurpose = "recipient_verify"
+
+// recipientVerificationSendTimeout bounds sending the confirmation requests
+// for the recipients of one submission
+const recipientVerificationSendTimeout = time.Minute
+
+// newRecipients builds the additional recipients of a submission. Like the
+// submission's own address, they have to confirm before they are notified
+// unless email verification is disabled.
+func (s *Server) newRecipients(emails []string) []model.Recipient {
+	if emails == nil {
+		return nil
+	}
+	var verifiedAt *time.Time
+	if !s.cfg.EmailVerification {
+		now := time.Now()
+		verifiedAt = &now
+	}
+	recipients := make([]model.Recipient, len(emails))
+	for i, email := range emails {
+		recipients[i] = model.Recipient{Email: email, VerifiedAt: verifiedAt}
+	}
+	return recipients
+}
+
+// sendRecipientVerifications asks the unconfirmed recipients of a verified
+// submission to opt in. The emails are sent in the background.
+func (s *Server) sendRecipientVerifications(user *model.User, recipients []model.Recipient) {
+	var pending []model.Recipient
+	for _, recipient := range recipients {
+		if recipient.VerifiedAt == nil {
+			pending = append(pending, recipient)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), recipientVerificationSendTimeout)
+		defer cancel()
+
+		ttl := time.Duration(s.cfg.VerifyTokenTTL) * time.Second
+		for _, recipient := range pending {
+			token := s.tokens.Sign(recipientVerifyTokenPurpose, recipient.ID, ttl)
+			link := strings.TrimRight(s.cfg.PublicBaseURL, "/") + "/api/recipients/verify/" + token
+			if err := s.emailSvc.SendRecipientVerification(ctx, recipient.Email, user.Email, user.Locale, link, time.Now().Add(ttl)); err != nil {
+				logger.Error("Failed to send recipient verification email to %s: %v", recipient.Email, err)
+			}
+		}
+	}()
+}
+
+// handleVerifyRecipient confirms an additional recipient, who is notified
+// from then on
+func (s *Server) handleVerifyRecipient(w http.ResponseWriter, r *http.Request) {
+	id, err := s.tokens.Verify(recipientVerifyTokenPurpose, r.PathValue("token"))
+	if errors.Is(err, service.ErrTokenExpired) {
+		respondError(w, http.StatusGone, "Confirmation link has expired, please ask for the recipient to be added again")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid confirmation link")
+		return
+	}
+
+	recipient, err := s.userRepo.VerifyRecipient(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if recipient == nil {
+		respondError(w, http.StatusNotFound, "Recipient not found")
+		return
+	}
+
+	logger.Info("Recipient %d of user %d confirmed", recipient.ID, recipient.UserID)
+	respondSuccess(w, map[string]interface{}{
+		"message": "Your email has been confirmed. You'll receive the notifications of this submission.",
+	})
+}
+
+// handleRecipientUnsubscribe serves the List-Unsubscribe link of emails sent
+// to additional recipients. GET only shows a confirmation page; POST removes
+// that recipient, the submission keeps being queried and its owner keeps
+// being notified.
+func (s *Server) handleRecipientUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	id, err := s.tokens.Verify(service.RecipientUnsubscribeTokenPurpose, r.PathValue("token"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid unsubscribe link")
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderConfirm(w, confirmData{
+			Title:   "退订成绩通知 / Unsubscribe",
+			Message: "确认后将不再收到该提交的通知。Confirm to stop receiving the notifications of this submission.",
+			Button:  "确认退订 / Unsubscribe",
+		})
+		return
+	}
+
+	recipient, err := s.userRepo.DeleteRecipient(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+	if recipient == nil {
+		respondError(w, http.StatusNotFound, "Recipient not found")
+		return
+	}
+
+	logger.Info("Recipient %d of user %d unsubscribed", recipient.ID, recipient.UserID)
+	respondSuccess(w, map[string]interface{}{
+		"message": "You have been unsubscribed. You will not receive further notifications of this submission.",
+	})
+}
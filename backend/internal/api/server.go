@@ -1,14 +1,18 @@
 package api
 
 import (
-"fmt"
-"net/http"
-
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/internal/repo"
-"chsi-auto-score-query/internal/service"
-"chsi-auto-score-query/pkg/config"
-"gorm.io/gorm"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/internal/service"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
 )
 
 type Server struct {
@@ -16,40 +20,141 @@ type Server struct {
 	db        *gorm.DB
 	userRepo  *repo.UserRepo
 	scheduler *service.Scheduler
-	mux       *http.ServeMux
+	emailSvc  *service.EmailService
+	tokens    *service.TokenSigner
+	notifiers *notify.Registry
+	webhooks  *service.WebhookService
+	outbox    *repo.NotificationRepo
+	attempts  *repo.QueryAttemptRepo
+	// scoreLimiter limits score lookups per client IP
+	scoreLimiter *ipLimiter
+	// manageLimiter limits management link requests per client IP,
+	// linkLimiter the link mails sent to one address
+	manageLimiter *ipLimiter
+	linkLimiter   *ipLimiter
+	// background tracks mails sent after the response
+	background sync.WaitGroup
+	mux        *http.ServeMux
+	httpServer *http.Server
 }
 
 func NewServer(cfg *config.Config, db *gorm.DB) *Server {
+	mux := http.NewServeMux()
+	emailSvc := service.NewEmailService(cfg)
 	return &Server{
-		cfg:       cfg,
-		db:        db,
-		userRepo:  repo.NewUserRepo(db),
-		scheduler: service.NewScheduler(db, cfg),
-		mux:       http.NewServeMux(),
+		cfg:           cfg,
+		db:            db,
+		userRepo:      repo.NewUserRepo(db),
+		scheduler:     service.NewScheduler(db, cfg),
+		emailSvc:      emailSvc,
+		tokens:        service.NewTokenSigner(cfg.AppSecret),
+		notifiers:     service.NewNotifierRegistry(emailSvc),
+		webhooks:      service.NewWebhookService(db, cfg),
+		outbox:        repo.NewNotificationRepo(db),
+		attempts:      repo.NewQueryAttemptRepo(db),
+		scoreLimiter:  newIPLimiter(cfg.ScoreLookupRateLimit),
+		manageLimiter: newIPLimiter(cfg.ManageLinkRateLimit),
+		linkLimiter:   newIPLimiter(1),
+		mux:           mux,
+		httpServer: &http.Server{
+			Addr:    fmt.Sprintf(":%s", cfg.Port),
+			Handler: mux,
+		},
 	}
 }
 
+// Start starts the scheduler and serves HTTP until Stop is called. It
+// returns nil after a graceful shutdown.
 func (s *Server) Start() error {
 	s.registerRoutes()
+	s.importInitialEntries()
 
 	// Start background scheduler
 	s.scheduler.Start()
 
-	addr := fmt.Sprintf(":%s", s.cfg.Port)
-	logger.Info("Server listening on %s", addr)
+	logger.Info("Server listening on %s", s.httpServer.Addr)
 
-	return http.ListenAndServe(addr, s.mux)
+	if err := s.httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-func (s *Server) Stop() {
-	s.scheduler.Stop()
+// Stop stops accepting requests, waits for in-flight requests and score
+// queries to finish until ctx expires, then stops the scheduler.
+func (s *Server) Stop(ctx context.Context) error {
+	httpErr := s.httpServer.Shutdown(ctx)
+	if httpErr != nil {
+		logger.Error("HTTP server shutdown: %v", httpErr)
+	}
+
+	// Let mails already accepted for sending go out
+	sent := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-ctx.Done():
+	}
+
+	schedulerErr := s.scheduler.Stop(ctx)
+	if schedulerErr != nil {
+		logger.Error("Scheduler shutdown: %v", schedulerErr)
+	}
+
+	s.emailSvc.Close()
+
 	logger.Info("Server stopped")
+	return errors.Join(httpErr, schedulerErr)
 }
 
 func (s *Server) registerRoutes() {
 	// API routes
 	s.mux.HandleFunc("GET /", s.handleIndex)
 	s.mux.HandleFunc("POST /api/submit", s.handleSubmit)
-	s.mux.HandleFunc("GET /api/score/{email}", s.handleQueryScore)
+	s.mux.HandleFunc("GET /api/verify/{token}", s.handleVerify)
+	s.mux.HandleFunc("GET /api/unsubscribe/{token}", s.handleUnsubscribe)
+	s.mux.HandleFunc("POST /api/unsubscribe/{token}", s.handleUnsubscribe)
+	s.mux.HandleFunc("GET /api/recipients/verify/{token}", s.handleVerifyRecipient)
+	s.mux.HandleFunc("GET /api/recipients/unsubscribe/{token}", s.handleRecipientUnsubscribe)
+	s.mux.HandleFunc("POST /api/recipients/unsubscribe/{token}", s.handleRecipientUnsubscribe)
+	s.mux.HandleFunc("GET /api/channels", s.handleChannels)
+	s.mux.HandleFunc("POST /api/score", s.limit(s.scoreLimiter, s.handleQueryScore))
 	s.mux.HandleFunc("GET /api/health", s.handleHealth)
+
+	// Self-service routes, authenticated by a management link or token
+	s.mux.HandleFunc("POST /api/manage/link", s.limit(s.manageLimiter, s.handleManageLink))
+	s.mux.HandleFunc("GET /api/manage", s.requireOwner(s.handleGetSubmission))
+	s.mux.HandleFunc("PATCH /api/manage", s.requireOwner(s.handleUpdateSubmission))
+	s.mux.HandleFunc("DELETE /api/manage", s.requireOwner(s.handleDeleteSubmission))
+	s.mux.HandleFunc("POST /api/manage/pause", s.requireOwner(s.handlePauseSubmission))
+	s.mux.HandleFunc("POST /api/manage/resume", s.requireOwner(s.handleResumeSubmission))
+	s.mux.HandleFunc("GET /api/manage/notifications", s.requireOwner(s.handleGetNotificationSettings))
+	s.mux.HandleFunc("PUT /api/manage/notifications", s.requireOwner(s.handleUpdateNotificationSettings))
+	s.mux.HandleFunc("GET /api/manage/history", s.requireOwner(s.handleQueryHistory))
+
+	s.registerAdminRoutes()
+}
+
+// registerAdminRoutes registers the /api/admin route group, every route of
+// which requires admin credentials
+func (s *Server) registerAdminRoutes() {
+	admin := func(method, path string, handler http.HandlerFunc) {
+		s.mux.HandleFunc(method+" /api/admin"+path, s.requireAdmin(handler))
+	}
+
+	admin("GET", "/users", s.handleListUsers)
+	admin("GET", "/users/{id}", s.handleGetUser)
+	admin("POST", "/users/{id}/query", s.handleQueryUser)
+	admin("GET", "/users/{id}/attempts", s.handleListAttempts)
+	admin("GET", "/attempts/{id}/snapshot", s.handleAttemptSnapshot)
+	admin("POST", "/users/reset", s.handleResetFailed)
+	admin("POST", "/query", s.handleQueryAll)
+	admin("POST", "/import", s.handleImport)
+	admin("GET", "/webhooks/deliveries", s.handleListWebhookDeliveries)
+	admin("POST", "/webhooks/deliveries/{id}/replay", s.handleReplayWebhookDelivery)
+	admin("GET", "/notifications", s.handleListNotifications)
+	admin("POST", "/notifications/{id}/retry", s.handleRetryNotification)
 }
@@ -1,29 +1,63 @@
 package db
 
 import (
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/internal/model"
-"chsi-auto-score-query/pkg/config"
-"gorm.io/driver/sqlite"
-"gorm.io/gorm"
+	"encoding/json"
+	"strings"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/secure"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
+// Models returns every model stored in the database, in migration order
+func Models() []interface{} {
+	return []interface{}{
+		&model.User{}, &model.ChsiSession{}, &model.PurgeRecord{}, &model.NotificationChannel{},
+		&model.WebhookEndpoint{}, &model.WebhookDelivery{}, &model.Notification{}, &model.Recipient{},
+		&model.NotificationPreference{}, &model.QueryAttempt{}, &model.ImportRecord{},
+	}
+}
+
 func Init(cfg *config.Config) (*gorm.DB, error) {
+	// 敏感字段加密密钥需在读写数据前加载
+	if err := secure.Init(cfg); err != nil {
+		logger.Error("Failed to load field encryption keys: %v", err)
+		return nil, err
+	}
+
 	database, err := gorm.Open(sqlite.Open(cfg.DatabaseDSN), &gorm.Config{})
 	if err != nil {
 		logger.Error("Failed to connect database: %v", err)
 		return nil, err
 	}
 
+	// 邮箱验证上线前的旧记录视为已验证
+	backfillVerified := database.Migrator().HasTable(&model.User{}) &&
+		!database.Migrator().HasColumn(&model.User{}, "VerifiedAt")
+
 	// 自动迁移
-	err = database.AutoMigrate(&model.User{})
+	err = database.AutoMigrate(Models()...)
 	if err != nil {
 		logger.Error("Failed to auto migrate: %v", err)
 		return nil, err
 	}
 
+	if backfillVerified {
+		database.Exec("UPDATE users SET verified_at = created_at WHERE verified_at IS NULL")
+		logger.Info("Marked existing users as verified")
+	}
+
+	// 旧版本以文本保存的成绩转换为结构化结果
+	if err := convertLegacyScores(database); err != nil {
+		logger.Error("Failed to convert legacy scores: %v", err)
+		return nil, err
+	}
+
 	// 清空数据库（如果需要）
 	if cfg.ClearDBOnStart {
 		database.Exec("DELETE FROM users")
@@ -33,3 +67,48 @@ func Init(cfg *config.Config) (*gorm.DB, error) {
 	DB = database
 	return database, nil
 }
+
+// convertLegacyScores rewrites scores saved as plain text before results
+// were structured, so they can be read by the encrypted_json serializer
+func convertLegacyScores(database *gorm.DB) error {
+	var rows []struct {
+		ID    uint
+		Score string
+	}
+	if err := database.Table("users").Select("id, score").
+		Where("score IS NOT NULL AND score <> ''").Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	converted := 0
+	for _, row := range rows {
+		if secure.IsEncrypted(row.Score) || strings.HasPrefix(strings.TrimSpace(row.Score), "{") {
+			continue
+		}
+		data, err := json.Marshal(model.ParseLegacyScore(row.Score))
+		if err != nil {
+			return err
+		}
+		value, err := secure.Active().Encrypt(string(data))
+		if err != nil {
+			return err
+		}
+		if err := database.Table("users").Where("id = ?", row.ID).Update("score", value).Error; err != nil {
+			return err
+		}
+		converted++
+	}
+	if converted > 0 {
+		logger.Info("Converted %d legacy scores", converted)
+	}
+	return nil
+}
+
+// Close closes the underlying database connection pool
+func Close(database *gorm.DB) error {
+	sqlDB, err := database.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// legacyUser is the users table as created before results were structured
+type legacyUser struct {
+	ID          uint `gorm:"primaryKey"`
+	Name        string
+	Email       string
+	InfoHash    string `gorm:"uniqueIndex"`
+	Score       string `gorm:"type:text"`
+	LastQueryAt time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+func (legacyUser) TableName() string {
+	return "users"
+}
+
+func TestInitConvertsLegacyScores(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "chsi.db")
+	legacy, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.AutoMigrate(&legacyUser{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, score := range []string{"总分: 245; ksbh: 100000000000001; xm: 张三; ", "拟录取", ""} {
+		legacy.Create(&legacyUser{Name: "张三", Email: "a@example.com", InfoHash: string(rune('a' + i)), Score: score})
+	}
+	if err := Close(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := Init(&config.Config{DatabaseDSN: dsn})
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer Close(database)
+
+	var users []model.User
+	if err := database.Order("id").Find(&users).Error; err != nil {
+		t.Fatalf("legacy scores are not readable: %v", err)
+	}
+	if score := users[0].Score; score == nil || score.Total != "245" || score.ExamID != "100000000000001" ||
+		score.Status != model.QueryStatusScoreReleased || score.Raw == "" {
+		t.Errorf("converted score = %+v", score)
+	}
+	if score := users[1].Score; score == nil || score.Admission != "拟录取" || score.Total != "" {
+		t.Errorf("converted admission = %+v", score)
+	}
+	if users[2].Score != nil {
+		t.Errorf("empty score converted to %+v", users[2].Score)
+	}
+
+	// Converted rows are left alone on the next start
+	if err := convertLegacyScores(database); err != nil {
+		t.Fatal(err)
+	}
+	var again model.User
+	if err := database.First(&again, users[0].ID).Error; err != nil || again.Score.Total != "245" {
+		t.Errorf("score after second conversion = %+v, %v", again.Score, err)
+	}
+}
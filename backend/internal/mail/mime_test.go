@@ -0,0 +1,135 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageAlternative(t *testing.T) {
+	msg := &Message{
+		From:            netmail.Address{Name: "考研成绩查询", Address: "noreply@example.com"},
+		To:              []netmail.Address{{Address: "user@example.org"}},
+		Subject:         "考研成绩已发布",
+		Text:            "总分：400\n",
+		HTML:            "<p>总分：400</p>",
+		ListUnsubscribe: "https://example.com/api/unsubscribe/abc",
+		Date:            time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC),
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if bytes.Contains(bytes.ReplaceAll(data, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("message contains bare LF")
+	}
+
+	parsed, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	h := parsed.Header
+	dec := new(mime.WordDecoder)
+	if subject, _ := dec.DecodeHeader(h.Get("Subject")); subject != msg.Subject || h.Get("Subject") == msg.Subject {
+		t.Errorf("Subject = %q, want encoded %q", h.Get("Subject"), msg.Subject)
+	}
+	if from, err := h.AddressList("From"); err != nil || from[0].Name != "考研成绩查询" || from[0].Address != "noreply@example.com" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	if date, err := h.Date(); err != nil || !date.Equal(msg.Date) {
+		t.Errorf("Date = %v, %v", date, err)
+	}
+	if id := h.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID = %q", id)
+	}
+	if h.Get("List-Unsubscribe") != "<https://example.com/api/unsubscribe/abc>" || h.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Errorf("List-Unsubscribe headers = %q, %q", h.Get("List-Unsubscribe"), h.Get("List-Unsubscribe-Post"))
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q", h.Get("Content-Type"))
+	}
+	parts := readParts(t, parsed.Body, params["boundary"])
+	if len(parts) != 2 || parts[0].contentType != "text/plain" || parts[1].contentType != "text/html" {
+		t.Fatalf("unexpected parts %+v", parts)
+	}
+	if parts[0].body != "总分：400\r\n" || parts[1].body != msg.HTML {
+		t.Errorf("bodies = %q, %q", parts[0].body, parts[1].body)
+	}
+}
+
+func TestMessageAttachments(t *testing.T) {
+	png := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 50)
+	msg := &Message{
+		From:        netmail.Address{Address: "noreply@example.com"},
+		To:          []netmail.Address{{Address: "user@example.org"}},
+		Subject:     "score",
+		HTML:        "<p>see attachment</p>",
+		Attachments: []Attachment{{Filename: "成绩单.png", ContentType: "image/png", Data: png}},
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	parsed, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q", parsed.Header.Get("Content-Type"))
+	}
+	parts := readParts(t, parsed.Body, params["boundary"])
+	if len(parts) != 2 || parts[0].contentType != "text/html" || parts[0].body != msg.HTML {
+		t.Fatalf("unexpected parts %+v", parts)
+	}
+	if parts[1].contentType != "image/png" || parts[1].filename != "成绩单.png" || parts[1].body != string(png) {
+		t.Errorf("attachment = %q %q (%d bytes)", parts[1].contentType, parts[1].filename, len(parts[1].body))
+	}
+
+	if _, err := (&Message{From: msg.From, To: msg.To}).Bytes(); err == nil {
+		t.Error("Bytes() should reject a message without body")
+	}
+}
+
+type part struct {
+	contentType string
+	filename    string
+	body        string
+}
+
+func readParts(t *testing.T, r io.Reader, boundary string) []part {
+	t.Helper()
+	var parts []part
+	mr := multipart.NewReader(r, boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("NextRawPart() error = %v", err)
+		}
+		mediaType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		var body io.Reader = p
+		switch p.Header.Get("Content-Transfer-Encoding") {
+		case "quoted-printable":
+			body = quotedprintable.NewReader(p)
+		case "base64":
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		parts = append(parts, part{contentType: mediaType, filename: p.FileName(), body: string(data)})
+	}
+}
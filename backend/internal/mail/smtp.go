@@ -0,0 +1,354 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS modes of a Transport
+const (
+	// TLSAuto uses implicit TLS on port 465 and STARTTLS otherwise
+	TLSAuto = "auto"
+	// TLSImplicit connects with TLS from the start (SMTPS, usually port 465)
+	TLSImplicit = "tls"
+	// TLSStartTLS requires the server to offer STARTTLS
+	TLSStartTLS = "starttls"
+	// TLSNone sends in plain text, only for trusted local relays
+	TLSNone = "none"
+)
+
+// Authentication mechanisms. AuthAuto tries the advertised mechanisms in the
+// order PLAIN, LOGIN, CRAM-MD5 until one succeeds.
+const (
+	AuthAuto    = "auto"
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+)
+
+const defaultSMTPTimeout = time.Minute
+
+var ErrStartTLSUnavailable = errors.New("smtp: server does not offer STARTTLS")
+
+// SMTPConfig configures a Transport
+type SMTPConfig struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	TLSMode    string
+	SkipVerify bool
+	Auth       string
+	// PoolSize is the number of idle connections kept for reuse
+	PoolSize    int
+	IdleTimeout time.Duration
+	Timeout     time.Duration
+}
+
+// Transport sends messages over SMTP and keeps authenticated connections
+// open between messages, so a batch of notifications does not reconnect and
+// log in for every recipient.
+type Transport struct {
+	cfg  SMTPConfig
+	mu   sync.Mutex
+	idle []*smtpConn
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func NewTransport(cfg SMTPConfig) *Transport {
+	if cfg.TLSMode == "" {
+		cfg.TLSMode = TLSAuto
+	}
+	if cfg.TLSMode == TLSAuto {
+		cfg.TLSMode = TLSStartTLS
+		if cfg.Port == 465 {
+			cfg.TLSMode = TLSImplicit
+		}
+	}
+	if cfg.Auth == "" {
+		cfg.Auth = AuthAuto
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultSMTPTimeout
+	}
+	return &Transport{cfg: cfg}
+}
+
+// Send delivers msg to the recipients. A pooled connection that turns out
+// to be stale before the transaction started is replaced by a fresh one.
+func (t *Transport) Send(ctx context.Context, from string, to []string, msg []byte) error {
+	for {
+		c, reused, err := t.get(ctx)
+		if err != nil {
+			return err
+		}
+		started, err := t.send(ctx, c, from, to, msg)
+		if err == nil {
+			t.put(c)
+			return nil
+		}
+		c.close()
+		if !reused || started || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// Close closes all idle connections
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.mu.Unlock()
+
+	for _, c := range idle {
+		c.quit()
+	}
+	return nil
+}
+
+// get returns an idle connection that still answers, or dials a new one
+func (t *Transport) get(ctx context.Context) (*smtpConn, bool, error) {
+	for {
+		t.mu.Lock()
+		n := len(t.idle)
+		if n == 0 {
+			t.mu.Unlock()
+			break
+		}
+		c := t.idle[n-1]
+		t.idle = t.idle[:n-1]
+		t.mu.Unlock()
+
+		if t.cfg.IdleTimeout > 0 && time.Since(c.lastUsed) > t.cfg.IdleTimeout {
+			c.quit()
+			continue
+		}
+		c.deadline(ctx, t.cfg.Timeout)
+		if err := c.client.Reset(); err != nil {
+			c.close()
+			continue
+		}
+		return c, true, nil
+	}
+
+	c, err := t.dial(ctx)
+	return c, false, err
+}
+
+// put returns a healthy connection to the pool
+func (t *Transport) put(c *smtpConn) {
+	c.lastUsed = time.Now()
+	t.mu.Lock()
+	if len(t.idle) < t.cfg.PoolSize {
+		t.idle = append(t.idle, c)
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+	c.quit()
+}
+
+// dial connects and authenticates. The net/smtp client ends the session
+// when authentication fails, so each fallback mechanism gets a new connection.
+func (t *Transport) dial(ctx context.Context) (*smtpConn, error) {
+	c, err := t.connect(ctx)
+	if err != nil || t.cfg.Username == "" {
+		return c, err
+	}
+
+	mechanisms, err := t.mechanisms(c.client)
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	for i, m := range mechanisms {
+		if i > 0 {
+			if c, err = t.connect(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if err = c.client.Auth(t.authFor(m)); err == nil {
+			return c, nil
+		}
+		c.close()
+	}
+	return nil, fmt.Errorf("smtp: auth: %w", err)
+}
+
+// connect opens a connection and secures it according to the TLS mode
+func (t *Transport) connect(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: t.cfg.Host, InsecureSkipVerify: t.cfg.SkipVerify}
+
+	dialer := &net.Dialer{Timeout: t.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.TLSMode == TLSImplicit {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("smtp: tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c := &smtpConn{conn: conn}
+	c.deadline(ctx, t.cfg.Timeout)
+	c.client, err = smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.cfg.TLSMode == TLSStartTLS {
+		if ok, _ := c.client.Extension("STARTTLS"); !ok {
+			c.close()
+			return nil, ErrStartTLSUnavailable
+		}
+		if err := c.client.StartTLS(tlsConfig); err != nil {
+			c.close()
+			return nil, fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// mechanisms returns the configured mechanism, or in auto mode the
+// advertised ones in order of preference
+func (t *Transport) mechanisms(client *smtp.Client) ([]string, error) {
+	ok, advertised := client.Extension("AUTH")
+	if !ok {
+		return nil, errors.New("smtp: server does not support AUTH")
+	}
+	switch t.cfg.Auth {
+	case AuthAuto:
+	case AuthPlain, AuthLogin, AuthCRAMMD5:
+		return []string{t.cfg.Auth}, nil
+	default:
+		return nil, fmt.Errorf("smtp: unknown AUTH mechanism %q", t.cfg.Auth)
+	}
+
+	offered := strings.Fields(strings.ToLower(advertised))
+	var mechanisms []string
+	for _, m := range []string{AuthPlain, AuthLogin, AuthCRAMMD5} {
+		for _, o := range offered {
+			if m == o {
+				mechanisms = append(mechanisms, m)
+			}
+		}
+	}
+	if len(mechanisms) == 0 {
+		return nil, fmt.Errorf("smtp: no supported AUTH mechanism in %q", advertised)
+	}
+	return mechanisms, nil
+}
+
+func (t *Transport) authFor(mechanism string) smtp.Auth {
+	switch mechanism {
+	case AuthLogin:
+		return &loginAuth{username: t.cfg.Username, password: t.cfg.Password}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(t.cfg.Username, t.cfg.Password)
+	default:
+		return &plainAuth{username: t.cfg.Username, password: t.cfg.Password}
+	}
+}
+
+// send runs one mail transaction. started reports whether the server
+// accepted MAIL FROM, after which a failed message must not be retried.
+func (t *Transport) send(ctx context.Context, c *smtpConn, from string, to []string, msg []byte) (started bool, err error) {
+	c.deadline(ctx, t.cfg.Timeout)
+	if err := c.client.Mail(from); err != nil {
+		return false, err
+	}
+	for _, rcpt := range to {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return true, err
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return true, err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return true, err
+	}
+	return true, w.Close()
+}
+
+func (c *smtpConn) deadline(ctx context.Context, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+}
+
+// quit ends the session politely, close just drops the connection
+func (c *smtpConn) quit() {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := c.client.Quit(); err != nil {
+		c.close()
+	}
+}
+
+func (c *smtpConn) close() {
+	c.conn.Close()
+}
+
+// plainAuth is RFC 4616 PLAIN. Unlike smtp.PlainAuth it leaves the decision
+// to send credentials without TLS to the configured TLS mode.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("smtp: unexpected PLAIN challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth is the LOGIN mechanism used by many Chinese providers
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("smtp: unexpected LOGIN challenge %q", fromServer)
+	}
+}
@@ -0,0 +1,340 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal SMTP server for transport tests
+type fakeSMTP struct {
+	t          *testing.T
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	implicit   bool
+	startTLS   bool
+	mechanisms []string
+	username   string
+	password   string
+
+	mu       sync.Mutex
+	conns    int
+	auths    []string
+	messages []string
+}
+
+func newFakeSMTP(t *testing.T, implicit bool, opts ...func(*fakeSMTP)) *fakeSMTP {
+	t.Helper()
+	s := &fakeSMTP{
+		t:          t,
+		tlsConfig:  &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}},
+		implicit:   implicit,
+		startTLS:   !implicit,
+		mechanisms: []string{"PLAIN", "LOGIN", "CRAM-MD5"},
+		username:   "user",
+		password:   "secret",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve()
+	t.Cleanup(func() { s.listener.Close() })
+	return s
+}
+
+func (s *fakeSMTP) config(tlsMode string) SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPConfig{
+		Host: host, Port: p, Username: "user", Password: "secret",
+		TLSMode: tlsMode, SkipVerify: true, PoolSize: 1, Timeout: 5 * time.Second,
+	}
+}
+
+func (s *fakeSMTP) stats() (conns int, auths, messages []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, append([]string(nil), s.auths...), append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := func(format string, args ...interface{}) { fmt.Fprintf(conn, format+"\r\n", args...) }
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	secure := s.implicit
+	authed := false
+	w("220 fake ESMTP")
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			w("250-fake")
+			if s.startTLS && !secure {
+				w("250-STARTTLS")
+			}
+			w("250 AUTH %s", strings.Join(s.mechanisms, " "))
+		case "STARTTLS":
+			w("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			s.mu.Lock()
+			s.auths = append(s.auths, mechanism)
+			s.mu.Unlock()
+			var user, pass string
+			switch mechanism {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(decoded), "\x00")
+				if len(parts) == 3 {
+					user, pass = parts[1], parts[2]
+				}
+			case "LOGIN":
+				w("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				l, _ := readLine()
+				u, _ := base64.StdEncoding.DecodeString(l)
+				w("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				l, _ = readLine()
+				p, _ := base64.StdEncoding.DecodeString(l)
+				user, pass = string(u), string(p)
+			case "CRAM-MD5":
+				challenge := "<123@fake>"
+				w("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+				l, _ := readLine()
+				decoded, _ := base64.StdEncoding.DecodeString(l)
+				u, digest, _ := strings.Cut(string(decoded), " ")
+				mac := hmac.New(md5.New, []byte(s.password))
+				mac.Write([]byte(challenge))
+				if digest == hex.EncodeToString(mac.Sum(nil)) {
+					user, pass = u, s.password
+				}
+			default:
+				w("504 unrecognized mechanism")
+				continue
+			}
+			if user == s.username && pass == s.password {
+				authed = true
+				w("235 ok")
+			} else {
+				w("535 bad credentials")
+			}
+		case "MAIL":
+			if !authed {
+				w("530 auth required")
+				continue
+			}
+			w("250 ok")
+		case "RCPT", "RSET", "NOOP":
+			w("250 ok")
+		case "DATA":
+			w("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := readLine()
+				if err != nil {
+					return
+				}
+				if l == "." {
+					break
+				}
+				msg.WriteString(l + "\n")
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			w("250 queued")
+		case "QUIT":
+			w("221 bye")
+			return
+		default:
+			w("502 unknown command")
+		}
+	}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake smtp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTransportTLSModes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		implicit bool
+		mode     string
+	}{
+		{"implicit", true, TLSImplicit},
+		{"starttls", false, TLSStartTLS},
+		{"auto starttls", false, TLSAuto},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTP(t, tc.implicit)
+			tr := NewTransport(srv.config(tc.mode))
+			defer tr.Close()
+
+			if err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if _, _, messages := srv.stats(); len(messages) != 1 || !strings.Contains(messages[0], "body") {
+				t.Errorf("server received %q", messages)
+			}
+		})
+	}
+}
+
+func TestTransportRequiresStartTLS(t *testing.T) {
+	srv := newFakeSMTP(t, false, func(s *fakeSMTP) { s.startTLS = false })
+	tr := NewTransport(srv.config(TLSStartTLS))
+	err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n"))
+	if !errors.Is(err, ErrStartTLSUnavailable) {
+		t.Errorf("Send() error = %v, want ErrStartTLSUnavailable", err)
+	}
+
+	// Plain connections are only used when configured explicitly
+	tr = NewTransport(srv.config(TLSNone))
+	defer tr.Close()
+	if err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n")); err != nil {
+		t.Errorf("Send() with TLS disabled error = %v", err)
+	}
+}
+
+func TestTransportVerifiesCertificates(t *testing.T) {
+	srv := newFakeSMTP(t, true)
+	cfg := srv.config(TLSImplicit)
+	cfg.SkipVerify = false
+	err := NewTransport(cfg).Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "tls") {
+		t.Errorf("Send() error = %v, want certificate error", err)
+	}
+}
+
+func TestTransportAuthFallback(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		mechanisms []string
+		auth       string
+		want       []string
+	}{
+		{"login only", []string{"LOGIN"}, AuthAuto, []string{"LOGIN"}},
+		{"cram-md5 only", []string{"CRAM-MD5"}, AuthAuto, []string{"CRAM-MD5"}},
+		{"forced cram-md5", []string{"PLAIN", "CRAM-MD5"}, AuthCRAMMD5, []string{"CRAM-MD5"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTP(t, true, func(s *fakeSMTP) { s.mechanisms = tc.mechanisms })
+			cfg := srv.config(TLSImplicit)
+			cfg.Auth = tc.auth
+			tr := NewTransport(cfg)
+			defer tr.Close()
+
+			if err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n")); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if _, auths, _ := srv.stats(); strings.Join(auths, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("auth attempts = %v, want %v", auths, tc.want)
+			}
+		})
+	}
+
+	srv := newFakeSMTP(t, true, func(s *fakeSMTP) { s.password = "other" })
+	err := NewTransport(srv.config(TLSImplicit)).Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("Send() error = %v, want auth error", err)
+	}
+	if _, auths, _ := srv.stats(); strings.Join(auths, ",") != "PLAIN,LOGIN,CRAM-MD5" {
+		t.Errorf("auth attempts = %v, want every mechanism", auths)
+	}
+}
+
+func TestTransportReusesConnections(t *testing.T) {
+	srv := newFakeSMTP(t, true)
+	tr := NewTransport(srv.config(TLSImplicit))
+	defer tr.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n")); err != nil {
+			t.Fatalf("Send() #%d error = %v", i, err)
+		}
+	}
+	if conns, _, messages := srv.stats(); conns != 1 || len(messages) != 3 {
+		t.Errorf("conns = %d, messages = %d, want 1 connection for 3 messages", conns, len(messages))
+	}
+
+	// A pooled connection dropped by the server is replaced transparently
+	tr.mu.Lock()
+	tr.idle[0].conn.Close()
+	tr.mu.Unlock()
+	if err := tr.Send(context.Background(), "from@example.com", []string{"to@example.com"}, []byte("x\r\n")); err != nil {
+		t.Fatalf("Send() after drop error = %v", err)
+	}
+	if conns, _, messages := srv.stats(); conns != 2 || len(messages) != 4 {
+		t.Errorf("conns = %d, messages = %d after drop", conns, len(messages))
+	}
+}
@@ -0,0 +1,295 @@
+// Package mail renders and builds notification emails.
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+)
+
+// Supported locales
+const (
+	LocaleZhCN = "zh-CN"
+	LocaleEn   = "en"
+
+	DefaultLocale = LocaleZhCN
+)
+
+// Template kinds. Each kind has a <kind>.html and a <kind>.txt file per
+// locale; the text file also defines the "subject" template.
+const (
+	KindVerification = "verification"
+	KindScore        = "score"
+	KindInfoMismatch = "info_mismatch"
+	KindError        = "error"
+	KindManage       = "manage"
+	KindStatusChange = "status_change"
+	KindDigest       = "digest"
+	// KindRecipientVerification asks an additional recipient to opt in
+	KindRecipientVerification = "recipient_verification"
+)
+
+var (
+	locales = []string{LocaleZhCN, LocaleEn}
+	kinds   = []string{KindVerification, KindScore, KindInfoMismatch, KindError, KindManage, KindStatusChange, KindDigest, KindRecipientVerification}
+)
+
+// statusLabels are the names of the query states shown in emails
+var statusLabels = map[string]map[model.QueryStatus]string{
+	LocaleZhCN: {
+		model.QueryStatusPending:       "等待查询",
+		model.QueryStatusNotPublished:  "成绩尚未发布",
+		model.QueryStatusInfoMismatch:  "报考信息不匹配",
+		model.QueryStatusScoreReleased: "成绩已发布",
+		model.QueryStatusReexam:        "复试阶段",
+		model.QueryStatusPhysicalExam:  "体检阶段",
+		model.QueryStatusAdmitted:      "拟录取",
+		model.QueryStatusRejected:      "未录取",
+		model.QueryStatusFailed:        "查询失败",
+		model.QueryStatusStopped:       "已停止查询",
+	},
+	LocaleEn: {
+		model.QueryStatusPending:       "Waiting for query",
+		model.QueryStatusNotPublished:  "Scores not yet released",
+		model.QueryStatusInfoMismatch:  "Registration details do not match",
+		model.QueryStatusScoreReleased: "Scores released",
+		model.QueryStatusReexam:        "Re-examination",
+		model.QueryStatusPhysicalExam:  "Physical examination",
+		model.QueryStatusAdmitted:      "Admission proposed",
+		model.QueryStatusRejected:      "Not admitted",
+		model.QueryStatusFailed:        "Query failed",
+		model.QueryStatusStopped:       "Querying stopped",
+	},
+}
+
+//go:embed templates
+var embedded embed.FS
+
+// VerificationData is the data of the verification template
+type VerificationData struct {
+	Name      string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// RecipientVerificationData is the data of the recipient verification
+// template. Owner is the submission's email address; the candidate's name
+// is only shared once the recipient has opted in.
+type RecipientVerificationData struct {
+	Owner     string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// ManageData is the data of the management link template
+type ManageData struct {
+	Name      string
+	Link      string
+	ExpiresAt time.Time
+}
+
+// ScoreData is the data of the score template
+type ScoreData struct {
+	Name   string
+	Result *model.ScoreResult
+}
+
+// InfoMismatchData is the data of the info mismatch template
+type InfoMismatchData struct {
+	Name    string
+	Message string
+}
+
+// ErrorData is the data of the final failure template
+type ErrorData struct {
+	Name     string
+	Reason   string
+	Attempts int
+}
+
+// StatusChangeData is the data of the status change template. The states
+// are labels from StatusLabel.
+type StatusChangeData struct {
+	Name     string
+	Previous string
+	Status   string
+	Message  string
+}
+
+// DigestData is the data of the daily digest template
+type DigestData struct {
+	Name        string
+	Status      string
+	LastQueryAt time.Time
+	SubmittedAt time.Time
+}
+
+// StatusLabel returns the name of a query state in the locale
+func StatusLabel(locale string, status model.QueryStatus) string {
+	if label, ok := statusLabels[MatchLocale(locale)][status]; ok {
+		return label
+	}
+	return string(status)
+}
+
+// Row is one label/value line of a table in a template
+type Row struct {
+	Label string
+	Value string
+}
+
+var funcs = map[string]interface{}{
+	"row": func(label, value string) Row {
+		return Row{Label: label, Value: value}
+	},
+	"rank": func(result *model.ScoreResult) string {
+		if result.Rank != "" && result.RankTotal != "" {
+			return result.Rank + " / " + result.RankTotal
+		}
+		return result.Rank
+	},
+}
+
+// Content is a rendered email
+type Content struct {
+	Subject string
+	HTML    string
+	Text    string
+	// Unsubscribe is set by the caller for the List-Unsubscribe header
+	Unsubscribe string
+}
+
+type localized struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// Templates holds the parsed templates of all locales
+type Templates struct {
+	templates map[string]map[string]localized
+}
+
+// MatchLocale maps a requested locale such as "en-US" or "zh_Hans" to a
+// supported one and falls back to the default locale.
+func MatchLocale(locale string) string {
+	matched, _ := matchLocale(locale)
+	return matched
+}
+
+// SupportedLocale reports whether the locale is empty or matches a
+// supported locale without falling back.
+func SupportedLocale(locale string) bool {
+	if strings.TrimSpace(locale) == "" {
+		return true
+	}
+	_, ok := matchLocale(locale)
+	return ok
+}
+
+func matchLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	lang, _, _ := strings.Cut(locale, "-")
+	switch lang {
+	case "zh":
+		return LocaleZhCN, true
+	case "en":
+		return LocaleEn, true
+	default:
+		return DefaultLocale, false
+	}
+}
+
+// LoadTemplates parses the embedded templates. Files found in dir, laid out
+// as <dir>/<locale>/<kind>.html and .txt, replace the embedded ones.
+func LoadTemplates(dir string) (*Templates, error) {
+	defaults, _ := fs.Sub(embedded, "templates")
+	var override fs.FS
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("email template dir: %w", err)
+		}
+		override = os.DirFS(dir)
+	}
+
+	t := &Templates{templates: make(map[string]map[string]localized)}
+	for _, locale := range locales {
+		t.templates[locale] = make(map[string]localized)
+		for _, kind := range kinds {
+			htmlSrc, err := readTemplate(override, defaults, locale, kind+".html")
+			if err != nil {
+				return nil, err
+			}
+			textSrc, err := readTemplate(override, defaults, locale, kind+".txt")
+			if err != nil {
+				return nil, err
+			}
+
+			name := locale + "/" + kind
+			html, err := htmltemplate.New(name + ".html").Funcs(funcs).Parse(htmlSrc)
+			if err != nil {
+				return nil, err
+			}
+			text, err := texttemplate.New(name + ".txt").Funcs(funcs).Parse(textSrc)
+			if err != nil {
+				return nil, err
+			}
+			if text.Lookup("subject") == nil {
+				return nil, fmt.Errorf("template %s.txt does not define \"subject\"", name)
+			}
+			t.templates[locale][kind] = localized{html: html, text: text}
+		}
+	}
+	return t, nil
+}
+
+func readTemplate(override, defaults fs.FS, locale, file string) (string, error) {
+	path := locale + "/" + file
+	if override != nil {
+		data, err := fs.ReadFile(override, path)
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("read template %s: %w", filepath.FromSlash(path), err)
+		}
+	}
+	data, err := fs.ReadFile(defaults, path)
+	if err != nil {
+		return "", fmt.Errorf("read embedded template %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// Render renders the subject, HTML and plain-text body of a template
+func (t *Templates) Render(locale, kind string, data interface{}) (*Content, error) {
+	tmpl, ok := t.templates[MatchLocale(locale)][kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", kind)
+	}
+
+	var subject, html, text bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return &Content{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
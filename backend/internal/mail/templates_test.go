@@ -0,0 +1,155 @@
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+)
+
+func TestRenderEscapesUserInput(t *testing.T) {
+	tmpl, err := LoadTemplates("")
+	if err != nil {
+		t.Fatalf("LoadTemplates() error = %v", err)
+	}
+
+	data := ScoreData{
+		Name: `<script>alert(1)</script>`,
+		Result: &model.ScoreResult{
+			Status:   model.QueryStatusScoreReleased,
+			Politics: model.SubjectScore{Score: "70"},
+			Total:    "400",
+			Rank:     "3",
+			Note:     `<a href="x">`,
+		},
+	}
+	content, err := tmpl.Render(LocaleZhCN, KindScore, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if content.Subject != "考研成绩已发布" {
+		t.Errorf("Subject = %q", content.Subject)
+	}
+	if strings.Contains(content.HTML, "<script>") || strings.Contains(content.HTML, `<a href="x">`) {
+		t.Errorf("HTML is not escaped:\n%s", content.HTML)
+	}
+	for _, want := range []string{"&lt;script&gt;", "<td>思想政治理论</td><td>70</td>", "<td>总分</td><td>400</td>"} {
+		if !strings.Contains(content.HTML, want) {
+			t.Errorf("HTML missing %q:\n%s", want, content.HTML)
+		}
+	}
+	if strings.Contains(content.HTML, "外国语") {
+		t.Errorf("HTML contains empty rows:\n%s", content.HTML)
+	}
+	if !strings.Contains(content.Text, "总分：400\n排名：3\n") {
+		t.Errorf("Text = %q", content.Text)
+	}
+}
+
+func TestRenderLocales(t *testing.T) {
+	tmpl, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := VerificationData{Name: "Zhang San", Link: "https://example.com/v?a=1&b=2", ExpiresAt: time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)}
+	for _, tc := range []struct{ locale, subject string }{
+		{"", "请验证您的邮箱"},
+		{"zh-CN", "请验证您的邮箱"},
+		{"en-US", "Please verify your email address"},
+		{"fr", "请验证您的邮箱"},
+	} {
+		content, err := tmpl.Render(tc.locale, KindVerification, data)
+		if err != nil {
+			t.Fatalf("Render(%q) error = %v", tc.locale, err)
+		}
+		if content.Subject != tc.subject {
+			t.Errorf("Render(%q) subject = %q, want %q", tc.locale, content.Subject, tc.subject)
+		}
+		if !strings.Contains(content.Text, data.Link) || !strings.Contains(content.HTML, "a=1&amp;b=2") {
+			t.Errorf("Render(%q) link not rendered", tc.locale)
+		}
+	}
+
+	for _, kind := range kinds {
+		for _, locale := range locales {
+			var data interface{}
+			switch kind {
+			case KindScore:
+				data = ScoreData{Result: &model.ScoreResult{}}
+			case KindVerification:
+				data = VerificationData{}
+			case KindInfoMismatch:
+				data = InfoMismatchData{}
+			case KindError:
+				data = ErrorData{}
+			case KindManage:
+				data = ManageData{}
+			case KindStatusChange:
+				data = StatusChangeData{}
+			case KindDigest:
+				data = DigestData{}
+			case KindRecipientVerification:
+				data = RecipientVerificationData{}
+			}
+			if _, err := tmpl.Render(locale, kind, data); err != nil {
+				t.Errorf("Render(%s, %s) error = %v", locale, kind, err)
+			}
+		}
+	}
+}
+
+func TestLoadTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "en"), 0o755)
+	os.WriteFile(filepath.Join(dir, "en", "error.txt"), []byte(`{{define "subject"}}Custom{{end}}Reason: {{.Reason}}`), 0o644)
+
+	tmpl, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("LoadTemplates() error = %v", err)
+	}
+	content, err := tmpl.Render(LocaleEn, KindError, ErrorData{Reason: "timeout"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Subject != "Custom" || content.Text != "Reason: timeout\n" {
+		t.Errorf("override not used: %+v", content)
+	}
+	if !strings.Contains(content.HTML, "Score query failed") {
+		t.Errorf("embedded HTML should still be used")
+	}
+
+	os.WriteFile(filepath.Join(dir, "en", "score.txt"), []byte(`no subject`), 0o644)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("LoadTemplates() should reject a text template without subject")
+	}
+	if _, err := LoadTemplates(filepath.Join(dir, "missing")); err == nil {
+		t.Error("LoadTemplates() should reject a missing directory")
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	for in, want := range map[string]string{"": LocaleZhCN, "zh": LocaleZhCN, "zh_Hans": LocaleZhCN, "EN": LocaleEn, "en-GB": LocaleEn, "ja": LocaleZhCN} {
+		if got := MatchLocale(in); got != want {
+			t.Errorf("MatchLocale(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if SupportedLocale("ja") || !SupportedLocale("en-US") || !SupportedLocale("") {
+		t.Error("SupportedLocale() mismatch")
+	}
+}
+
+func TestStatusLabel(t *testing.T) {
+	if got := StatusLabel("en-US", model.QueryStatusNotPublished); got != "Scores not yet released" {
+		t.Errorf("StatusLabel(en) = %q", got)
+	}
+	if got := StatusLabel("", model.QueryStatusAdmitted); got != "拟录取" {
+		t.Errorf("StatusLabel(default) = %q", got)
+	}
+	if got := StatusLabel(LocaleEn, "unknown"); got != "unknown" {
+		t.Errorf("StatusLabel(unknown) = %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"time"
+)
+
+// Error classes of a failed query attempt
+const (
+	AttemptErrorLogin          = "login"           // 登录学信网失败
+	AttemptErrorSessionExpired = "session_expired" // 会话过期且重新登录失败
+	AttemptErrorHTTPStatus     = "http_status"     // 学信网返回非200状态码
+	AttemptErrorNetwork        = "network"         // 网络错误
+	AttemptErrorTimeout        = "timeout"         // 超时
+	AttemptErrorCanceled       = "canceled"        // 调度器停止或批次截止
+	AttemptErrorParse          = "parse"           // 页面无法解析
+	AttemptErrorOther          = "other"
+)
+
+// QueryAttempt records one CHSI query of a user and its outcome, so the
+// history survives the overwrite of Notice and LastQueryAt.
+type QueryAttempt struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"index:idx_query_attempts_user_started"`
+	StartedAt  time.Time `gorm:"index:idx_query_attempts_user_started;index"`
+	DurationMs int64
+	// HTTPStatus is 0 when no response was received
+	HTTPStatus int
+	// Status is the parsed query status, empty when the page was not parsed
+	Status     QueryStatus `gorm:"type:varchar(32)"`
+	ErrorClass string      `gorm:"type:varchar(32);index"`
+	Error      string      `gorm:"type:text"`
+	// Snapshot is the gzip-compressed, base64-encoded response page. It holds
+	// personal data and is encrypted at rest; SnapshotSize is its original
+	// length, 0 without a snapshot.
+	Snapshot     string `gorm:"type:text;serializer:encrypted" json:"-"`
+	SnapshotSize int
+	CreatedAt    time.Time
+}
+
+func (QueryAttempt) TableName() string {
+	return "query_attempts"
+}
+
+// SetSnapshot stores the compressed response page
+func (a *QueryAttempt) SetSnapshot(page string) {
+	if page == "" {
+		return
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(page))
+	zw.Close()
+	a.Snapshot = base64.StdEncoding.EncodeToString(buf.Bytes())
+	a.SnapshotSize = len(page)
+}
+
+// SnapshotPage returns the decompressed response page
+func (a *QueryAttempt) SnapshotPage() (string, error) {
+	if a.Snapshot == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(a.Snapshot)
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	page, err := io.ReadAll(zr)
+	return string(page), err
+}
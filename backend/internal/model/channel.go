@@ -0,0 +1,40 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// NotificationChannel is one notification target chosen for a submission,
+// e.g. email or a WeCom bot, with its channel specific settings.
+type NotificationChannel struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"index"`
+	Type   string `gorm:"type:varchar(32)"`
+	// Settings is a JSON object; it may hold bot tokens and is encrypted at rest
+	Settings  string `gorm:"type:text;serializer:encrypted"`
+	CreatedAt time.Time
+}
+
+func (NotificationChannel) TableName() string {
+	return "notification_channels"
+}
+
+// SettingsMap decodes the channel settings
+func (c *NotificationChannel) SettingsMap() map[string]string {
+	settings := map[string]string{}
+	if c.Settings != "" {
+		json.Unmarshal([]byte(c.Settings), &settings)
+	}
+	return settings
+}
+
+// SetSettings encodes the channel settings
+func (c *NotificationChannel) SetSettings(settings map[string]string) {
+	if len(settings) == 0 {
+		c.Settings = ""
+		return
+	}
+	data, _ := json.Marshal(settings)
+	c.Settings = string(data)
+}
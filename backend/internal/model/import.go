@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// ImportRecord remembers an entry handled by a bulk import by its info hash,
+// so the entry is not imported again after the user was deleted or purged
+type ImportRecord struct {
+	ID        uint   `gorm:"primaryKey"`
+	EntryHash string `gorm:"type:varchar(64);uniqueIndex"`
+	CreatedAt time.Time
+}
+
+func (ImportRecord) TableName() string {
+	return "import_records"
+}
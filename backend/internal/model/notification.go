@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Notification events
+const (
+	NotificationEventScore        = "score"
+	NotificationEventInfoMismatch = "info_mismatch"
+	NotificationEventFailed       = "failed"
+	NotificationEventStatusChange = "status_change"
+	NotificationEventDigest       = "digest"
+)
+
+// Notification outbox states
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationDead    = "dead"
+	// NotificationCanceled entries were dropped because the user stopped
+	// notifications before they were delivered
+	NotificationCanceled = "canceled"
+)
+
+// Notification is an outbox entry. It is written in the same transaction as
+// the state change it reports and delivered by the dispatcher afterwards, so
+// a notification is neither lost nor sent twice when a channel is down.
+type Notification struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"index"`
+	Event  string `gorm:"type:varchar(32)"`
+	// IdempotencyKey identifies the state change, e.g. score:<user>:<fingerprint>
+	IdempotencyKey string       `gorm:"type:varchar(128);uniqueIndex"`
+	Result         *ScoreResult `gorm:"type:text;serializer:encrypted_json"`
+	Reason         string       `gorm:"type:text"`
+	// PreviousStatus is the state left for status_change events
+	PreviousStatus QueryStatus `gorm:"type:varchar(32)"`
+	// QueryAttempts is the number of failed queries for failed events
+	QueryAttempts int
+	Status        string `gorm:"type:varchar(16);default:pending;index"`
+	Attempts      int
+	NextAttemptAt *time.Time `gorm:"index"`
+	// Delivered lists the channels that already got the notification
+	Delivered string `gorm:"type:text"`
+	LastError string `gorm:"type:text"`
+	SentAt    *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+// NewResultNotification returns the notification for a notifiable result
+func NewResultNotification(userID uint, result *ScoreResult) *Notification {
+	event := NotificationEventScore
+	if result.Status == QueryStatusInfoMismatch {
+		event = NotificationEventInfoMismatch
+	}
+	return &Notification{
+		UserID:         userID,
+		Event:          event,
+		IdempotencyKey: fmt.Sprintf("%s:%d:%s", event, userID, result.Fingerprint()),
+		Result:         result,
+		Status:         NotificationPending,
+	}
+}
+
+// NewFailedNotification returns the final notification after a user ran out
+// of query attempts
+func NewFailedNotification(user *User, reason string) *Notification {
+	return &Notification{
+		UserID:         user.ID,
+		Event:          NotificationEventFailed,
+		IdempotencyKey: fmt.Sprintf("%s:%d:%d", NotificationEventFailed, user.ID, user.LastQueryAt.UnixNano()),
+		Reason:         reason,
+		QueryAttempts:  user.Attempts,
+		Status:         NotificationPending,
+	}
+}
+
+// IsDelivered reports whether the channel already got the notification
+func (n *Notification) IsDelivered(channel string) bool {
+	for _, c := range strings.Split(n.Delivered, ",") {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkDelivered records a channel that got the notification
+func (n *Notification) MarkDelivered(channel string) {
+	if n.IsDelivered(channel) {
+		return
+	}
+	if n.Delivered != "" {
+		n.Delivered += ","
+	}
+	n.Delivered += channel
+}
+
+// NewStatusChangeNotification reports a change of the query status
+func NewStatusChangeNotification(userID uint, previous QueryStatus, result *ScoreResult) *Notification {
+	return &Notification{
+		UserID:         userID,
+		Event:          NotificationEventStatusChange,
+		IdempotencyKey: fmt.Sprintf("%s:%d:%s:%s", NotificationEventStatusChange, userID, previous, result.Fingerprint()),
+		Result:         result,
+		PreviousStatus: previous,
+		Status:         NotificationPending,
+	}
+}
+
+// NewDigestNotification is the daily summary of a submission still waiting
+// for its score; day makes it unique per calendar day
+func NewDigestNotification(user *User, day time.Time) *Notification {
+	return &Notification{
+		UserID:         user.ID,
+		Event:          NotificationEventDigest,
+		IdempotencyKey: fmt.Sprintf("%s:%d:%s", NotificationEventDigest, user.ID, day.Format("2006-01-02")),
+		Status:         NotificationPending,
+	}
+}
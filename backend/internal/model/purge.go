@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+)
+
+// Purge modes
+const (
+	PurgeModeAnonymize = "anonymize"
+	PurgeModeDelete    = "delete"
+)
+
+// Purge reasons
+const (
+	PurgeReasonDelivered = "delivered" // 结果已通知且超过保留期
+	PurgeReasonFailed    = "failed"    // 查询失败终止且超过保留期
+	PurgeReasonRejected  = "rejected"  // 未录取且超过保留期
+	PurgeReasonDeleted   = "deleted"   // 记录已被删除（软删除）
+)
+
+// PurgeRecord is the audit trail of removed personal data. It never holds
+// the removed values themselves.
+type PurgeRecord struct {
+	ID       uint `gorm:"primaryKey"`
+	UserID   uint `gorm:"index"`
+	Mode     string
+	Reason   string
+	Fields   string
+	PurgedAt time.Time `gorm:"index"`
+}
+
+func (PurgeRecord) TableName() string {
+	return "purge_records"
+}
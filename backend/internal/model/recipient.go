@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+// Recipient is an additional email address that receives the notifications
+// of a submission, e.g. a parent of the candidate. The submission's own
+// email address is always notified and is not stored here. Like the
+// submission's address, a recipient is only notified after confirming it.
+type Recipient struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"index"`
+	Email  string `gorm:"type:varchar(254)"`
+	// 收件人确认接收通知的时间，未确认的地址不会收到通知
+	VerifiedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (Recipient) TableName() string {
+	return "recipients"
+}
+
+// NotificationPreference chooses the events a submission is notified about.
+// Submissions without a row use DefaultNotificationPreference. Information
+// mismatches and final query failures need action and are always notified.
+type NotificationPreference struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"uniqueIndex"`
+	// 成绩发布（及之后的复试、拟录取等结果）
+	ScoreReleased bool
+	// 每次查询状态变化
+	StatusChange bool
+	// 每日汇总仍在等待成绩的查询状态
+	DailyDigest bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// DefaultNotificationPreference only notifies released results, as before
+// preferences existed
+func DefaultNotificationPreference(userID uint) *NotificationPreference {
+	return &NotificationPreference{UserID: userID, ScoreReleased: true}
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SubjectScore is the score of a single exam subject
+type SubjectScore struct {
+	Name  string `json:"name,omitempty"`
+	Score string `json:"score,omitempty"`
+}
+
+// ScoreResult is the parsed content of the CHSI cj object
+type ScoreResult struct {
+	Status          QueryStatus  `json:"status"`
+	CandidateName   string       `json:"candidate_name,omitempty"` // 姓名
+	ExamID          string       `json:"exam_id,omitempty"`        // 考生编号
+	Major           string       `json:"major,omitempty"`          // 报考专业
+	Politics        SubjectScore `json:"politics"`                 // 思想政治理论
+	ForeignLanguage SubjectScore `json:"foreign_language"`         // 外国语
+	Subject1        SubjectScore `json:"subject1"`                 // 业务课一
+	Subject2        SubjectScore `json:"subject2"`                 // 业务课二
+	Total           string       `json:"total,omitempty"`          // 总分
+	Rank            string       `json:"rank,omitempty"`           // 排名
+	RankTotal       string       `json:"rank_total,omitempty"`     // 参与排名人数
+	Admission       string       `json:"admission,omitempty"`      // 录取/复试/体检状态
+	Note            string       `json:"note,omitempty"`           // 招生单位说明（zsdwsm）
+	Message         string       `json:"message,omitempty"`        // 页面提示信息（msg）
+	Raw             string       `json:"raw,omitempty"`            // 原始cj JSON
+}
+
+// Released reports whether the result shows a score or a later stage
+func (r *ScoreResult) Released() bool {
+	return r.Status.Released()
+}
+
+// Notifiable reports whether the user should be told about this result
+func (r *ScoreResult) Notifiable() bool {
+	return r.Released() || r.Status == QueryStatusInfoMismatch
+}
+
+// Fingerprint identifies a distinct result so it is only notified once
+func (r *ScoreResult) Fingerprint() string {
+	sum := sha256.Sum256([]byte(string(r.Status) + "\n" + r.Raw + "\n" + r.Message))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScore reports whether the result carries any subject or total score
+func (r *ScoreResult) HasScore() bool {
+	return r.Total != "" || r.Politics.Score != "" || r.ForeignLanguage.Score != "" ||
+		r.Subject1.Score != "" || r.Subject2.Score != ""
+}
+
+// Summary returns a short human readable description for logs
+func (r *ScoreResult) Summary() string {
+	var parts []string
+	if r.Total != "" {
+		parts = append(parts, fmt.Sprintf("总分: %s", r.Total))
+	}
+	if r.Rank != "" {
+		parts = append(parts, fmt.Sprintf("排名: %s", r.Rank))
+	}
+	if r.Admission != "" {
+		parts = append(parts, r.Admission)
+	}
+	if len(parts) == 0 {
+		return string(r.Status)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ParseLegacyScore converts a score stored as text by earlier versions, e.g.
+// "总分: 245; xm: 张三; " or a bare admission status, into a result
+func ParseLegacyScore(text string) *ScoreResult {
+	result := &ScoreResult{Status: QueryStatusScoreReleased, Raw: text}
+	fields := map[string]string{}
+	for _, part := range strings.Split(text, ";") {
+		key, value, ok := strings.Cut(part, ":")
+		if ok {
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if len(fields) == 0 {
+		// 旧版本在没有分数时只保存录取状态或初试成绩
+		value := strings.TrimSpace(text)
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			result.Total = value
+		} else {
+			result.Admission = value
+		}
+		return result
+	}
+	for _, key := range []string{"总分", "zf", "total_score"} {
+		if result.Total == "" {
+			result.Total = fields[key]
+		}
+	}
+	result.CandidateName = fields["xm"]
+	result.ExamID = fields["ksbh"]
+	result.Major = fields["zymc"]
+	return result
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// ChsiSession stores the CHSI cookies of one account so a restart does not
+// force a new login.
+type ChsiSession struct {
+	ID         uint   `gorm:"primaryKey"`
+	Account    string `gorm:"uniqueIndex"`
+	Cookies    string `gorm:"type:text;serializer:encrypted"`
+	LoggedInAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (ChsiSession) TableName() string {
+	return "chsi_sessions"
+}
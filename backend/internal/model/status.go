@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+)
+
+// QueryStatus is the lifecycle state of a submission
+type QueryStatus string
+
+const (
+	QueryStatusPending       QueryStatus = "pending"        // 等待查询
+	QueryStatusNotPublished  QueryStatus = "not_published"  // 成绩尚未发布
+	QueryStatusInfoMismatch  QueryStatus = "info_mismatch"  // 报考信息不匹配
+	QueryStatusScoreReleased QueryStatus = "score_released" // 成绩已发布
+	QueryStatusReexam        QueryStatus = "reexam"         // 复试阶段
+	QueryStatusPhysicalExam  QueryStatus = "physical_exam"  // 体检阶段
+	QueryStatusAdmitted      QueryStatus = "admitted"       // 拟录取
+	QueryStatusRejected      QueryStatus = "rejected"       // 未录取（无成绩）
+	QueryStatusFailed        QueryStatus = "failed"         // 查询失败（重试耗尽）
+	QueryStatusStopped       QueryStatus = "stopped"        // 已停止查询
+)
+
+// queryTransitions lists the states reachable from each state. Staying in
+// the same state is always allowed.
+var queryTransitions = map[QueryStatus][]QueryStatus{
+	QueryStatusPending: {
+		QueryStatusNotPublished, QueryStatusInfoMismatch, QueryStatusScoreReleased,
+		QueryStatusReexam, QueryStatusPhysicalExam, QueryStatusAdmitted,
+		QueryStatusRejected, QueryStatusFailed, QueryStatusStopped,
+	},
+	QueryStatusNotPublished: {
+		QueryStatusPending, QueryStatusInfoMismatch, QueryStatusScoreReleased,
+		QueryStatusReexam, QueryStatusPhysicalExam, QueryStatusAdmitted,
+		QueryStatusRejected, QueryStatusFailed, QueryStatusStopped,
+	},
+	QueryStatusInfoMismatch:  {QueryStatusPending, QueryStatusStopped},
+	QueryStatusScoreReleased: {QueryStatusReexam, QueryStatusPhysicalExam, QueryStatusAdmitted, QueryStatusStopped},
+	QueryStatusReexam:        {QueryStatusPhysicalExam, QueryStatusAdmitted, QueryStatusStopped},
+	QueryStatusPhysicalExam:  {QueryStatusAdmitted, QueryStatusStopped},
+	QueryStatusAdmitted:      {QueryStatusStopped},
+	QueryStatusRejected:      {QueryStatusStopped},
+	QueryStatusFailed:        {QueryStatusPending, QueryStatusStopped},
+	QueryStatusStopped:       {QueryStatusPending},
+}
+
+// InvalidTransitionError is returned for a state change the machine does not allow
+type InvalidTransitionError struct {
+	From QueryStatus
+	To   QueryStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("invalid query status transition: %s -> %s", e.From, e.To)
+}
+
+// Valid reports whether s is a known status
+func (s QueryStatus) Valid() bool {
+	_, ok := queryTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether the machine allows moving from s to next
+func (s QueryStatus) CanTransitionTo(next QueryStatus) bool {
+	if s == next {
+		return true
+	}
+	for _, to := range queryTransitions[s] {
+		if to == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Polling reports whether submissions in this state are still queried
+func (s QueryStatus) Polling() bool {
+	return s == QueryStatusPending || s == QueryStatusNotPublished
+}
+
+// Released reports whether the state shows a score or a later stage
+func (s QueryStatus) Released() bool {
+	switch s {
+	case QueryStatusScoreReleased, QueryStatusReexam, QueryStatusPhysicalExam, QueryStatusAdmitted:
+		return true
+	}
+	return false
+}
+
+// PollingStatuses returns the states picked up by the scheduler
+func PollingStatuses() []QueryStatus {
+	return []QueryStatus{QueryStatusPending, QueryStatusNotPublished}
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUserTransitionTo(t *testing.T) {
+	tests := []struct {
+		from    QueryStatus
+		to      QueryStatus
+		allowed bool
+	}{
+		{"", QueryStatusNotPublished, true},
+		{QueryStatusPending, QueryStatusScoreReleased, true},
+		{QueryStatusNotPublished, QueryStatusNotPublished, true},
+		{QueryStatusNotPublished, QueryStatusInfoMismatch, true},
+		{QueryStatusInfoMismatch, QueryStatusScoreReleased, false},
+		{QueryStatusScoreReleased, QueryStatusAdmitted, true},
+		{QueryStatusAdmitted, QueryStatusNotPublished, false},
+		{QueryStatusFailed, QueryStatusPending, true},
+		{QueryStatusStopped, QueryStatusScoreReleased, false},
+		{QueryStatusNotPublished, QueryStatusRejected, true},
+		{QueryStatusRejected, QueryStatusPending, false},
+		{QueryStatusRejected, QueryStatusStopped, true},
+	}
+
+	for _, tt := range tests {
+		user := &User{Status: tt.from}
+		err := user.TransitionTo(tt.to)
+		if tt.allowed {
+			if err != nil || user.Status != tt.to {
+				t.Errorf("%q -> %q: err = %v, status = %q", tt.from, tt.to, err, user.Status)
+			}
+			continue
+		}
+		var transitionErr *InvalidTransitionError
+		if !errors.As(err, &transitionErr) || user.Status != tt.from {
+			t.Errorf("%q -> %q: err = %v, status = %q; want InvalidTransitionError", tt.from, tt.to, err, user.Status)
+		}
+	}
+}
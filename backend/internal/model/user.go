@@ -1,27 +1,70 @@
 package model
 
 import (
-"time"
+	"time"
 
-"gorm.io/gorm"
+	// registers the "encrypted" and "encrypted_json" gorm serializers used below
+	_ "chsi-auto-score-query/internal/secure"
+	"gorm.io/gorm"
 )
 
 type User struct {
-	ID           uint           `gorm:"primaryKey"`
-	Name         string         `gorm:"index"`
-	IDCard       string         `gorm:"index"`
-	ExamID       string         `gorm:"index"`
-	Email        string
-	SchoolCode   string
-	InfoHash     string    `gorm:"uniqueIndex"`
-	Score        string    `gorm:"type:text"`
-	Notice       string    `gorm:"type:text"`
-	LastQueryAt  time.Time `gorm:"index"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	ID uint `gorm:"primaryKey"`
+	// 姓名、证件号码和考生编号加密存储（见 internal/secure）
+	Name       string `gorm:"type:text;serializer:encrypted"`
+	IDCard     string `gorm:"type:text;serializer:encrypted"`
+	ExamID     string `gorm:"type:text;serializer:encrypted"`
+	Email      string
+	SchoolCode string
+	// 通知语言（zh-CN / en）
+	Locale   string `gorm:"type:varchar(16)"`
+	InfoHash string `gorm:"uniqueIndex"`
+	// 成绩含有姓名、考生编号和原始响应，同样加密存储
+	Score       *ScoreResult `gorm:"type:text;serializer:encrypted_json"`
+	Status      QueryStatus  `gorm:"type:varchar(32);default:pending;index"`
+	Notice      string       `gorm:"type:text"`
+	LastQueryAt time.Time    `gorm:"index"`
+	// 连续失败次数和下一次允许查询的时间（指数退避）
+	Attempts      int
+	NextAttemptAt *time.Time `gorm:"index"`
+	// 最近一次成绩通知的时间和对应结果的指纹，保证同一结果只通知一次
+	NotifiedAt   *time.Time
+	NotifiedHash string `gorm:"type:varchar(64)"`
+	// 自助管理令牌的SHA-256摘要，令牌本身只在提交时返回一次
+	ManageTokenHash string `gorm:"type:varchar(64);index"`
+	// 提交请求Idempotency-Key的SHA-256摘要，用于识别客户端重试
+	SubmitKeyHash string `gorm:"type:varchar(64);index"`
+	// 邮箱验证时间，未验证的提交不会被查询
+	VerifiedAt *time.Time `gorm:"index"`
+	// 个人信息匿名化时间（见 PurgeRecord）
+	PurgedAt  *time.Time `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	// 通知渠道，为空时默认通过邮件通知
+	Channels []NotificationChannel `gorm:"foreignKey:UserID"`
+	// 本提交单独注册的Webhook地址
+	Webhooks []WebhookEndpoint `gorm:"foreignKey:UserID"`
+	// 额外的通知邮箱，如考生家长
+	Recipients []Recipient `gorm:"foreignKey:UserID"`
+	// 通知偏好，为空时使用默认设置
+	Preference *NotificationPreference `gorm:"foreignKey:UserID"`
 }
 
 func (User) TableName() string {
 	return "users"
 }
+
+// TransitionTo moves the user to the next query status if the state machine allows it
+func (u *User) TransitionTo(next QueryStatus) error {
+	current := u.Status
+	if current == "" {
+		current = QueryStatusPending
+	}
+	if !current.CanTransitionTo(next) {
+		return &InvalidTransitionError{From: current, To: next}
+	}
+	u.Status = next
+	return nil
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// Webhook event types
+const (
+	WebhookEventScoreReleased = "score.released"
+	WebhookEventStatusChanged = "status.changed"
+	WebhookEventQueryFailed   = "query.failed"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is a webhook URL registered for one submission. Global
+// endpoints come from the configuration and are not stored.
+type WebhookEndpoint struct {
+	ID     uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"index"`
+	URL    string `gorm:"type:text"`
+	// 签名密钥，未提供时在注册时随机生成；为空（早期记录）时不投递
+	Secret    string `gorm:"type:text;serializer:encrypted"`
+	CreatedAt time.Time
+}
+
+func (WebhookEndpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+// WebhookDelivery is one event sent to one URL, kept as the delivery log
+type WebhookDelivery struct {
+	ID      uint   `gorm:"primaryKey"`
+	EventID string `gorm:"type:varchar(64);index"`
+	Event   string `gorm:"type:varchar(32)"`
+	UserID  uint   `gorm:"index"`
+	// EndpointID is 0 for globally configured URLs
+	EndpointID uint
+	URL        string `gorm:"type:text"`
+	// 载荷含有成绩，与其他敏感字段一样加密存储
+	Payload       string `gorm:"type:text;serializer:encrypted"`
+	Status        string `gorm:"type:varchar(16);default:pending;index"`
+	Attempts      int
+	NextAttemptAt *time.Time `gorm:"index"`
+	ResponseCode  int
+	LastError     string `gorm:"type:text"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hosts of the bot APIs. A full webhook url copied from the vendor's
+// console is accepted only on these hosts.
+const (
+	weComHost    = "qyapi.weixin.qq.com"
+	dingTalkHost = "oapi.dingtalk.com"
+	feishuHost   = "open.feishu.cn"
+	larkHost     = "open.larksuite.com"
+	telegramHost = "api.telegram.org"
+)
+
+// 企业微信群机器人
+type weCom struct {
+	url string
+}
+
+func newWeCom(settings map[string]string) (Notifier, error) {
+	if u := setting(settings, "url", ""); u != "" {
+		u, err := checkURL("wecom", u, weComHost)
+		if err != nil {
+			return nil, err
+		}
+		return &weCom{url: u}, nil
+	}
+	values, err := require("wecom", settings, "key")
+	if err != nil {
+		return nil, err
+	}
+	return &weCom{url: "https://" + weComHost + "/cgi-bin/webhook/send?key=" + url.QueryEscape(values[0])}, nil
+}
+
+func (n *weCom) Send(ctx context.Context, msg Message) error {
+	resp, err := postJSON(ctx, n.url, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": "**" + msg.Title + "**\n" + msg.Text},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return checkCode(resp)
+}
+
+// 钉钉群机器人，配置secret时使用加签校验
+type dingTalk struct {
+	url    string
+	secret string
+}
+
+func newDingTalk(settings map[string]string) (Notifier, error) {
+	n := &dingTalk{url: setting(settings, "url", ""), secret: setting(settings, "secret", "")}
+	if n.url != "" {
+		var err error
+		if n.url, err = checkURL("dingtalk", n.url, dingTalkHost); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	values, err := require("dingtalk", settings, "access_token")
+	if err != nil {
+		return nil, err
+	}
+	n.url = "https://" + dingTalkHost + "/robot/send?access_token=" + url.QueryEscape(values[0])
+	return n, nil
+}
+
+func (n *dingTalk) Send(ctx context.Context, msg Message) error {
+	target := n.url
+	if n.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		sign := hmacBase64(n.secret, timestamp+"\n"+n.secret)
+		target += "&timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+
+	resp, err := postJSON(ctx, target, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"title": msg.Title, "text": "### " + msg.Title + "\n\n" + msg.Text},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return checkCode(resp)
+}
+
+// 飞书群机器人，配置secret时使用签名校验
+type feishu struct {
+	url    string
+	secret string
+}
+
+func newFeishu(settings map[string]string) (Notifier, error) {
+	n := &feishu{url: setting(settings, "url", ""), secret: setting(settings, "secret", "")}
+	if n.url != "" {
+		var err error
+		if n.url, err = checkURL("feishu", n.url, feishuHost, larkHost); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	values, err := require("feishu", settings, "token")
+	if err != nil {
+		return nil, err
+	}
+	n.url = "https://" + feishuHost + "/open-apis/bot/v2/hook/" + url.PathEscape(values[0])
+	return n, nil
+}
+
+func (n *feishu) Send(ctx context.Context, msg Message) error {
+	payload := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": msg.Title + "\n" + msg.Text},
+	}
+	if n.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		// 飞书以 timestamp\nsecret 作为HMAC密钥对空消息签名
+		payload["timestamp"] = timestamp
+		payload["sign"] = hmacBase64(timestamp+"\n"+n.secret, "")
+	}
+
+	resp, err := postJSON(ctx, n.url, payload, nil)
+	if err != nil {
+		return err
+	}
+	return checkCode(resp)
+}
+
+// Telegram Bot API
+type telegram struct {
+	url    string
+	chatID string
+}
+
+func newTelegram(settings map[string]string) (Notifier, error) {
+	values, err := require("telegram", settings, "bot_token", "chat_id")
+	if err != nil {
+		return nil, err
+	}
+	return &telegram{url: "https://" + telegramHost + "/bot" + url.PathEscape(values[0]) + "/sendMessage", chatID: values[1]}, nil
+}
+
+func (n *telegram) Send(ctx context.Context, msg Message) error {
+	resp, err := postJSON(ctx, n.url, map[string]interface{}{
+		"chat_id": n.chatID,
+		"text":    msg.Title + "\n\n" + msg.Text,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(resp), `"ok":true`) {
+		return fmt.Errorf("telegram: %s", truncate(string(resp), 200))
+	}
+	return nil
+}
+
+func hmacBase64(key string, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
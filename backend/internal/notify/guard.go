@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a notification target resolves to an
+// address that is not publicly routable, such as loopback, link-local
+// (including 169.254.169.254) or a private network.
+var ErrPrivateAddress = errors.New("destination is not a public address")
+
+// allowPrivate disables the address check, see AllowPrivateAddresses
+var allowPrivate atomic.Bool
+
+// AllowPrivateAddresses lets notifications and webhooks reach private and
+// loopback addresses. Targets are chosen by submitters, so this is only
+// meant for deployments whose receivers run on the local network.
+func AllowPrivateAddresses(allow bool) {
+	allowPrivate.Store(allow)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// netip does not count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether addr may be contacted on behalf of a submitter
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// checkDial rejects connections to non-public addresses. It runs after name
+// resolution, so it also covers redirects and DNS names pointing inwards.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	if allowPrivate.Load() {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, address)
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// NewHTTPClient returns a client for submitter-chosen URLs. It only connects
+// to public addresses and ignores proxy settings, so a proxy cannot be used
+// to reach internal services.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: checkDial}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// checkURL validates a submitter-supplied URL. With hosts it must be an
+// https URL on one of them; otherwise any http(s) URL is accepted and the
+// address is checked when connecting.
+func checkURL(channel string, raw string, hosts ...string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("%s channel requires an http(s) url", channel)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("%s channel url must not contain credentials", channel)
+	}
+	if len(hosts) == 0 {
+		return u.String(), nil
+	}
+	if u.Scheme != "https" || u.Port() != "" {
+		return "", fmt.Errorf("%s channel url must be an https url on %s", channel, strings.Join(hosts, ", "))
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return u.String(), nil
+		}
+	}
+	return "", fmt.Errorf("%s channel url must be on %s", channel, strings.Join(hosts, ", "))
+}
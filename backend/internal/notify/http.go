@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// httpClient sends every channel request; it only connects to public addresses
+var httpClient = NewHTTPClient(15 * time.Second)
+
+// post sends body to url and returns the response body of a 2xx response
+func post(ctx context.Context, url string, contentType string, body []byte, header map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(string(respBody), 200))
+	}
+	return respBody, nil
+}
+
+// postJSON marshals v and posts it as JSON
+func postJSON(ctx context.Context, url string, v interface{}, header map[string]string) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return post(ctx, url, "application/json; charset=utf-8", body, header)
+}
+
+// checkCode checks the application level status of bot APIs that answer
+// HTTP 200 with {"errcode": n, "errmsg": "..."} or {"code": n, "msg": "..."}
+func checkCode(respBody []byte) error {
+	var status struct {
+		ErrCode *int   `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+		Code    *int   `json:"code"`
+		Msg     string `json:"msg"`
+	}
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		return nil
+	}
+	if status.ErrCode != nil && *status.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %s", *status.ErrCode, status.ErrMsg)
+	}
+	if status.Code != nil && *status.Code != 0 {
+		return fmt.Errorf("code %d: %s", *status.Code, status.Msg)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
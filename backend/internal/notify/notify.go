@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Events carried by a Message
+const (
+	EventScore        = "score"
+	EventInfoMismatch = "info_mismatch"
+	EventFailed       = "failed"
+	EventStatusChange = "status_change"
+	EventDigest       = "digest"
+)
+
+// Message is a channel-neutral notification. Channels that render rich
+// content (email) use HTML, all others use Text.
+type Message struct {
+	Event string
+	Title string
+	Text  string
+	HTML  string
+	// Unsubscribe is a link that stops notifications for the submission
+	Unsubscribe string
+}
+
+// Notifier delivers a message through one configured channel
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Factory builds a notifier from the per-submission channel settings. It
+// returns an error when required settings are missing, so it doubles as
+// validation at submit time.
+type Factory func(settings map[string]string) (Notifier, error)
+
+// Registry maps channel names to their factories
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry returns a registry with all built-in HTTP channels. Channels
+// that need application services, such as email, are registered by the caller.
+func NewRegistry() *Registry {
+	r := &Registry{factories: make(map[string]Factory)}
+	r.Register("webhook", newWebhook)
+	r.Register("wecom", newWeCom)
+	r.Register("dingtalk", newDingTalk)
+	r.Register("feishu", newFeishu)
+	r.Register("telegram", newTelegram)
+	r.Register("bark", newBark)
+	r.Register("ntfy", newNtfy)
+	r.Register("serverchan", newServerChan)
+	return r
+}
+
+// Register adds or replaces a channel
+func (r *Registry) Register(name string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// New builds a notifier for the named channel
+func (r *Registry) New(name string, settings map[string]string) (Notifier, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown notification channel %q", name)
+	}
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	return factory(settings)
+}
+
+// Channels lists the registered channel names
+func (r *Registry) Channels() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// require returns the named settings or an error listing the missing ones
+func require(channel string, settings map[string]string, keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	var missing []string
+	for i, key := range keys {
+		values[i] = strings.TrimSpace(settings[key])
+		if values[i] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s channel requires setting(s): %s", channel, strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// setting returns the named setting or a default
+func setting(settings map[string]string, key string, defaultValue string) string {
+	if v := strings.TrimSpace(settings[key]); v != "" {
+		return v
+	}
+	return defaultValue
+}
@@ -0,0 +1,257 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type capturedRequest struct {
+	host   string
+	path   string
+	query  string
+	header http.Header
+	body   string
+}
+
+// redirectTransport sends every request to a local stand-in and keeps the
+// host it was meant for in the X-Original-Host header
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Original-Host", req.URL.Host)
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newStandIn starts a local HTTP stand-in that records requests and
+// answers with the given body. Channel requests are redirected to it for
+// the rest of the test.
+func newStandIn(t *testing.T, answer string) (*httptest.Server, *capturedRequest) {
+	t.Helper()
+	captured := &capturedRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*captured = capturedRequest{host: r.Header.Get("X-Original-Host"), path: r.URL.Path, query: r.URL.RawQuery, header: r.Header, body: string(body)}
+		io.WriteString(w, answer)
+	}))
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+	client := httpClient
+	httpClient = &http.Client{Transport: redirectTransport{target: target}}
+	t.Cleanup(func() { httpClient = client })
+	return srv, captured
+}
+
+func TestChannels(t *testing.T) {
+	msg := Message{Event: EventScore, Title: "考研成绩已发布", Text: "总分: 385"}
+
+	tests := []struct {
+		channel  string
+		answer   string
+		settings map[string]string
+		wantHost string
+		wantPath string
+		check    func(t *testing.T, req *capturedRequest)
+	}{
+		{
+			channel:  "webhook",
+			settings: map[string]string{"url": "https://hooks.example.com/hook"},
+			wantHost: "hooks.example.com",
+			wantPath: "/hook",
+			check: func(t *testing.T, req *capturedRequest) {
+				var payload map[string]string
+				json.Unmarshal([]byte(req.body), &payload)
+				if payload["event"] != EventScore || payload["text"] != "总分: 385" {
+					t.Errorf("payload = %v", payload)
+				}
+			},
+		},
+		{
+			channel:  "wecom",
+			answer:   `{"errcode":0,"errmsg":"ok"}`,
+			settings: map[string]string{"key": "k1"},
+			wantHost: weComHost,
+			wantPath: "/cgi-bin/webhook/send",
+			check: func(t *testing.T, req *capturedRequest) {
+				if req.query != "key=k1" || !strings.Contains(req.body, `"msgtype":"markdown"`) {
+					t.Errorf("query = %q, body = %s", req.query, req.body)
+				}
+			},
+		},
+		{
+			channel:  "dingtalk",
+			answer:   `{"errcode":0,"errmsg":"ok"}`,
+			settings: map[string]string{"access_token": "t1", "secret": "s"},
+			wantHost: dingTalkHost,
+			wantPath: "/robot/send",
+			check: func(t *testing.T, req *capturedRequest) {
+				if !strings.Contains(req.query, "access_token=t1") || !strings.Contains(req.query, "sign=") {
+					t.Errorf("query = %q, want token and signature", req.query)
+				}
+			},
+		},
+		{
+			channel:  "feishu",
+			answer:   `{"code":0,"msg":"success"}`,
+			settings: map[string]string{"token": "abc", "secret": "s"},
+			wantHost: feishuHost,
+			wantPath: "/open-apis/bot/v2/hook/abc",
+			check: func(t *testing.T, req *capturedRequest) {
+				if !strings.Contains(req.body, `"sign"`) || !strings.Contains(req.body, "总分: 385") {
+					t.Errorf("body = %s", req.body)
+				}
+			},
+		},
+		{
+			channel:  "telegram",
+			answer:   `{"ok":true,"result":{}}`,
+			settings: map[string]string{"bot_token": "123:abc", "chat_id": "42"},
+			wantHost: telegramHost,
+			wantPath: "/bot123:abc/sendMessage",
+			check: func(t *testing.T, req *capturedRequest) {
+				if !strings.Contains(req.body, `"chat_id":"42"`) {
+					t.Errorf("body = %s", req.body)
+				}
+			},
+		},
+		{
+			channel:  "bark",
+			answer:   `{"code":200,"message":"success"}`,
+			settings: map[string]string{"device_key": "dev"},
+			wantHost: "api.day.app",
+			wantPath: "/push",
+			check: func(t *testing.T, req *capturedRequest) {
+				if !strings.Contains(req.body, `"device_key":"dev"`) {
+					t.Errorf("body = %s", req.body)
+				}
+			},
+		},
+		{
+			channel:  "ntfy",
+			settings: map[string]string{"topic": "scores", "token": "tk"},
+			wantHost: "ntfy.sh",
+			wantPath: "/scores",
+			check: func(t *testing.T, req *capturedRequest) {
+				if req.body != "总分: 385" || req.header.Get("Authorization") != "Bearer tk" || req.header.Get("Title") == "" {
+					t.Errorf("body = %q, header = %v", req.body, req.header)
+				}
+			},
+		},
+		{
+			channel:  "serverchan",
+			answer:   `{"code":0,"message":""}`,
+			settings: map[string]string{"sendkey": "SCT1"},
+			wantHost: "sctapi.ftqq.com",
+			wantPath: "/SCT1.send",
+			check: func(t *testing.T, req *capturedRequest) {
+				if !strings.Contains(req.body, "desp=") {
+					t.Errorf("body = %s", req.body)
+				}
+			},
+		},
+	}
+
+	registry := NewRegistry()
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			_, captured := newStandIn(t, tt.answer)
+			notifier, err := registry.New(tt.channel, tt.settings)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := notifier.Send(context.Background(), msg); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if captured.host != tt.wantHost || captured.path != tt.wantPath {
+				t.Errorf("request to %s%s, want %s%s", captured.host, captured.path, tt.wantHost, tt.wantPath)
+			}
+			tt.check(t, captured)
+		})
+	}
+}
+
+func TestChannelErrors(t *testing.T) {
+	registry := NewRegistry()
+
+	if _, err := registry.New("telegram", map[string]string{"bot_token": "x"}); err == nil || !strings.Contains(err.Error(), "chat_id") {
+		t.Errorf("New(telegram) without chat_id error = %v", err)
+	}
+	if _, err := registry.New("pigeon", nil); err == nil {
+		t.Errorf("New(pigeon) succeeded, want unknown channel error")
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	AllowPrivateAddresses(true)
+	defer AllowPrivateAddresses(false)
+	notifier, _ := registry.New("webhook", map[string]string{"url": failing.URL})
+	if err := notifier.Send(context.Background(), Message{Title: "t"}); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Send() error = %v, want HTTP 502", err)
+	}
+
+	newStandIn(t, `{"errcode":93000,"errmsg":"invalid webhook url"}`)
+	notifier, _ = registry.New("wecom", map[string]string{"url": "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=k"})
+	if err := notifier.Send(context.Background(), Message{Title: "t"}); err == nil || !strings.Contains(err.Error(), "93000") {
+		t.Errorf("Send() error = %v, want errcode error", err)
+	}
+}
+
+func TestURLAndAddressChecks(t *testing.T) {
+	registry := NewRegistry()
+	for _, tc := range []struct {
+		channel  string
+		settings map[string]string
+	}{
+		{"wecom", map[string]string{"url": "https://169.254.169.254/latest/meta-data"}},
+		{"wecom", map[string]string{"url": "http://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=k"}},
+		{"dingtalk", map[string]string{"url": "https://oapi.dingtalk.com.evil.example/robot/send"}},
+		{"feishu", map[string]string{"url": "https://user@open.feishu.cn/open-apis/bot/v2/hook/x"}},
+		{"webhook", map[string]string{"url": "file:///etc/passwd"}},
+	} {
+		if _, err := registry.New(tc.channel, tc.settings); err == nil {
+			t.Errorf("New(%s, %v) accepted the url", tc.channel, tc.settings)
+		}
+	}
+	if _, err := registry.New("feishu", map[string]string{"url": "https://open.larksuite.com/open-apis/bot/v2/hook/x"}); err != nil {
+		t.Errorf("New(feishu) with a lark url: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	notifier, err := registry.New("webhook", map[string]string{"url": srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(context.Background(), Message{Title: "t"}); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Send() to loopback error = %v, want ErrPrivateAddress", err)
+	}
+	AllowPrivateAddresses(true)
+	defer AllowPrivateAddresses(false)
+	if err := notifier.Send(context.Background(), Message{Title: "t"}); err != nil {
+		t.Errorf("Send() with private addresses allowed: %v", err)
+	}
+
+	for addr, want := range map[string]bool{
+		"8.8.8.8": true, "2606:4700::1111": true, "127.0.0.1": false, "10.1.2.3": false, "192.168.0.1": false,
+		"169.254.169.254": false, "100.64.0.1": false, "::1": false, "fd00::1": false, "::ffff:127.0.0.1": false, "0.0.0.0": false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"mime"
+	"net/url"
+)
+
+// Bark (iOS)
+type bark struct {
+	url       string
+	deviceKey string
+}
+
+func newBark(settings map[string]string) (Notifier, error) {
+	values, err := require("bark", settings, "device_key")
+	if err != nil {
+		return nil, err
+	}
+	return &bark{url: "https://api.day.app/push", deviceKey: values[0]}, nil
+}
+
+func (n *bark) Send(ctx context.Context, msg Message) error {
+	_, err := postJSON(ctx, n.url, map[string]string{
+		"device_key": n.deviceKey,
+		"title":      msg.Title,
+		"body":       msg.Text,
+		"group":      "chsi",
+	}, nil)
+	return err
+}
+
+// ntfy
+type ntfy struct {
+	url   string
+	token string
+}
+
+func newNtfy(settings map[string]string) (Notifier, error) {
+	values, err := require("ntfy", settings, "topic")
+	if err != nil {
+		return nil, err
+	}
+	return &ntfy{url: "https://ntfy.sh/" + url.PathEscape(values[0]), token: setting(settings, "token", "")}, nil
+}
+
+func (n *ntfy) Send(ctx context.Context, msg Message) error {
+	header := map[string]string{"Title": mime.BEncoding.Encode("UTF-8", msg.Title), "Tags": "mortar_board"}
+	if n.token != "" {
+		header["Authorization"] = "Bearer " + n.token
+	}
+	_, err := post(ctx, n.url, "text/plain; charset=utf-8", []byte(msg.Text), header)
+	return err
+}
+
+// Server酱（ServerChan Turbo）及兼容的推送服务
+type serverChan struct {
+	url string
+}
+
+func newServerChan(settings map[string]string) (Notifier, error) {
+	values, err := require("serverchan", settings, "sendkey")
+	if err != nil {
+		return nil, err
+	}
+	return &serverChan{url: "https://sctapi.ftqq.com/" + url.PathEscape(values[0]) + ".send"}, nil
+}
+
+func (n *serverChan) Send(ctx context.Context, msg Message) error {
+	form := url.Values{}
+	form.Set("title", msg.Title)
+	form.Set("desp", msg.Text)
+	resp, err := post(ctx, n.url, "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	return checkCode(resp)
+}
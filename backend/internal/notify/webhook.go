@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// webhook posts the message as JSON to an arbitrary public URL
+type webhook struct {
+	url string
+}
+
+func newWebhook(settings map[string]string) (Notifier, error) {
+	values, err := require("webhook", settings, "url")
+	if err != nil {
+		return nil, err
+	}
+	u, err := checkURL("webhook", values[0])
+	if err != nil {
+		return nil, err
+	}
+	return &webhook{url: u}, nil
+}
+
+func (n *webhook) Send(ctx context.Context, msg Message) error {
+	_, err := postJSON(ctx, n.url, map[string]interface{}{
+		"event": msg.Event,
+		"title": msg.Title,
+		"text":  msg.Text,
+		"time":  time.Now().Format(time.RFC3339),
+	}, nil)
+	return err
+}
@@ -0,0 +1,79 @@
+package repo
+
+import (
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+)
+
+type QueryAttemptRepo struct {
+	db *gorm.DB
+}
+
+func NewQueryAttemptRepo(db *gorm.DB) *QueryAttemptRepo {
+	return &QueryAttemptRepo{db: db}
+}
+
+// Create records an attempt and keeps only the newest keep attempts of the
+// user; a non-positive keep does not limit them
+func (r *QueryAttemptRepo) Create(attempt *model.QueryAttempt, keep int) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(attempt).Error; err != nil {
+			return err
+		}
+		if keep <= 0 {
+			return nil
+		}
+		newest := tx.Model(&model.QueryAttempt{}).Select("id").
+			Where("user_id = ?", attempt.UserID).Order("id DESC").Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", attempt.UserID, newest).Delete(&model.QueryAttempt{}).Error
+	})
+	if err != nil {
+		logger.Error("Failed to record query attempt: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListByUser returns the attempts of a user, newest first, without their
+// snapshots
+func (r *QueryAttemptRepo) ListByUser(userID uint, limit, offset int) ([]model.QueryAttempt, int64, error) {
+	query := r.db.Model(&model.QueryAttempt{}).Where("user_id = ?", userID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Error("Failed to count query attempts: %v", err)
+		return nil, 0, err
+	}
+	var attempts []model.QueryAttempt
+	if err := query.Omit("snapshot").Order("id DESC").Limit(limit).Offset(offset).Find(&attempts).Error; err != nil {
+		logger.Error("Failed to list query attempts: %v", err)
+		return nil, 0, err
+	}
+	return attempts, total, nil
+}
+
+// FindByID returns an attempt including its snapshot
+func (r *QueryAttemptRepo) FindByID(id uint) (*model.QueryAttempt, error) {
+	var attempt model.QueryAttempt
+	if err := r.db.First(&attempt, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find query attempt: %v", err)
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// DeleteBefore removes the attempts started before the cutoff
+func (r *QueryAttemptRepo) DeleteBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("started_at < ?", cutoff).Delete(&model.QueryAttempt{})
+	if result.Error != nil {
+		logger.Error("Failed to delete old query attempts: %v", result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
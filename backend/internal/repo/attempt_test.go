@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+)
+
+func TestQueryAttempts(t *testing.T) {
+	attempts := NewQueryAttemptRepo(newTestDB(t))
+	old := time.Now().Add(-48 * time.Hour)
+
+	page := "<html>" + strings.Repeat("成绩尚未发布", 100) + "</html>"
+	for i, status := range []model.QueryStatus{model.QueryStatusNotPublished, model.QueryStatusNotPublished, model.QueryStatusScoreReleased} {
+		attempt := &model.QueryAttempt{UserID: 1, StartedAt: old.Add(time.Duration(i) * 24 * time.Hour), HTTPStatus: 200, Status: status}
+		attempt.SetSnapshot(page)
+		if err := attempts.Create(attempt, 2); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if err := attempts.Create(&model.QueryAttempt{UserID: 2, StartedAt: old, ErrorClass: model.AttemptErrorTimeout}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the newest two attempts of user 1 are kept
+	list, total, err := attempts.ListByUser(1, 10, 0)
+	if err != nil || total != 2 {
+		t.Fatalf("ListByUser() = %d, %v; want 2", total, err)
+	}
+	if list[0].Status != model.QueryStatusScoreReleased || !list[0].StartedAt.After(list[1].StartedAt) {
+		t.Errorf("attempts not newest first: %+v", list)
+	}
+	if list[0].Snapshot != "" || list[0].SnapshotSize != len(page) {
+		t.Errorf("listed attempt carries its snapshot: %d bytes", len(list[0].Snapshot))
+	}
+
+	stored, err := attempts.FindByID(list[0].ID)
+	if err != nil || stored == nil {
+		t.Fatalf("FindByID() = %v, %v", stored, err)
+	}
+	if got, err := stored.SnapshotPage(); err != nil || got != page || stored.SnapshotSize != len(page) {
+		t.Errorf("snapshot = %d bytes, %v; want the stored page", len(got), err)
+	}
+	if len(stored.Snapshot) >= len(page) {
+		t.Errorf("snapshot is not compressed: %d >= %d", len(stored.Snapshot), len(page))
+	}
+
+	deleted, err := attempts.DeleteBefore(time.Now().Add(-time.Hour))
+	if err != nil || deleted != 2 {
+		t.Errorf("DeleteBefore() = %d, %v; want 2", deleted, err)
+	}
+	if _, total, _ := attempts.ListByUser(1, 10, 0); total != 1 {
+		t.Errorf("%d attempts left for user 1, want 1", total)
+	}
+}
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationRepo struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepo(db *gorm.DB) *NotificationRepo {
+	return &NotificationRepo{db: db}
+}
+
+// enqueueNotification adds a notification to the outbox inside tx. An entry
+// with the same idempotency key is kept as it is.
+func enqueueNotification(tx *gorm.DB, n *model.Notification) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "idempotency_key"}},
+		DoNothing: true,
+	}).Create(n).Error
+}
+
+// Enqueue adds a notification to the outbox
+func (r *NotificationRepo) Enqueue(n *model.Notification) error {
+	if err := enqueueNotification(r.db, n); err != nil {
+		logger.Error("Failed to enqueue notification: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FindDue returns pending notifications whose next attempt is due
+func (r *NotificationRepo) FindDue(now time.Time, limit int) ([]model.Notification, error) {
+	var notifications []model.Notification
+	err := r.db.Where("status = ?", model.NotificationPending).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("id").Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		logger.Error("Failed to find due notifications: %v", err)
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (r *NotificationRepo) FindByID(id uint) (*model.Notification, error) {
+	var n model.Notification
+	if err := r.db.First(&n, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find notification: %v", err)
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (r *NotificationRepo) Update(n *model.Notification) error {
+	if err := r.db.Save(n).Error; err != nil {
+		logger.Error("Failed to update notification: %v", err)
+		return err
+	}
+	return nil
+}
+
+// List returns outbox entries, newest first. A zero userID or an empty
+// status matches all entries.
+func (r *NotificationRepo) List(userID uint, status string, limit, offset int) ([]model.Notification, int64, error) {
+	query := r.db.Model(&model.Notification{})
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Error("Failed to count notifications: %v", err)
+		return nil, 0, err
+	}
+	var notifications []model.Notification
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&notifications).Error; err != nil {
+		logger.Error("Failed to list notifications: %v", err)
+		return nil, 0, err
+	}
+	return notifications, total, nil
+}
+
+// Requeue gives a notification a fresh retry budget and makes it due now.
+// Channels that already received it are not sent to again.
+func (r *NotificationRepo) Requeue(n *model.Notification) error {
+	n.Status = model.NotificationPending
+	n.Attempts = 0
+	n.NextAttemptAt = nil
+	return r.Update(n)
+}
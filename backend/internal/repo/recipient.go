@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FindRecipients returns the additional email recipients of a user
+func (r *UserRepo) FindRecipients(userID uint) ([]model.Recipient, error) {
+	var recipients []model.Recipient
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&recipients).Error; err != nil {
+		logger.Error("Failed to find recipients: %v", err)
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// FindPreference returns the notification preference of a user, or the
+// default one if none was saved
+func (r *UserRepo) FindPreference(userID uint) (*model.NotificationPreference, error) {
+	pref, err := findPreference(r.db, userID)
+	if err != nil {
+		logger.Error("Failed to find notification preference: %v", err)
+		return nil, err
+	}
+	return pref, nil
+}
+
+func findPreference(tx *gorm.DB, userID uint) (*model.NotificationPreference, error) {
+	var pref model.NotificationPreference
+	if err := tx.Where("user_id = ?", userID).First(&pref).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return model.DefaultNotificationPreference(userID), nil
+		}
+		return nil, err
+	}
+	return &pref, nil
+}
+
+// SaveNotificationSettings stores the preference of a user and replaces the
+// recipients. A nil preference or nil recipients leave that part unchanged.
+// Recipients already stored keep their confirmation; the newly added ones
+// are returned so they can be asked to confirm.
+func (r *UserRepo) SaveNotificationSettings(userID uint, pref *model.NotificationPreference, recipients []model.Recipient) ([]model.Recipient, error) {
+	var added []model.Recipient
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if pref != nil {
+			pref.UserID = userID
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"score_released", "status_change", "daily_digest", "updated_at"}),
+			}).Create(pref).Error
+			if err != nil {
+				return err
+			}
+		}
+		if recipients == nil {
+			return nil
+		}
+
+		var existing []model.Recipient
+		if err := tx.Where("user_id = ?", userID).Find(&existing).Error; err != nil {
+			return err
+		}
+		kept := map[string]bool{}
+		for _, recipient := range recipients {
+			kept[strings.ToLower(recipient.Email)] = true
+		}
+		stored := map[string]bool{}
+		for _, recipient := range existing {
+			if !kept[strings.ToLower(recipient.Email)] {
+				if err := tx.Delete(&recipient).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			stored[strings.ToLower(recipient.Email)] = true
+		}
+		for _, recipient := range recipients {
+			if stored[strings.ToLower(recipient.Email)] {
+				continue
+			}
+			recipient.ID = 0
+			recipient.UserID = userID
+			if err := tx.Create(&recipient).Error; err != nil {
+				return err
+			}
+			added = append(added, recipient)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to save notification settings of user %d: %v", userID, err)
+		return nil, err
+	}
+	return added, nil
+}
+
+// VerifyRecipient records that a recipient confirmed its address. Returns
+// nil if the recipient was removed in the meantime.
+func (r *UserRepo) VerifyRecipient(id uint) (*model.Recipient, error) {
+	var recipient model.Recipient
+	if err := r.db.First(&recipient, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find recipient: %v", err)
+		return nil, err
+	}
+	if recipient.VerifiedAt != nil {
+		return &recipient, nil
+	}
+
+	now := time.Now()
+	if err := r.db.Model(&recipient).Update("verified_at", now).Error; err != nil {
+		logger.Error("Failed to verify recipient: %v", err)
+		return nil, err
+	}
+	recipient.VerifiedAt = &now
+	return &recipient, nil
+}
+
+// DeleteRecipient removes a single recipient, e.g. when it unsubscribes.
+// The submission and its other recipients are left alone. Returns nil if the
+// recipient does not exist.
+func (r *UserRepo) DeleteRecipient(id uint) (*model.Recipient, error) {
+	var recipient model.Recipient
+	if err := r.db.First(&recipient, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find recipient: %v", err)
+		return nil, err
+	}
+	if err := r.db.Delete(&recipient).Error; err != nil {
+		logger.Error("Failed to delete recipient: %v", err)
+		return nil, err
+	}
+	return &recipient, nil
+}
+
+// FindDigestCandidates returns the verified users with the daily digest
+// enabled whose score is still awaited
+func (r *UserRepo) FindDigestCandidates() ([]model.User, error) {
+	var users []model.User
+	err := r.db.Joins("JOIN notification_preferences ON notification_preferences.user_id = users.id").
+		Where("notification_preferences.daily_digest = ? AND users.status IN ? AND users.verified_at IS NOT NULL",
+			true, model.PollingStatuses()).
+		Find(&users).Error
+	if err != nil {
+		logger.Error("Failed to find digest candidates: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
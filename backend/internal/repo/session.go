@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+)
+
+type SessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+func (r *SessionRepo) FindByAccount(account string) (*model.ChsiSession, error) {
+	var session model.ChsiSession
+	if err := r.db.Where("account = ?", account).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find chsi session: %v", err)
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepo) Save(session *model.ChsiSession) error {
+	if err := r.db.Save(session).Error; err != nil {
+		logger.Error("Failed to save chsi session: %v", err)
+		return err
+	}
+	return nil
+}
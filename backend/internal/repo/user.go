@@ -1,9 +1,15 @@
 package repo
 
 import (
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/internal/model"
-"gorm.io/gorm"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepo struct {
@@ -46,9 +52,167 @@ func (r *UserRepo) FindByEmail(email string) (*model.User, error) {
 	return &user, nil
 }
 
+// FindByInfoHash looks up a submission by its keyed info hash
+func (r *UserRepo) FindByInfoHash(infoHash string) (*model.User, error) {
+	var user model.User
+	if err := r.db.Where("info_hash = ?", infoHash).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find user by info hash: %v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// InfoHashExists reports whether any submission, including a deleted one
+// that still holds the unique index, has the info hash
+func (r *UserRepo) InfoHashExists(infoHash string) (bool, error) {
+	var count int64
+	if err := r.db.Unscoped().Model(&model.User{}).Where("info_hash = ?", infoHash).Count(&count).Error; err != nil {
+		logger.Error("Failed to check info hash: %v", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// WasImported reports whether an entry with the info hash was handled by an
+// earlier import
+func (r *UserRepo) WasImported(infoHash string) (bool, error) {
+	var count int64
+	if err := r.db.Model(&model.ImportRecord{}).Where("entry_hash = ?", infoHash).Count(&count).Error; err != nil {
+		logger.Error("Failed to check import record: %v", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// MarkImported records an imported entry that did not create a user, e.g.
+// because the same submission already exists
+func (r *UserRepo) MarkImported(infoHash string) error {
+	record := &model.ImportRecord{EntryHash: infoHash}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(record).Error; err != nil {
+		logger.Error("Failed to record imported entry: %v", err)
+		return err
+	}
+	return nil
+}
+
+// CreateImported creates an imported user together with its import record
+func (r *UserRepo) CreateImported(user *model.User) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.ImportRecord{EntryHash: user.InfoHash}).Error; err != nil {
+			return err
+		}
+		return tx.Create(user).Error
+	})
+	if err != nil {
+		logger.Error("Failed to create imported user: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FindAnyByInfoHash finds a user by info hash including soft-deleted ones,
+// which still hold the unique hash
+func (r *UserRepo) FindAnyByInfoHash(infoHash string) (*model.User, error) {
+	var user model.User
+	if err := r.db.Unscoped().Where("info_hash = ?", infoHash).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find user by info hash: %v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindBySubmitKey finds the submission created with an Idempotency-Key hash
+func (r *UserRepo) FindBySubmitKey(hash string) (*model.User, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	var user model.User
+	if err := r.db.Where("submit_key_hash = ?", hash).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find user by submit key: %v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindByManageToken looks up a submission by the hash of its management token
+func (r *UserRepo) FindByManageToken(hash string) (*model.User, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	var user model.User
+	if err := r.db.Where("manage_token_hash = ?", hash).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find user by management token: %v", err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindAllByEmail returns every submission registered with the email
+func (r *UserRepo) FindAllByEmail(email string) ([]model.User, error) {
+	var users []model.User
+	if err := r.db.Where("email = ?", email).Find(&users).Error; err != nil {
+		logger.Error("Failed to find users by email: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// UserFilter selects users in List. Zero fields match all users.
+type UserFilter struct {
+	Status        model.QueryStatus
+	SchoolCode    string
+	QueriedAfter  *time.Time
+	QueriedBefore *time.Time
+}
+
+// List returns users matching the filter, oldest first, and the total count
+func (r *UserRepo) List(filter UserFilter, limit, offset int) ([]model.User, int64, error) {
+	query := r.db.Model(&model.User{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.SchoolCode != "" {
+		query = query.Where("school_code = ?", filter.SchoolCode)
+	}
+	if filter.QueriedAfter != nil {
+		query = query.Where("last_query_at >= ?", *filter.QueriedAfter)
+	}
+	if filter.QueriedBefore != nil {
+		query = query.Where("last_query_at < ?", *filter.QueriedBefore)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Error("Failed to count users: %v", err)
+		return nil, 0, err
+	}
+	var users []model.User
+	if err := query.Order("id").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+		logger.Error("Failed to list users: %v", err)
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// FindPending returns verified users still being polled whose backoff has elapsed
 func (r *UserRepo) FindPending() ([]model.User, error) {
 	var users []model.User
-	if err := r.db.Where("score IS NULL OR score = ''").Find(&users).Error; err != nil {
+	if err := r.db.Where("status IN ?", model.PollingStatuses()).
+		Where("verified_at IS NOT NULL").
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", time.Now()).
+		Find(&users).Error; err != nil {
 		logger.Error("Failed to find pending users: %v", err)
 		return nil, err
 	}
@@ -63,6 +227,491 @@ func (r *UserRepo) Update(user *model.User) error {
 	return nil
 }
 
+// SaveResult persists a parsed query result together with the notification
+// marker in one transaction. It returns true when the caller is the first to
+// see this distinct result and must send the notification. Results the user
+// may not move to, e.g. after pausing during the query, are discarded.
+func (r *UserRepo) SaveResult(user *model.User, result *model.ScoreResult) (bool, error) {
+	notify := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var current model.User
+		if err := tx.First(&current, user.ID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				// Deleted while the query was running
+				return nil
+			}
+			return err
+		}
+
+		pref, err := findPreference(tx, current.ID)
+		if err != nil {
+			return err
+		}
+
+		stored := current.Status
+		previous := current.Status
+		if previous == "" {
+			previous = model.QueryStatusPending
+		}
+		// 查询期间被暂停、停止或已结束时丢弃结果，不写入也不通知
+		if err := current.TransitionTo(result.Status); err != nil {
+			logger.Warn("Discarding query result for user %d: %v", current.ID, err)
+			return nil
+		}
+		current.Score = result
+		current.Notice = result.Message
+		current.LastQueryAt = time.Now()
+		current.Attempts = 0
+		current.NextAttemptAt = nil
+
+		// 成绩通知受偏好控制，信息不匹配总是通知；同一结果只记录一次
+		var notifications []*model.Notification
+		if result.Notifiable() && current.NotifiedHash != result.Fingerprint() {
+			now := time.Now()
+			current.NotifiedAt = &now
+			current.NotifiedHash = result.Fingerprint()
+			if result.Status == model.QueryStatusInfoMismatch || pref.ScoreReleased {
+				notifications = append(notifications, model.NewResultNotification(current.ID, result))
+			}
+		}
+		if pref.StatusChange && current.Status != previous && len(notifications) == 0 {
+			notifications = append(notifications, model.NewStatusChangeNotification(current.ID, previous, result))
+		}
+
+		// 只写入查询结果相关的列，且仅在查询期间状态未被修改时写入
+		saved := tx.Model(&current).Where("status = ?", stored).
+			Select("status", "score", "notice", "last_query_at", "attempts", "next_attempt_at", "notified_at", "notified_hash").
+			Updates(&current)
+		if saved.Error != nil {
+			return saved.Error
+		}
+		if saved.RowsAffected == 0 {
+			return nil
+		}
+		for _, n := range notifications {
+			if err := enqueueNotification(tx, n); err != nil {
+				return err
+			}
+		}
+		notify = len(notifications) > 0
+		*user = current
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to save query result: %v", err)
+		return false, err
+	}
+	return notify, nil
+}
+
+// UpdatePolling applies the outcome of a query to a user that is still
+// being polled. The user is reloaded in a transaction and passed to update,
+// which may return a notification to queue with the change; only the query
+// columns are written, and only while the user is still polled, so changes
+// made during the query (pause, edit, delete) are kept. It returns nil if the
+// user is gone or no longer polled.
+func (r *UserRepo) UpdatePolling(id uint, update func(user *model.User) *model.Notification) (*model.User, error) {
+	var updated *model.User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var current model.User
+		if err := tx.First(&current, id).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+		if !current.Status.Polling() {
+			return nil
+		}
+
+		n := update(&current)
+		result := tx.Model(&model.User{}).
+			Where("id = ? AND status IN ?", id, model.PollingStatuses()).
+			Updates(map[string]interface{}{
+				"status":          current.Status,
+				"notice":          current.Notice,
+				"last_query_at":   current.LastQueryAt,
+				"attempts":        current.Attempts,
+				"next_attempt_at": current.NextAttemptAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if n != nil {
+			if err := enqueueNotification(tx, n); err != nil {
+				return err
+			}
+		}
+		updated = &current
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to update user %d: %v", id, err)
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Verify marks the user's email as verified. Verifying twice keeps the first timestamp.
+func (r *UserRepo) Verify(id uint) (*model.User, error) {
+	if err := r.db.Model(&model.User{}).
+		Where("id = ? AND verified_at IS NULL", id).
+		Update("verified_at", time.Now()).Error; err != nil {
+		logger.Error("Failed to verify user: %v", err)
+		return nil, err
+	}
+	return r.FindByID(id)
+}
+
+// ErrStatusChanged is returned when a user's status kept changing while an
+// update that depends on it was being written, e.g. a query result saved
+// by the scheduler in the meantime
+var ErrStatusChanged = errors.New("submission status changed concurrently")
+
+// transition moves the user to next and writes only the status and the given
+// columns, on the condition that the status is still the one read. A
+// concurrent change, such as a query result saved by the scheduler, is never
+// overwritten; the transition is then checked again against the new state.
+// It returns nil if the user does not exist.
+func (r *UserRepo) transition(id uint, next model.QueryStatus, columns []string, change func(user *model.User), after func(tx *gorm.DB, user *model.User) error) (*model.User, error) {
+	for try := 0; try < 3; try++ {
+		user, err := r.FindByID(id)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		stored := user.Status
+		if err := user.TransitionTo(next); err != nil {
+			return nil, err
+		}
+		change(user)
+
+		updated := false
+		err = r.db.Transaction(func(tx *gorm.DB) error {
+			saved := tx.Model(user).Omit(clause.Associations).Where("status = ?", stored).
+				Select(append([]string{"status"}, columns...)).Updates(user)
+			if saved.Error != nil || saved.RowsAffected == 0 {
+				return saved.Error
+			}
+			updated = true
+			if after != nil {
+				return after(tx, user)
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("Failed to move user %d to %s: %v", id, next, err)
+			return nil, err
+		}
+		if updated {
+			return user, nil
+		}
+	}
+	return nil, ErrStatusChanged
+}
+
+// Stop moves a user to the stopped state so it is neither queried nor
+// notified again, and cancels the notifications still queued for it. It
+// returns nil if the user does not exist.
+func (r *UserRepo) Stop(id uint) (*model.User, error) {
+	return r.transition(id, model.QueryStatusStopped, []string{"next_attempt_at"},
+		func(user *model.User) {
+			user.NextAttemptAt = nil
+		},
+		func(tx *gorm.DB, user *model.User) error {
+			return tx.Model(&model.Notification{}).
+				Where("user_id = ? AND status = ?", user.ID, model.NotificationPending).
+				Updates(map[string]interface{}{"status": model.NotificationCanceled, "next_attempt_at": nil}).Error
+		})
+}
+
+// Resume moves a stopped, failed or mismatched user back to pending so it is
+// queried again with a fresh retry budget. It returns nil if the user does
+// not exist.
+func (r *UserRepo) Resume(id uint) (*model.User, error) {
+	return r.transition(id, model.QueryStatusPending, []string{"attempts", "next_attempt_at"},
+		func(user *model.User) {
+			user.Attempts = 0
+			user.NextAttemptAt = nil
+		}, nil)
+}
+
+// ResetFailed moves failed users back to pending with a fresh retry budget.
+// Without ids every failed user is reset. It returns the number reset.
+func (r *UserRepo) ResetFailed(ids ...uint) (int64, error) {
+	query := r.db.Model(&model.User{}).Where("status = ?", model.QueryStatusFailed)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":          model.QueryStatusPending,
+		"attempts":        0,
+		"next_attempt_at": nil,
+	})
+	if result.Error != nil {
+		logger.Error("Failed to reset failed users: %v", result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// UpdateInfo saves corrected submission data, writing only the columns the
+// owner can change. When requery is set the data identifying the candidate
+// changed, so the previous result is dropped and the user is queried again
+// from scratch; this fails with ErrStatusChanged if a query result was saved
+// since user was read.
+func (r *UserRepo) UpdateInfo(user *model.User, requery bool) error {
+	columns := []string{"name", "id_card", "exam_id", "school_code", "email", "locale", "info_hash", "verified_at"}
+	query := r.db.Model(user).Omit(clause.Associations)
+	if requery {
+		query = query.Where("status = ?", user.Status)
+		if err := user.TransitionTo(model.QueryStatusPending); err != nil {
+			return err
+		}
+		user.Score = nil
+		user.Notice = ""
+		user.Attempts = 0
+		user.NextAttemptAt = nil
+		user.NotifiedAt = nil
+		user.NotifiedHash = ""
+		columns = append(columns, "status", "score", "notice", "attempts", "next_attempt_at", "notified_at", "notified_hash")
+	}
+
+	saved := query.Select(columns).Updates(user)
+	if saved.Error != nil {
+		logger.Error("Failed to update user: %v", saved.Error)
+		return saved.Error
+	}
+	if requery && saved.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// Reactivate stores user in place of the soft-deleted or finished
+// submission existing with the same info hash. Previous channels, webhooks,
+// recipients, preferences and notifications are replaced and querying starts
+// over.
+func (r *UserRepo) Reactivate(existing *model.User, user *model.User) error {
+	user.ID = existing.ID
+	user.CreatedAt = existing.CreatedAt
+	user.Status = model.QueryStatusPending
+	user.DeletedAt = gorm.DeletedAt{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteUserRelations(tx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Omit(clause.Associations).Save(user).Error; err != nil {
+			return err
+		}
+		for i := range user.Channels {
+			user.Channels[i].ID = 0
+			user.Channels[i].UserID = user.ID
+		}
+		if len(user.Channels) > 0 {
+			if err := tx.Create(&user.Channels).Error; err != nil {
+				return err
+			}
+		}
+		for i := range user.Webhooks {
+			user.Webhooks[i].ID = 0
+			user.Webhooks[i].UserID = user.ID
+		}
+		if len(user.Webhooks) > 0 {
+			if err := tx.Create(&user.Webhooks).Error; err != nil {
+				return err
+			}
+		}
+		for i := range user.Recipients {
+			user.Recipients[i].ID = 0
+			user.Recipients[i].UserID = user.ID
+		}
+		if len(user.Recipients) > 0 {
+			if err := tx.Create(&user.Recipients).Error; err != nil {
+				return err
+			}
+		}
+		if user.Preference != nil {
+			user.Preference.ID = 0
+			user.Preference.UserID = user.ID
+			return tx.Create(user.Preference).Error
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to reactivate user %d: %v", existing.ID, err)
+		return err
+	}
+	return nil
+}
+
+// UpdateManageToken replaces the management token hash of a user
+func (r *UserRepo) UpdateManageToken(id uint, hash string) error {
+	if err := r.db.Model(&model.User{}).Where("id = ?", id).Update("manage_token_hash", hash).Error; err != nil {
+		logger.Error("Failed to update management token: %v", err)
+		return err
+	}
+	return nil
+}
+
+// PurgeUnverified permanently removes submissions never verified before the cutoff
+func (r *UserRepo) PurgeUnverified(before time.Time) (int64, error) {
+	var count int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := tx.Unscoped().Model(&model.User{}).
+			Where("verified_at IS NULL AND created_at < ?", before).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := deleteUserRelations(tx, ids...); err != nil {
+			return err
+		}
+		result := tx.Unscoped().Delete(&model.User{}, ids)
+		count = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		logger.Error("Failed to purge unverified users: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// HardDelete permanently removes a user record and its related rows
+func (r *UserRepo) HardDelete(id uint) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteUserRelations(tx, id); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&model.User{}, id).Error
+	})
+	if err != nil {
+		logger.Error("Failed to hard delete user: %v", err)
+		return err
+	}
+	return nil
+}
+
+// deleteUserRelations removes rows that belong to the given users
+func deleteUserRelations(tx *gorm.DB, ids ...uint) error {
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.NotificationChannel{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.WebhookEndpoint{}).Error; err != nil {
+		return err
+	}
+	// 投递记录的载荷含有成绩等个人数据
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.WebhookDelivery{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.QueryAttempt{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.Recipient{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id IN ?", ids).Delete(&model.NotificationPreference{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id IN ?", ids).Delete(&model.Notification{}).Error
+}
+
+// FindChannels returns the notification channels chosen for a user
+func (r *UserRepo) FindChannels(userID uint) ([]model.NotificationChannel, error) {
+	var channels []model.NotificationChannel
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&channels).Error; err != nil {
+		logger.Error("Failed to find notification channels: %v", err)
+		return nil, err
+	}
+	return channels, nil
+}
+
+// piiColumns are the columns cleared when a user is anonymized
+var piiColumns = []string{"name", "id_card", "exam_id", "email"}
+
+// FindPurgeCandidates returns users whose personal data is due for removal:
+// results delivered before the cutoff, failed queries older than the cutoff,
+// and soft-deleted records that still hold data. Users with notifications
+// still waiting in the outbox are kept until those are delivered.
+func (r *UserRepo) FindPurgeCandidates(cutoff time.Time) ([]model.User, error) {
+	delivered := r.db.Model(&model.Notification{}).Select("1").
+		Where("notifications.user_id = users.id AND event IN ? AND status = ? AND sent_at < ?",
+			[]string{model.NotificationEventScore, model.NotificationEventInfoMismatch}, model.NotificationSent, cutoff)
+	undelivered := r.db.Model(&model.Notification{}).Select("1").
+		Where("notifications.user_id = users.id AND status = ?", model.NotificationPending)
+
+	var users []model.User
+	err := r.db.Unscoped().
+		Where("purged_at IS NULL").
+		Where(r.db.
+			Where("deleted_at IS NOT NULL").
+			Or(r.db.Where("NOT EXISTS (?)", undelivered).Where(r.db.
+				Where("status NOT IN ? AND EXISTS (?)", model.PollingStatuses(), delivered).
+				Or("status IN ? AND last_query_at < ?", []model.QueryStatus{model.QueryStatusFailed, model.QueryStatusRejected}, cutoff)))).
+		Find(&users).Error
+	if err != nil {
+		logger.Error("Failed to find purge candidates: %v", err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// Purge removes a user's personal data and records the removal. In
+// anonymize mode the row is kept with its status and score but without
+// name, ID card, exam ID and email; in delete mode the row is removed.
+func (r *UserRepo) Purge(user *model.User, mode string, reason string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		fields := strings.Join(piiColumns, ",") + ",score,notice,channels,webhooks,webhook_deliveries,recipients,notification_preferences,notifications,query_attempts"
+
+		if err := deleteUserRelations(tx, user.ID); err != nil {
+			return err
+		}
+
+		switch mode {
+		case model.PurgeModeDelete:
+			if err := tx.Unscoped().Delete(&model.User{}, user.ID).Error; err != nil {
+				return err
+			}
+			fields = "*"
+		default:
+			mode = model.PurgeModeAnonymize
+			// 成绩含姓名和考生编号，说明文字可能含有CHSI返回的个人信息
+			updates := map[string]interface{}{
+				"info_hash": fmt.Sprintf("purged-%d", user.ID),
+				"purged_at": now,
+				"score":     nil,
+				"notice":    "",
+			}
+			for _, column := range piiColumns {
+				updates[column] = ""
+			}
+			if err := tx.Unscoped().Model(&model.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&model.PurgeRecord{
+			UserID:   user.ID,
+			Mode:     mode,
+			Reason:   reason,
+			Fields:   fields,
+			PurgedAt: now,
+		}).Error
+	})
+	if err != nil {
+		logger.Error("Failed to purge user %d: %v", user.ID, err)
+		return err
+	}
+	return nil
+}
+
 func (r *UserRepo) Delete(id uint) error {
 	if err := r.db.Delete(&model.User{}, id).Error; err != nil {
 		logger.Error("Failed to delete user: %v", err)
@@ -0,0 +1,511 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	database "chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestSaveResultNotifiesOncePerResult(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	now := time.Now()
+	user := &model.User{Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if pendingUsers, _ := userRepo.FindPending(); len(pendingUsers) != 1 {
+		t.Fatalf("FindPending() = %d users, want 1", len(pendingUsers))
+	}
+
+	pending := &model.ScoreResult{Status: model.QueryStatusNotPublished, Message: "成绩尚未发布"}
+	if notify, err := userRepo.SaveResult(user, pending); err != nil || notify {
+		t.Fatalf("SaveResult(not_published) = %v, %v; want false, nil", notify, err)
+	}
+
+	released := &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "385", Raw: `{"zf":"385"}`}
+	if notify, err := userRepo.SaveResult(user, released); err != nil || !notify {
+		t.Fatalf("SaveResult(score_released) = %v, %v; want true, nil", notify, err)
+	}
+	if notify, err := userRepo.SaveResult(user, released); err != nil || notify {
+		t.Fatalf("SaveResult(same result) = %v, %v; want false, nil", notify, err)
+	}
+
+	stored, err := userRepo.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("FindByID() error = %v", err)
+	}
+	if stored.Status != model.QueryStatusScoreReleased || stored.Score == nil || stored.Score.Total != "385" {
+		t.Errorf("stored status = %q, score = %+v", stored.Status, stored.Score)
+	}
+	if stored.NotifiedAt == nil || stored.NotifiedHash != released.Fingerprint() {
+		t.Errorf("notification marker not stored: %v %q", stored.NotifiedAt, stored.NotifiedHash)
+	}
+
+	pendingUsers, err := userRepo.FindPending()
+	if err != nil || len(pendingUsers) != 0 {
+		t.Errorf("FindPending() = %d users, %v; want none", len(pendingUsers), err)
+	}
+
+	admitted := &model.ScoreResult{Status: model.QueryStatusAdmitted, Total: "385", Admission: "拟录取", Raw: `{"zf":"385","lqzt":"拟录取"}`}
+	if notify, err := userRepo.SaveResult(user, admitted); err != nil || !notify {
+		t.Fatalf("SaveResult(admitted) = %v, %v; want true, nil", notify, err)
+	}
+
+	// Every claimed notification is in the outbox exactly once
+	notifications := NewNotificationRepo(userRepo.db)
+	if err := notifications.Enqueue(model.NewResultNotification(user.ID, released)); err != nil {
+		t.Fatalf("Enqueue(duplicate) error = %v", err)
+	}
+	queued, total, err := notifications.List(user.ID, model.NotificationPending, 10, 0)
+	if err != nil || total != 2 {
+		t.Fatalf("List() = %d notifications, %v; want 2", total, err)
+	}
+	if queued[0].Event != model.NotificationEventScore || queued[0].Result == nil || queued[0].Result.Admission != "拟录取" {
+		t.Errorf("unexpected notification %+v", queued[0])
+	}
+}
+
+func TestSaveResultStopsPollingOnRejection(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	now := time.Now()
+	user := &model.User{Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	rejected := &model.ScoreResult{Status: model.QueryStatusRejected, Admission: "未录取", Raw: `{"lqzt":"未录取"}`}
+	if _, err := userRepo.SaveResult(user, rejected); err != nil {
+		t.Fatalf("SaveResult(rejected) error = %v", err)
+	}
+	if stored, _ := userRepo.FindByID(user.ID); stored == nil || stored.Status != model.QueryStatusRejected {
+		t.Fatalf("stored user = %+v, want status rejected", stored)
+	}
+	if pendingUsers, err := userRepo.FindPending(); err != nil || len(pendingUsers) != 0 {
+		t.Errorf("FindPending() = %d users, %v; want none", len(pendingUsers), err)
+	}
+}
+
+func TestSaveResultHonoursPreference(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	user := &model.User{Email: "a@example.com", InfoHash: "h1"}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := userRepo.SaveNotificationSettings(user.ID, &model.NotificationPreference{StatusChange: true}, []model.Recipient{{Email: "b@example.com"}}); err != nil {
+		t.Fatalf("SaveNotificationSettings() error = %v", err)
+	}
+	if recipients, _ := userRepo.FindRecipients(user.ID); len(recipients) != 1 || recipients[0].Email != "b@example.com" {
+		t.Errorf("FindRecipients() = %+v", recipients)
+	}
+
+	pending := &model.ScoreResult{Status: model.QueryStatusNotPublished}
+	if notify, err := userRepo.SaveResult(user, pending); err != nil || !notify {
+		t.Fatalf("SaveResult(not_published) = %v, %v; want status change", notify, err)
+	}
+	if notify, _ := userRepo.SaveResult(user, pending); notify {
+		t.Error("SaveResult(same status) notified again")
+	}
+	// Score notifications are off, the release is reported as a status change
+	released := &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "385"}
+	if notify, err := userRepo.SaveResult(user, released); err != nil || !notify {
+		t.Fatalf("SaveResult(score_released) = %v, %v; want status change", notify, err)
+	}
+
+	queued, total, _ := NewNotificationRepo(userRepo.db).List(user.ID, "", 10, 0)
+	if total != 2 {
+		t.Fatalf("queued %d notifications, want 2", total)
+	}
+	for _, n := range queued {
+		if n.Event != model.NotificationEventStatusChange {
+			t.Errorf("unexpected %s notification", n.Event)
+		}
+	}
+	if queued[0].PreviousStatus != model.QueryStatusNotPublished || queued[0].Result.Status != model.QueryStatusScoreReleased {
+		t.Errorf("latest notification %s -> %s", queued[0].PreviousStatus, queued[0].Result.Status)
+	}
+}
+
+func TestRecipientConfirmation(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Recipients: []model.Recipient{{Email: "b@example.com"}}}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	recipients, _ := userRepo.FindRecipients(user.ID)
+	if len(recipients) != 1 || recipients[0].VerifiedAt != nil {
+		t.Fatalf("FindRecipients() = %+v, want one unconfirmed recipient", recipients)
+	}
+	if confirmed, err := userRepo.VerifyRecipient(recipients[0].ID); err != nil || confirmed == nil || confirmed.VerifiedAt == nil {
+		t.Fatalf("VerifyRecipient() = %+v, %v", confirmed, err)
+	}
+
+	// Replacing the recipients keeps the confirmation of the remaining ones
+	added, err := userRepo.SaveNotificationSettings(user.ID, nil, []model.Recipient{{Email: "B@example.com"}, {Email: "c@example.com"}})
+	if err != nil || len(added) != 1 || added[0].Email != "c@example.com" || added[0].ID == 0 {
+		t.Fatalf("SaveNotificationSettings() = %+v, %v; want c@example.com added", added, err)
+	}
+	recipients, _ = userRepo.FindRecipients(user.ID)
+	if len(recipients) != 2 || recipients[0].VerifiedAt == nil || recipients[1].VerifiedAt != nil {
+		t.Fatalf("recipients after update = %+v", recipients)
+	}
+
+	// Unsubscribing removes only that recipient
+	if removed, err := userRepo.DeleteRecipient(recipients[0].ID); err != nil || removed == nil {
+		t.Fatalf("DeleteRecipient() = %+v, %v", removed, err)
+	}
+	if removed, err := userRepo.DeleteRecipient(recipients[0].ID); err != nil || removed != nil {
+		t.Errorf("DeleteRecipient(removed) = %+v, %v; want nil", removed, err)
+	}
+	if confirmed, err := userRepo.VerifyRecipient(recipients[0].ID); err != nil || confirmed != nil {
+		t.Errorf("VerifyRecipient(removed) = %+v, %v; want nil", confirmed, err)
+	}
+	recipients, _ = userRepo.FindRecipients(user.ID)
+	if stored, _ := userRepo.FindByID(user.ID); stored == nil || len(recipients) != 1 || recipients[0].Email != "c@example.com" {
+		t.Errorf("after unsubscribe: user %v, recipients %+v", stored, recipients)
+	}
+}
+
+func TestQueryOutcomeKeepsConcurrentChanges(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	now := time.Now()
+	paused := &model.User{Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now}
+	deleted := &model.User{Name: "李四", Email: "b@example.com", InfoHash: "h2", VerifiedAt: &now}
+	for _, u := range []*model.User{paused, deleted} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The user pauses and the other deletes the submission while queried
+	if _, err := userRepo.Stop(paused.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := userRepo.HardDelete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []*model.User{paused, deleted} {
+		updated, err := userRepo.UpdatePolling(u.ID, func(current *model.User) *model.Notification {
+			current.Status = model.QueryStatusFailed
+			return model.NewFailedNotification(current, "查询失败")
+		})
+		if err != nil || updated != nil {
+			t.Errorf("UpdatePolling(%d) = %v, %v; want nil", u.ID, updated, err)
+		}
+	}
+	released := &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "385"}
+	for _, u := range []*model.User{paused, deleted} {
+		if notify, err := userRepo.SaveResult(u, released); err != nil || notify {
+			t.Errorf("SaveResult(%d) = %v, %v; want false, nil", u.ID, notify, err)
+		}
+	}
+
+	stored, _ := userRepo.FindByID(paused.ID)
+	if stored.Status != model.QueryStatusStopped || stored.Attempts != 0 {
+		t.Errorf("paused user = %q after %d attempts; want stopped", stored.Status, stored.Attempts)
+	}
+	if stored.Score != nil || stored.NotifiedHash != "" || !stored.LastQueryAt.IsZero() {
+		t.Errorf("paused user got the result: %+v, %q, %v", stored.Score, stored.NotifiedHash, stored.LastQueryAt)
+	}
+	var count int64
+	userRepo.db.Unscoped().Model(&model.User{}).Where("id = ?", deleted.ID).Count(&count)
+	if count != 0 {
+		t.Error("deleted user was inserted again")
+	}
+	userRepo.db.Model(&model.Notification{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d notifications queued for changed users", count)
+	}
+}
+
+func TestUnverifiedUsers(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	stale := &model.User{Email: "stale@example.com", InfoHash: "stale", CreatedAt: time.Now().Add(-72 * time.Hour)}
+	fresh := &model.User{Email: "fresh@example.com", InfoHash: "fresh"}
+	for _, u := range []*model.User{stale, fresh} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	if pendingUsers, _ := userRepo.FindPending(); len(pendingUsers) != 0 {
+		t.Fatalf("FindPending() returned %d unverified users", len(pendingUsers))
+	}
+
+	if verified, err := userRepo.Verify(fresh.ID); err != nil || verified.VerifiedAt == nil {
+		t.Fatalf("Verify() = %+v, %v", verified, err)
+	}
+	if pendingUsers, _ := userRepo.FindPending(); len(pendingUsers) != 1 {
+		t.Fatalf("FindPending() = %d users after verification, want 1", len(pendingUsers))
+	}
+
+	count, err := userRepo.PurgeUnverified(time.Now().Add(-48 * time.Hour))
+	if err != nil || count != 1 {
+		t.Fatalf("PurgeUnverified() = %d, %v; want 1, nil", count, err)
+	}
+	if u, _ := userRepo.FindByID(stale.ID); u != nil {
+		t.Errorf("stale unverified user still present")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	db := newTestDB(t)
+	userRepo := NewUserRepo(db)
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+
+	delivered := &model.User{Name: "张三", IDCard: "110", ExamID: "105", Email: "a@example.com", InfoHash: "a",
+		Status: model.QueryStatusScoreReleased, NotifiedAt: &old, VerifiedAt: &old, Notice: "张三 总分 385",
+		Score: &model.ScoreResult{Status: model.QueryStatusScoreReleased, CandidateName: "张三", ExamID: "105", Total: "385", Raw: `{"xm":"张三"}`}}
+	recent := &model.User{Name: "李四", Email: "b@example.com", InfoHash: "b",
+		Status: model.QueryStatusScoreReleased, NotifiedAt: &now, VerifiedAt: &old}
+	polling := &model.User{Name: "王五", Email: "c@example.com", InfoHash: "c", VerifiedAt: &old}
+	deleted := &model.User{Name: "赵六", Email: "d@example.com", InfoHash: "d", VerifiedAt: &old}
+	// The score was queued long ago but SMTP has been down since
+	undelivered := &model.User{Name: "孙七", Email: "e@example.com", InfoHash: "e",
+		Status: model.QueryStatusScoreReleased, NotifiedAt: &old, VerifiedAt: &old}
+	// Rejected without a score, so no score notification is ever sent
+	rejected := &model.User{Name: "周八", Email: "f@example.com", InfoHash: "f",
+		Status: model.QueryStatusRejected, LastQueryAt: old, VerifiedAt: &old}
+	for _, u := range []*model.User{delivered, recent, polling, deleted, undelivered, rejected} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	userRepo.Delete(deleted.ID)
+	for _, n := range []*model.Notification{
+		{UserID: delivered.ID, Event: model.NotificationEventScore, IdempotencyKey: "n1", Status: model.NotificationSent, SentAt: &old},
+		{UserID: recent.ID, Event: model.NotificationEventScore, IdempotencyKey: "n2", Status: model.NotificationSent, SentAt: &now},
+		{UserID: undelivered.ID, Event: model.NotificationEventScore, IdempotencyKey: "n3", Status: model.NotificationPending},
+	} {
+		db.Create(n)
+	}
+	for _, u := range []*model.User{delivered, deleted} {
+		db.Create(&model.WebhookDelivery{UserID: u.ID, URL: "https://example.com/hook", Payload: `{"data":{}}`})
+	}
+
+	candidates, err := userRepo.FindPurgeCandidates(now.Add(-7 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("FindPurgeCandidates() error = %v", err)
+	}
+	ids := map[uint]bool{}
+	for _, u := range candidates {
+		ids[u.ID] = true
+	}
+	if len(ids) != 3 || !ids[delivered.ID] || !ids[deleted.ID] || !ids[rejected.ID] {
+		t.Fatalf("FindPurgeCandidates() = %v, want users %d, %d and %d", ids, delivered.ID, deleted.ID, rejected.ID)
+	}
+
+	if err := userRepo.Purge(delivered, model.PurgeModeAnonymize, model.PurgeReasonDelivered); err != nil {
+		t.Fatalf("Purge(anonymize) error = %v", err)
+	}
+	if err := userRepo.Purge(deleted, model.PurgeModeDelete, model.PurgeReasonDeleted); err != nil {
+		t.Fatalf("Purge(delete) error = %v", err)
+	}
+	if err := userRepo.Purge(rejected, model.PurgeModeAnonymize, model.PurgeReasonRejected); err != nil {
+		t.Fatalf("Purge(rejected) error = %v", err)
+	}
+
+	anonymized, _ := userRepo.FindByID(delivered.ID)
+	if anonymized == nil || anonymized.Name != "" || anonymized.IDCard != "" || anonymized.Email != "" || anonymized.PurgedAt == nil {
+		t.Errorf("anonymized user = %+v", anonymized)
+	}
+	if anonymized != nil && anonymized.Status != model.QueryStatusScoreReleased {
+		t.Errorf("anonymized user lost status: %q", anonymized.Status)
+	}
+	var stored struct {
+		Score  *string
+		Notice string
+	}
+	db.Table("users").Select("score, notice").Where("id = ?", delivered.ID).Scan(&stored)
+	if stored.Score != nil || stored.Notice != "" {
+		t.Errorf("anonymized user kept score %v and notice %q", stored.Score, stored.Notice)
+	}
+
+	var remaining int64
+	db.Unscoped().Model(&model.User{}).Where("id = ?", deleted.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("deleted user still present")
+	}
+
+	db.Model(&model.WebhookDelivery{}).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("%d webhook deliveries kept after purge", remaining)
+	}
+
+	var records []model.PurgeRecord
+	db.Order("id").Find(&records)
+	if len(records) != 3 || records[0].Mode != model.PurgeModeAnonymize || records[1].Reason != model.PurgeReasonDeleted || records[2].Reason != model.PurgeReasonRejected {
+		t.Errorf("purge records = %+v", records)
+	}
+
+	if again, _ := userRepo.FindPurgeCandidates(now.Add(-7 * 24 * time.Hour)); len(again) != 0 {
+		t.Errorf("FindPurgeCandidates() after purge = %d users, want 0", len(again))
+	}
+}
+
+func TestStop(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Status: model.QueryStatusNotPublished}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped, err := userRepo.Stop(user.ID)
+	if err != nil || stopped == nil || stopped.Status != model.QueryStatusStopped {
+		t.Fatalf("Stop() = %+v, %v", stopped, err)
+	}
+	if missing, err := userRepo.Stop(999); missing != nil || err != nil {
+		t.Errorf("Stop(unknown) = %v, %v", missing, err)
+	}
+}
+
+func TestResumeAndUpdateInfo(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", ManageTokenHash: "t1", Status: model.QueryStatusInfoMismatch, Attempts: 2}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := userRepo.FindByManageToken("t1"); err != nil || found == nil || found.ID != user.ID {
+		t.Fatalf("FindByManageToken() = %v, %v", found, err)
+	}
+	if found, err := userRepo.FindByManageToken(""); found != nil || err != nil {
+		t.Errorf("FindByManageToken(empty) = %v, %v", found, err)
+	}
+
+	if _, err := userRepo.Stop(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := userRepo.Resume(user.ID)
+	if err != nil || resumed.Status != model.QueryStatusPending || resumed.Attempts != 0 {
+		t.Fatalf("Resume() = %+v, %v", resumed, err)
+	}
+
+	// Corrected identity data drops the old result
+	resumed.ExamID = "100000000000001"
+	resumed.InfoHash = "h2"
+	resumed.Score = &model.ScoreResult{Status: model.QueryStatusNotPublished}
+	resumed.NotifiedHash = "old"
+	if err := userRepo.UpdateInfo(resumed, true); err != nil {
+		t.Fatalf("UpdateInfo() error = %v", err)
+	}
+	stored, _ := userRepo.FindByInfoHash("h2")
+	if stored == nil || stored.Score != nil || stored.NotifiedHash != "" || stored.ExamID != "100000000000001" {
+		t.Errorf("stored after UpdateInfo = %+v", stored)
+	}
+
+	released := &model.User{Email: "b@example.com", InfoHash: "h3", Status: model.QueryStatusScoreReleased}
+	if err := userRepo.Create(released); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userRepo.Resume(released.ID); err == nil {
+		t.Error("Resume() of a released user succeeded")
+	}
+	if err := userRepo.UpdateInfo(released, true); err == nil {
+		t.Error("UpdateInfo(requery) of a released user succeeded")
+	}
+}
+
+func TestListAndResetFailed(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	old := time.Now().Add(-2 * time.Hour)
+	users := []*model.User{
+		{Email: "a@example.com", InfoHash: "h1", SchoolCode: "10001", Status: model.QueryStatusFailed, Attempts: 8, LastQueryAt: old},
+		{Email: "b@example.com", InfoHash: "h2", SchoolCode: "10001", Status: model.QueryStatusFailed, Attempts: 8, LastQueryAt: time.Now()},
+		{Email: "c@example.com", InfoHash: "h3", SchoolCode: "10002", Status: model.QueryStatusNotPublished, LastQueryAt: old},
+	}
+	for _, u := range users {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	for _, tc := range []struct {
+		name   string
+		filter UserFilter
+		want   int64
+	}{
+		{"all", UserFilter{}, 3},
+		{"status", UserFilter{Status: model.QueryStatusFailed}, 2},
+		{"school", UserFilter{SchoolCode: "10002"}, 1},
+		{"queried before", UserFilter{QueriedBefore: &hourAgo}, 2},
+		{"combined", UserFilter{Status: model.QueryStatusFailed, QueriedAfter: &hourAgo}, 1},
+	} {
+		if _, total, err := userRepo.List(tc.filter, 10, 0); err != nil || total != tc.want {
+			t.Errorf("List(%s) = %d, %v; want %d", tc.name, total, err, tc.want)
+		}
+	}
+	if page, total, _ := userRepo.List(UserFilter{}, 2, 2); len(page) != 1 || total != 3 || page[0].ID != users[2].ID {
+		t.Errorf("List() second page = %d items of %d", len(page), total)
+	}
+
+	if count, err := userRepo.ResetFailed(users[0].ID, users[2].ID); err != nil || count != 1 {
+		t.Fatalf("ResetFailed(ids) = %d, %v; want 1", count, err)
+	}
+	if count, err := userRepo.ResetFailed(); err != nil || count != 1 {
+		t.Fatalf("ResetFailed() = %d, %v; want 1", count, err)
+	}
+	reset, _ := userRepo.FindByID(users[0].ID)
+	if reset.Status != model.QueryStatusPending || reset.Attempts != 0 {
+		t.Errorf("reset user = %q, %d attempts", reset.Status, reset.Attempts)
+	}
+}
+
+func TestOwnerUpdatesKeepConcurrentResults(t *testing.T) {
+	userRepo := NewUserRepo(newTestDB(t))
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Status: model.QueryStatusNotPublished}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	// The owner's request read the user before the scheduler saved a score
+	stale, _ := userRepo.FindByID(user.ID)
+	if _, err := userRepo.SaveResult(user, &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "400"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale.Locale = "en"
+	if err := userRepo.UpdateInfo(stale, false); err != nil {
+		t.Fatalf("UpdateInfo() error = %v", err)
+	}
+	stored, _ := userRepo.FindByID(user.ID)
+	if stored.Locale != "en" || stored.Status != model.QueryStatusScoreReleased || stored.Score == nil || stored.NotifiedHash == "" {
+		t.Errorf("after UpdateInfo: %+v", stored)
+	}
+
+	// A requery would drop the score the owner has not seen yet
+	stale.ExamID = "100000000000002"
+	if err := userRepo.UpdateInfo(stale, true); !errors.Is(err, ErrStatusChanged) {
+		t.Errorf("UpdateInfo(requery) error = %v, want ErrStatusChanged", err)
+	}
+
+	// Stop re-reads the user and keeps the result
+	stopped, err := userRepo.Stop(user.ID)
+	if err != nil || stopped.Status != model.QueryStatusStopped {
+		t.Fatalf("Stop() = %+v, %v", stopped, err)
+	}
+	stored, _ = userRepo.FindByID(user.ID)
+	if stored.Score == nil || stored.Score.Total != "400" || stored.NotifiedHash == "" || stored.ExamID != "" {
+		t.Errorf("after Stop: %+v", stored)
+	}
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"gorm.io/gorm"
+)
+
+type WebhookRepo struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepo(db *gorm.DB) *WebhookRepo {
+	return &WebhookRepo{db: db}
+}
+
+// FindEndpoints returns the webhook endpoints registered for a user
+func (r *WebhookRepo) FindEndpoints(userID uint) ([]model.WebhookEndpoint, error) {
+	var endpoints []model.WebhookEndpoint
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&endpoints).Error; err != nil {
+		logger.Error("Failed to find webhook endpoints: %v", err)
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (r *WebhookRepo) FindEndpoint(id uint) (*model.WebhookEndpoint, error) {
+	var endpoint model.WebhookEndpoint
+	if err := r.db.First(&endpoint, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find webhook endpoint: %v", err)
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (r *WebhookRepo) CreateDeliveries(deliveries []model.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := r.db.Create(&deliveries).Error; err != nil {
+		logger.Error("Failed to create webhook deliveries: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FindDueDeliveries returns pending deliveries whose next attempt is due
+func (r *WebhookRepo) FindDueDeliveries(now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := r.db.Where("status = ?", model.DeliveryPending).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("id").Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		logger.Error("Failed to find due webhook deliveries: %v", err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepo) FindDelivery(id uint) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	if err := r.db.First(&delivery, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		logger.Error("Failed to find webhook delivery: %v", err)
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *WebhookRepo) UpdateDelivery(delivery *model.WebhookDelivery) error {
+	if err := r.db.Save(delivery).Error; err != nil {
+		logger.Error("Failed to update webhook delivery: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery log, newest first. A zero userID or an
+// empty status matches all deliveries.
+func (r *WebhookRepo) ListDeliveries(userID uint, status string, limit, offset int) ([]model.WebhookDelivery, int64, error) {
+	query := r.db.Model(&model.WebhookDelivery{})
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Error("Failed to count webhook deliveries: %v", err)
+		return nil, 0, err
+	}
+	var deliveries []model.WebhookDelivery
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		logger.Error("Failed to list webhook deliveries: %v", err)
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
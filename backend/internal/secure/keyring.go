@@ -0,0 +1,266 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/pkg/config"
+)
+
+// Encrypted values look like enc:v1:<key id>:<wrapped data key>:<ciphertext>.
+// Every value is encrypted with its own random data key, which is in turn
+// wrapped with the key-encryption key named by <key id>. Rotating the
+// primary key therefore only requires re-wrapping, and old keys stay
+// available for decryption until all rows have been migrated.
+const encPrefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("secure: unknown encryption key")
+	ErrMalformed  = errors.New("secure: malformed encrypted value")
+)
+
+// Keyring holds the key-encryption keys and the key used for InfoHash
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+	hashKey []byte
+}
+
+var (
+	mu      sync.RWMutex
+	current *Keyring
+)
+
+// Init builds the keyring from config and makes it the active one. Without
+// any configured key, field encryption is disabled and values are stored as
+// plaintext.
+func Init(cfg *config.Config) error {
+	kr, err := LoadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	if kr.primary == "" {
+		logger.Warn("No field encryption key configured, personal data is stored in plaintext")
+	}
+	Use(kr)
+	return nil
+}
+
+// Use sets the active keyring
+func Use(kr *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = kr
+}
+
+func active() *Keyring {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// LoadKeyring reads keys from FIELD_ENCRYPTION_KEYS and FIELD_ENCRYPTION_KEY_FILE.
+// Both use one "id:base64key" entry per line or comma.
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	spec := cfg.FieldEncryptionKeys
+	if cfg.FieldEncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.FieldEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		spec += "\n" + string(data)
+	}
+
+	hashKey := cfg.InfoHashKey
+	if hashKey == "" {
+		hashKey = cfg.AppSecret
+	}
+	if hashKey == "" {
+		logger.Warn("Neither INFO_HASH_KEY nor APP_SECRET is set, InfoHash is not keyed")
+	}
+
+	return NewKeyring(spec, cfg.FieldEncryptionPrimaryKey, []byte(hashKey))
+}
+
+// NewKeyring parses a key spec. The primary key defaults to the first key listed.
+func NewKeyring(spec string, primary string, hashKey []byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string][]byte), hashKey: hashKey}
+
+	entries := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry %q, want id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes, got %d", id, len(key))
+		}
+		kr.keys[id] = key
+		if kr.primary == "" {
+			kr.primary = id
+		}
+	}
+
+	if primary != "" {
+		if _, ok := kr.keys[primary]; !ok {
+			return nil, fmt.Errorf("primary key %s is not configured", primary)
+		}
+		kr.primary = primary
+	}
+	return kr, nil
+}
+
+// Enabled reports whether new values are encrypted
+func (kr *Keyring) Enabled() bool {
+	return kr != nil && kr.primary != ""
+}
+
+// Encrypt encrypts plaintext with a fresh data key wrapped by the primary key
+func (kr *Keyring) Encrypt(plaintext string) (string, error) {
+	if !kr.Enabled() || plaintext == "" {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(kr.keys[kr.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return encPrefix + kr.primary + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt reverses Encrypt. Values without the encryption prefix are legacy
+// plaintext and returned unchanged.
+func (kr *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encPrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	if kr == nil {
+		return "", ErrUnknownKey
+	}
+	kek, ok := kr.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsMigration reports whether value is plaintext or wrapped by a key
+// other than the primary one
+func (kr *Keyring) NeedsMigration(value string) bool {
+	if !kr.Enabled() || value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	return !strings.HasPrefix(value, encPrefix+kr.primary+":")
+}
+
+// InfoHash returns the keyed hash used to detect duplicate submissions
+func (kr *Keyring) InfoHash(name, idCard, examID string) string {
+	var key []byte
+	if kr != nil {
+		key = kr.hashKey
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + ":" + idCard + ":" + examID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether value carries the encryption prefix
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encPrefix)
+}
+
+// InfoHash hashes submission info with the active keyring
+func InfoHash(name, idCard, examID string) string {
+	return active().InfoHash(name, idCard, examID)
+}
+
+// Active returns the keyring set by Init or Use
+func Active() *Keyring {
+	return active()
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
@@ -0,0 +1,159 @@
+package secure
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+func TestKeyringRoundTripAndRotation(t *testing.T) {
+	old, err := NewKeyring("k1:"+testKey('a'), "", []byte("hash"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	encrypted, err := old.Encrypt("110101199001011234")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "1101011990") {
+		t.Fatalf("Encrypt() = %q, want opaque ciphertext", encrypted)
+	}
+	if again, _ := old.Encrypt("110101199001011234"); again == encrypted {
+		t.Errorf("Encrypt() is deterministic, want a fresh data key per value")
+	}
+
+	// k2 becomes primary, k1 stays available for decryption
+	rotated, err := NewKeyring("k1:"+testKey('a')+"\nk2:"+testKey('b'), "k2", []byte("hash"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	if plain, err := rotated.Decrypt(encrypted); err != nil || plain != "110101199001011234" {
+		t.Fatalf("Decrypt() after rotation = %q, %v", plain, err)
+	}
+	if !rotated.NeedsMigration(encrypted) {
+		t.Errorf("NeedsMigration() = false for value under old key")
+	}
+	reencrypted, _ := rotated.Encrypt("110101199001011234")
+	if rotated.NeedsMigration(reencrypted) {
+		t.Errorf("NeedsMigration() = true for value under primary key")
+	}
+
+	// Legacy plaintext passes through
+	if plain, err := rotated.Decrypt("张三"); err != nil || plain != "张三" {
+		t.Errorf("Decrypt(plaintext) = %q, %v", plain, err)
+	}
+	if !rotated.NeedsMigration("张三") {
+		t.Errorf("NeedsMigration() = false for plaintext")
+	}
+
+	// Without k1 the old value cannot be read
+	onlyNew, _ := NewKeyring("k2:"+testKey('b'), "", nil)
+	if _, err := onlyNew.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt() with missing key error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestNewKeyringRejectsBadKeys(t *testing.T) {
+	for _, spec := range []string{"nokey", "k1:not-base64!", "k1:" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewKeyring(spec, "", nil); err == nil {
+			t.Errorf("NewKeyring(%q) succeeded, want error", spec)
+		}
+	}
+	if _, err := NewKeyring("k1:"+testKey('a'), "k9", nil); err == nil {
+		t.Errorf("NewKeyring() with unknown primary succeeded, want error")
+	}
+}
+
+func TestInfoHashIsKeyed(t *testing.T) {
+	a, _ := NewKeyring("", "", []byte("key-a"))
+	b, _ := NewKeyring("", "", []byte("key-b"))
+	if a.InfoHash("张三", "1", "2") != a.InfoHash("张三", "1", "2") {
+		t.Errorf("InfoHash() is not stable")
+	}
+	if a.InfoHash("张三", "1", "2") == b.InfoHash("张三", "1", "2") {
+		t.Errorf("InfoHash() does not depend on the key")
+	}
+}
+
+type secret struct {
+	ID     uint
+	IDCard string  `gorm:"type:text;serializer:encrypted"`
+	Detail *detail `gorm:"type:text;serializer:encrypted_json"`
+}
+
+type detail struct {
+	Name string `json:"name"`
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	kr, _ := NewKeyring("k1:"+testKey('a'), "", nil)
+	Use(kr)
+	defer Use(nil)
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.AutoMigrate(&secret{})
+
+	if err := db.Create(&secret{IDCard: "110101199001011234", Detail: &detail{Name: "张三"}}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := db.Create(&secret{IDCard: "110101199001011235"}).Error; err != nil {
+		t.Fatalf("Create(nil detail) error = %v", err)
+	}
+	// Rows written before the column was encrypted
+	db.Exec(`INSERT INTO secrets (id, id_card, detail) VALUES (3, '110101199001011236', '{"name":"李四"}')`)
+
+	var stored struct{ IDCard, Detail string }
+	db.Table("secrets").Select("id_card, detail").Where("id = 1").Scan(&stored)
+	if !IsEncrypted(stored.IDCard) || !IsEncrypted(stored.Detail) || strings.Contains(stored.Detail, "张三") {
+		t.Errorf("stored columns = %+v, want ciphertext", stored)
+	}
+	var nulls int64
+	db.Table("secrets").Where("id = 2 AND detail IS NULL").Count(&nulls)
+	if nulls != 1 {
+		t.Error("nil detail was not stored as NULL")
+	}
+
+	var loaded []secret
+	if err := db.Order("id").Find(&loaded).Error; err != nil || len(loaded) != 3 {
+		t.Fatalf("Find() = %+v, %v", loaded, err)
+	}
+	if loaded[0].IDCard != "110101199001011234" || loaded[0].Detail == nil || loaded[0].Detail.Name != "张三" {
+		t.Errorf("loaded = %+v %+v", loaded[0], loaded[0].Detail)
+	}
+	if loaded[1].Detail != nil {
+		t.Errorf("nil detail loaded as %+v", loaded[1].Detail)
+	}
+	if loaded[2].Detail == nil || loaded[2].Detail.Name != "李四" {
+		t.Errorf("plaintext detail loaded as %+v", loaded[2].Detail)
+	}
+}
+
+func TestMask(t *testing.T) {
+	if got := MaskID("110101199001011237"); got != "**************1237" {
+		t.Errorf("MaskID() = %q", got)
+	}
+	if got := MaskID("123"); got != "***" {
+		t.Errorf("MaskID(short) = %q", got)
+	}
+	if got := MaskName("欧阳娜娜"); got != "欧***" {
+		t.Errorf("MaskName() = %q", got)
+	}
+	if got := MaskName(""); got != "" {
+		t.Errorf("MaskName(empty) = %q", got)
+	}
+}
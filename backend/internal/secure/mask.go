@@ -0,0 +1,24 @@
+package secure
+
+import (
+	"strings"
+)
+
+// MaskID keeps only the last four characters of an identifier such as an ID
+// card number or exam ID, for logs and API responses
+func MaskID(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 4 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
+
+// MaskName keeps only the first character of a name
+func MaskName(name string) string {
+	runes := []rune(name)
+	if len(runes) <= 1 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[0]) + strings.Repeat("*", len(runes)-1)
+}
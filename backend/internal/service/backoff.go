@@ -0,0 +1,27 @@
+package service
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// retryDelay returns the wait before retry number attempt (starting at 1):
+// base doubled per attempt and capped at max, with half of the delay jittered
+// so that users failing together do not retry together.
+func retryDelay(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half)
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	base, max := time.Minute, time.Hour
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, time.Hour},
+		{30, time.Hour},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := retryDelay(tt.attempt, base, max)
+			if got < tt.want/2 || got >= tt.want {
+				t.Fatalf("retryDelay(%d) = %v, want in [%v, %v)", tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
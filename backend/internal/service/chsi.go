@@ -1,60 +1,242 @@
 package service
 
 import (
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/internal/model"
-"chsi-auto-score-query/pkg/config"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
 )
 
+// UnsubscribeTokenPurpose signs the List-Unsubscribe links of notifications
+const UnsubscribeTokenPurpose = "unsubscribe"
+
+// RecipientUnsubscribeTokenPurpose signs the List-Unsubscribe links sent to
+// additional recipients, which only remove that recipient
+const RecipientUnsubscribeTokenPurpose = "recipient_unsubscribe"
+
+const unsubscribeTokenTTL = 180 * 24 * time.Hour
+
 type QueryService struct {
 	chsiClient *ChsiClient
+	sessions   *SessionManager
+	userRepo   *repo.UserRepo
+	attempts   *repo.QueryAttemptRepo
 	emailSvc   *EmailService
+	notifiers  *notify.Registry
+	tokens     *TokenSigner
 	cfg        *config.Config
 }
 
-func NewQueryService(cfg *config.Config) *QueryService {
+func NewQueryService(db *gorm.DB, cfg *config.Config) *QueryService {
+	chsiClient := NewChsiClient(cfg)
+	emailSvc := NewEmailService(cfg)
 	return &QueryService{
-		chsiClient: NewChsiClient(cfg),
-		emailSvc:   NewEmailService(cfg),
+		chsiClient: chsiClient,
+		sessions:   NewSessionManager(chsiClient, repo.NewSessionRepo(db), cfg),
+		userRepo:   repo.NewUserRepo(db),
+		attempts:   repo.NewQueryAttemptRepo(db),
+		emailSvc:   emailSvc,
+		notifiers:  NewNotifierRegistry(emailSvc),
+		tokens:     NewTokenSigner(cfg.AppSecret),
 		cfg:        cfg,
 	}
 }
 
-// QueryAndEmail performs login, query, and email operations
-func (s *QueryService) QueryAndEmail(user *model.User) error {
+// QueryError wraps a failed query with the notice shown to the user
+type QueryError struct {
+	Notice string
+	Err    error
+	// Login marks failures of the shared CHSI login or session, which are
+	// not caused by the user and affect every query alike
+	Login bool
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Notice, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Query performs login, query and parse operations and returns the parsed
+// result. A nil result means the page could not be interpreted this time.
+// Every call is recorded in the user's query history.
+func (s *QueryService) Query(ctx context.Context, user *model.User) (result *model.ScoreResult, err error) {
 	logger.Info("Starting score query for user: %s", user.Email)
 
-	// Step 1: Login
-	if err := s.chsiClient.Login(); err != nil {
+	attempt := &model.QueryAttempt{UserID: user.ID, StartedAt: time.Now()}
+	var htmlContent string
+	defer func() {
+		s.recordAttempt(attempt, htmlContent, result, err)
+	}()
+
+	// Step 1: Make sure we hold a valid CHSI session
+	if err := s.sessions.Ensure(ctx); err != nil {
 		logger.Error("Login failed for user %s: %v", user.Email, err)
-		return s.emailSvc.SendError(user.Email, "登录学信网失败，请稍后重试")
+		attempt.ErrorClass = loginErrorClass(err)
+		return nil, &QueryError{Notice: "登录学信网失败，请稍后重试", Err: err, Login: true}
 	}
 
-	// Step 2: Query score
-	htmlContent, err := s.chsiClient.QueryScore(user)
+	// Step 2: Query score, logging in again once if the session has expired
+	htmlContent, err = s.chsiClient.QueryScore(ctx, user)
+	if errors.Is(err, ErrSessionExpired) {
+		s.sessions.Invalidate()
+		if err := s.sessions.Ensure(ctx); err != nil {
+			logger.Error("Re-login failed for user %s: %v", user.Email, err)
+			attempt.ErrorClass = loginErrorClass(err)
+			return nil, &QueryError{Notice: "登录学信网失败，请稍后重试", Err: err, Login: true}
+		}
+		htmlContent, err = s.chsiClient.QueryScore(ctx, user)
+	}
+	if errors.Is(err, ErrSessionExpired) {
+		logger.Error("Session rejected again for user %s: %v", user.Email, err)
+		return nil, &QueryError{Notice: "登录学信网失败，请稍后重试", Err: err, Login: true}
+	}
 	if err != nil {
 		logger.Error("Query failed for user %s: %v", user.Email, err)
-		return s.emailSvc.SendError(user.Email, "查询成绩失败，请确保信息正确")
+		return nil, &QueryError{Notice: "查询成绩失败，请确保信息正确", Err: err}
 	}
+	attempt.HTTPStatus = http.StatusOK
+	// 查询响应可能更新了cookie，保存后重启时仍可复用
+	s.sessions.Persist()
 
 	// Step 3: Parse score
-	score, err := s.chsiClient.ParseScore(htmlContent)
+	result, parseErr := s.chsiClient.ParseScore(htmlContent)
+	if parseErr != nil {
+		logger.Error("Parse failed for user %s: %v", user.Email, parseErr)
+		attempt.ErrorClass = model.AttemptErrorParse
+		attempt.Error = parseErr.Error()
+		return nil, nil // Not an error if score doesn't exist yet
+	}
+
+	logger.Info("Score query for user %s finished with status: %s", user.Email, result.Status)
+	return result, nil
+}
+
+// recordAttempt completes and stores a query attempt. The response page is
+// kept only when QUERY_SNAPSHOTS is enabled.
+func (s *QueryService) recordAttempt(attempt *model.QueryAttempt, page string, result *model.ScoreResult, err error) {
+	if s.attempts == nil {
+		return
+	}
+	attempt.DurationMs = time.Since(attempt.StartedAt).Milliseconds()
+	if result != nil {
+		attempt.Status = result.Status
+	}
 	if err != nil {
-		logger.Error("Parse failed for user %s: %v", user.Email, err)
-		return nil // Not an error if score doesn't exist yet
+		attempt.Error = err.Error()
+		if attempt.ErrorClass == "" {
+			attempt.ErrorClass = attemptErrorClass(err)
+		}
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) {
+			attempt.HTTPStatus = statusErr.StatusCode
+		}
+	}
+	if s.cfg != nil && s.cfg.QuerySnapshots {
+		attempt.SetSnapshot(page)
+	}
+
+	keep := 0
+	if s.cfg != nil {
+		keep = s.cfg.QueryHistoryLimit
+	}
+	s.attempts.Create(attempt, keep)
+}
+
+// attemptErrorClass classifies a failed query for the query history
+func attemptErrorClass(err error) string {
+	var statusErr *HTTPStatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return model.AttemptErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return model.AttemptErrorCanceled
+	case errors.Is(err, ErrSessionExpired):
+		return model.AttemptErrorSessionExpired
+	case errors.As(err, &statusErr):
+		return model.AttemptErrorHTTPStatus
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return model.AttemptErrorTimeout
+		}
+		return model.AttemptErrorNetwork
+	}
+	return model.AttemptErrorOther
+}
+
+// loginErrorClass classifies a failed login; timeouts and cancellation keep
+// their own class
+func loginErrorClass(err error) string {
+	switch class := attemptErrorClass(err); class {
+	case model.AttemptErrorTimeout, model.AttemptErrorCanceled:
+		return class
+	}
+	return model.AttemptErrorLogin
+}
+
+// Close releases the connections held for sending notifications
+func (s *QueryService) Close() error {
+	return s.emailSvc.Close()
+}
+
+// loginFailure reports whether err is a failure of the shared CHSI login
+func loginFailure(err error) bool {
+	var queryErr *QueryError
+	return errors.As(err, &queryErr) && queryErr.Login
+}
+
+// failureNotice returns the reason shown to the user for a failed query
+func failureNotice(err error) string {
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		return queryErr.Notice
+	}
+	return "查询成绩失败，请稍后重试"
 }
 
-if score == "" {
-logger.Info("Score not available yet for user %s", user.Email)
-return nil
+// message renders a notification in the user's locale
+func (s *QueryService) message(user *model.User, event, kind string, data interface{}) (notify.Message, error) {
+	content, err := s.emailSvc.Render(user.Locale, kind, data)
+	if err != nil {
+		return notify.Message{}, err
+	}
+	return notify.Message{
+		Event:       event,
+		Title:       content.Subject,
+		Text:        content.Text,
+		HTML:        content.HTML,
+		Unsubscribe: s.unsubscribeLink(user),
+	}, nil
 }
 
-// Step 4: Send email
-if err := s.emailSvc.SendScore(user.Email, user.Name, score); err != nil {
-logger.Error("Email send failed for user %s: %v", user.Email, err)
-return err
+// unsubscribeLink returns the signed link that stops querying for the user
+func (s *QueryService) unsubscribeLink(user *model.User) string {
+	if s.tokens == nil || s.cfg == nil {
+		return ""
+	}
+	token := s.tokens.Sign(UnsubscribeTokenPurpose, user.ID, unsubscribeTokenTTL)
+	return strings.TrimRight(s.cfg.PublicBaseURL, "/") + "/api/unsubscribe/" + token
 }
 
-logger.Info("Successfully completed score query and email for user: %s", user.Email)
-return nil
+// recipientUnsubscribeLink returns the signed link that removes a single
+// additional recipient
+func (s *QueryService) recipientUnsubscribeLink(recipient model.Recipient) string {
+	if s.tokens == nil || s.cfg == nil {
+		return ""
+	}
+	token := s.tokens.Sign(RecipientUnsubscribeTokenPurpose, recipient.ID, unsubscribeTokenTTL)
+	return strings.TrimRight(s.cfg.PublicBaseURL, "/") + "/api/recipients/unsubscribe/" + token
 }
@@ -2,93 +2,417 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"chsi-auto-score-query/internal/logger"
 	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/secure"
 	"chsi-auto-score-query/pkg/config"
 )
 
+const (
+	defaultPassportBaseURL = "https://account.chsi.com.cn"
+	defaultYzBaseURL       = "https://yz.chsi.com.cn"
+
+	chsiUserAgent = "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Mobile Safari/537.36"
+)
+
+// Login failure kinds. Use errors.Is against the error returned by Login.
+var (
+	ErrBadCredentials  = errors.New("chsi login: bad credentials")
+	ErrCaptchaRequired = errors.New("chsi login: captcha required")
+	ErrAccountLocked   = errors.New("chsi login: account locked")
+	ErrUnexpectedPage  = errors.New("chsi login: unexpected page")
+)
+
+// ErrSessionExpired is returned when a request is redirected back to the
+// passport login page.
+var ErrSessionExpired = errors.New("chsi: session expired")
+
+// LoginError carries the failure kind together with the message shown by the
+// passport page, if any.
+type LoginError struct {
+	Kind    error
+	Message string
+	URL     string
+}
+
+func (e *LoginError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v (%s)", e.Kind, e.URL)
+	}
+	return fmt.Sprintf("%v: %s", e.Kind, e.Message)
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Kind
+}
+
+// HTTPStatusError is returned when CHSI answers a score query with a status
+// other than 200
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("query returned status code %d", e.StatusCode)
+}
+
 type ChsiClient struct {
 	client   *http.Client
+	jar      *sessionJar
+	limiter  *rateLimiter
 	cfg      *config.Config
 	username string
 	password string
+
+	passportBaseURL string
+	yzBaseURL       string
 }
 
 func NewChsiClient(cfg *config.Config) *ChsiClient {
-	jar, _ := cookiejar.New(nil)
+	jar := newSessionJar()
 	client := &http.Client{
 		Jar:     jar,
 		Timeout: 30 * time.Second,
 	}
 
 	return &ChsiClient{
-		client:   client,
-		cfg:      cfg,
-		username: cfg.ChsiUsername,
-		password: cfg.ChsiPassword,
+		client:          client,
+		jar:             jar,
+		limiter:         newRateLimiter(cfg.ChsiRateLimit),
+		cfg:             cfg,
+		username:        cfg.ChsiUsername,
+		password:        cfg.ChsiPassword,
+		passportBaseURL: defaultPassportBaseURL,
+		yzBaseURL:       defaultYzBaseURL,
 	}
 }
 
-// Login logs into CHSI website
-func (c *ChsiClient) Login() error {
+// do sends a request to CHSI once the global rate limit allows it
+func (c *ChsiClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
+
+// loginURL returns the CAS login page URL with yz.chsi.com.cn as the service
+func (c *ChsiClient) loginURL() string {
+	service := c.yzBaseURL + "/j_spring_cas_security_check"
+	return c.passportBaseURL + "/passport/login?entrytype=yzgr&service=" + url.QueryEscape(service)
+}
+
+// Login logs into CHSI website through the passport CAS flow:
+// load the login form, submit it with its hidden fields, follow the ticket
+// redirect back to yz.chsi.com.cn and finally verify the session.
+func (c *ChsiClient) Login(ctx context.Context) error {
 	logger.Info("Attempting to login CHSI with username: %s", c.username)
 
 	// 第一步：获取登录页面以获取lt和execution参数
-	loginPageURL := "https://account.chsi.com.cn/passport/login?entrytype=yzgr&service=https%3A%2F%2Fyz.chsi.com.cn%2Fj_spring_cas_security_check"
-	resp, err := c.client.Get(loginPageURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.loginURL(), nil)
+	if err != nil {
+		logger.Error("Failed to create login page request: %v", err)
+		return err
+	}
+	req.Header.Set("User-Agent", chsiUserAgent)
+
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		logger.Error("Failed to get login page: %v", err)
 		return err
 	}
-	defer resp.Body.Close()
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logger.Error("Failed to read login page: %v", err)
+		return err
+	}
 
-	// 简化版：直接尝试登录（在实际应用中应该解析HTML获取lt和execution）
-	// 这里使用空值，因为某些情况下服务器可能不需要这些参数
-	loginData := url.Values{}
+	// CAS已有登录态时会直接跳转回yz，无需再次提交表单
+	if !isLoginPage(resp.Request.URL) {
+		return c.confirmSession(ctx)
+	}
+
+	form, ok := parseLoginForm(string(page))
+	if !ok {
+		logger.Error("Login form not found on %s", resp.Request.URL)
+		return &LoginError{Kind: ErrUnexpectedPage, URL: resp.Request.URL.String()}
+	}
+	if form.captcha {
+		logger.Error("Login page requires captcha")
+		return &LoginError{Kind: ErrCaptchaRequired, Message: extractLoginMessage(string(page)), URL: resp.Request.URL.String()}
+	}
+
+	// 第二步：提交登录表单（带上lt、execution及其他隐藏字段）
+	action, err := resp.Request.URL.Parse(form.action)
+	if err != nil {
+		logger.Error("Invalid login form action %q: %v", form.action, err)
+		return &LoginError{Kind: ErrUnexpectedPage, URL: resp.Request.URL.String()}
+	}
+
+	loginData := form.fields
 	loginData.Set("username", c.username)
 	loginData.Set("password", c.password)
-	loginData.Set("lt", "")
-	loginData.Set("execution", "")
-	loginData.Set("_eventId", "submit")
-
-	loginURL := "https://account.chsi.com.cn/passport/login?entrytype=yzgr&service=https%3A%2F%2Fyz.chsi.com.cn%2Fj_spring_cas_security_check"
+	if loginData.Get("_eventId") == "" {
+		loginData.Set("_eventId", "submit")
+	}
 
-	req, err := http.NewRequest("POST", loginURL, bytes.NewBufferString(loginData.Encode()))
+	req, err = http.NewRequestWithContext(ctx, "POST", action.String(), bytes.NewBufferString(loginData.Encode()))
 	if err != nil {
 		logger.Error("Failed to create login request: %v", err)
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Mobile Safari/537.36")
+	req.Header.Set("User-Agent", chsiUserAgent)
+	req.Header.Set("Referer", resp.Request.URL.String())
 
-	resp, err = c.client.Do(req)
+	// 第三步：跟随CAS ticket跳转回yz.chsi.com.cn
+	resp, err = c.do(ctx, req)
 	if err != nil {
 		logger.Error("Failed to login: %v", err)
 		return err
 	}
-	defer resp.Body.Close()
+	page, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logger.Error("Failed to read login response: %v", err)
+		return err
+	}
+
+	if isLoginPage(resp.Request.URL) {
+		loginErr := classifyLoginFailure(string(page), resp.Request.URL.String())
+		logger.Error("Login rejected: %v", loginErr)
+		return loginErr
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Login returned status code: %d", resp.StatusCode)
+		return &LoginError{Kind: ErrUnexpectedPage, Message: fmt.Sprintf("HTTP %d", resp.StatusCode), URL: resp.Request.URL.String()}
+	}
+
+	// 第四步：确认会话确实建立
+	return c.confirmSession(ctx)
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusFound {
-		logger.Warn("Login returned status code: %d", resp.StatusCode)
+// confirmSession verifies the session after a login attempt
+func (c *ChsiClient) confirmSession(ctx context.Context) error {
+	ok, err := c.CheckSession(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		logger.Error("Login finished but yz.chsi.com.cn session is not established")
+		return &LoginError{Kind: ErrUnexpectedPage, Message: "session not established", URL: c.yzBaseURL}
 	}
 
 	logger.Info("Login successful")
 	return nil
 }
 
+// CheckSession reports whether the current cookies hold a valid yz session.
+// An expired session is redirected to the passport login page.
+func (c *ChsiClient) CheckSession(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.yzBaseURL+"/user/center.jsp", nil)
+	if err != nil {
+		logger.Error("Failed to create session check request: %v", err)
+		return false, err
+	}
+	req.Header.Set("User-Agent", chsiUserAgent)
+
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		logger.Error("Failed to check session: %v", err)
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if isLoginPage(resp.Request.URL) {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// legacyCookie is a cookie saved by earlier versions, keyed by cookieURLs
+type legacyCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// cookieURLs lists the locations whose cookies made up a CHSI session in the
+// legacy format
+func (c *ChsiClient) cookieURLs() []string {
+	return []string{c.passportBaseURL + "/passport/", c.yzBaseURL + "/"}
+}
+
+// ExportCookies serializes the session cookies held by the client together
+// with their domain, path, expiry and flags
+func (c *ChsiClient) ExportCookies() (string, error) {
+	data, err := json.Marshal(c.jar.Saved())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ImportCookies restores cookies produced by ExportCookies. Expired cookies
+// are skipped; cookies saved by earlier versions without attributes are
+// restored as session cookies of the CHSI sites.
+func (c *ChsiClient) ImportCookies(data string) error {
+	data = strings.TrimSpace(data)
+	if data == "" {
+		return nil
+	}
+	if !strings.HasPrefix(data, "[") {
+		return c.importLegacyCookies(data)
+	}
+
+	var saved []savedCookie
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, sc := range saved {
+		if !sc.Expires.IsZero() && !sc.Expires.After(now) {
+			continue
+		}
+		c.jar.SetCookies(sc.url(), []*http.Cookie{sc.cookie()})
+	}
+	return nil
+}
+
+// importLegacyCookies restores cookies saved with names and values only
+func (c *ChsiClient) importLegacyCookies(data string) error {
+	var saved map[string][]legacyCookie
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return err
+	}
+	for _, raw := range c.cookieURLs() {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		var cookies []*http.Cookie
+		for _, lc := range saved[raw] {
+			cookies = append(cookies, &http.Cookie{Name: lc.Name, Value: lc.Value, Path: u.Path})
+		}
+		if len(cookies) > 0 {
+			c.jar.SetCookies(u, cookies)
+		}
+	}
+	return nil
+}
+
+// isLoginPage reports whether u points at the passport login page
+func isLoginPage(u *url.URL) bool {
+	return strings.Contains(u.Path, "/passport/login")
+}
+
+type loginForm struct {
+	action  string
+	fields  url.Values
+	captcha bool
+}
+
+var (
+	formRe      = regexp.MustCompile(`(?is)<form\b([^>]*)>(.*?)</form>`)
+	inputRe     = regexp.MustCompile(`(?is)<input\b([^>]*)>`)
+	attrRe      = regexp.MustCompile(`(?s)([\w:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	tagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	loginMsgRe  = regexp.MustCompile(`(?is)<(div|span|p)\b[^>]*(?:id|class)\s*=\s*["'][^"']*(?:error|msg|tips)[^"']*["'][^>]*>(.*?)</(?:div|span|p)>`)
+	captchaName = regexp.MustCompile(`(?i)captcha|checkcode|yzm`)
+)
+
+// parseAttrs parses the attributes of a single HTML tag
+func parseAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRe.FindAllStringSubmatch(s, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+// parseLoginForm finds the CAS login form and collects its hidden fields
+// (lt, execution, _eventId and any anti-CSRF tokens).
+func parseLoginForm(page string) (loginForm, bool) {
+	for _, m := range formRe.FindAllStringSubmatch(page, -1) {
+		body := m[2]
+		if !strings.Contains(body, `name="username"`) && !strings.Contains(body, `name="execution"`) && !strings.Contains(body, `name="lt"`) {
+			continue
+		}
+
+		form := loginForm{
+			action: parseAttrs(m[1])["action"],
+			fields: url.Values{},
+		}
+		for _, in := range inputRe.FindAllStringSubmatch(body, -1) {
+			attrs := parseAttrs(in[1])
+			name := attrs["name"]
+			if name == "" {
+				continue
+			}
+			typ := strings.ToLower(attrs["type"])
+			if typ == "hidden" {
+				form.fields.Set(name, attrs["value"])
+				continue
+			}
+			if captchaName.MatchString(name) {
+				form.captcha = true
+			}
+		}
+		return form, true
+	}
+	return loginForm{}, false
+}
+
+// extractLoginMessage returns the error/tip text shown on the passport page
+func extractLoginMessage(page string) string {
+	for _, m := range loginMsgRe.FindAllStringSubmatch(page, -1) {
+		text := strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(m[2], "")))
+		if text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// classifyLoginFailure maps the passport page shown after a rejected login
+// to one of the typed login errors.
+func classifyLoginFailure(page string, pageURL string) *LoginError {
+	msg := extractLoginMessage(page)
+	loginErr := &LoginError{Kind: ErrUnexpectedPage, Message: msg, URL: pageURL}
+
+	switch {
+	case strings.Contains(msg, "验证码"):
+		loginErr.Kind = ErrCaptchaRequired
+	case strings.Contains(msg, "锁定") || strings.Contains(msg, "冻结"):
+		loginErr.Kind = ErrAccountLocked
+	case strings.Contains(msg, "密码") || strings.Contains(msg, "用户名") || strings.Contains(msg, "账号"):
+		loginErr.Kind = ErrBadCredentials
+	default:
+		if form, ok := parseLoginForm(page); ok && form.captcha {
+			loginErr.Kind = ErrCaptchaRequired
+		}
+	}
+	return loginErr
+}
+
 // QueryScore queries exam score from CHSI
-func (c *ChsiClient) QueryScore(user *model.User) (string, error) {
-	logger.Info("Querying score for user: %s (ID: %s, ExamID: %s)", user.Name, user.IDCard, user.ExamID)
+func (c *ChsiClient) QueryScore(ctx context.Context, user *model.User) (string, error) {
+	logger.Info("Querying score for user: %s (ID: %s, ExamID: %s)",
+		secure.MaskName(user.Name), secure.MaskID(user.IDCard), secure.MaskID(user.ExamID))
 
 	queryData := url.Values{}
 	queryData.Set("xm", user.Name)           // 姓名
@@ -97,28 +421,33 @@ func (c *ChsiClient) QueryScore(user *model.User) (string, error) {
 	queryData.Set("bkdwdm", user.SchoolCode) // 报考单位代码
 	queryData.Set("checkcode", "")           // 验证码（模拟可以为空）
 
-	queryURL := "https://yz.chsi.com.cn/apply/cjcx/cjcx.do"
+	queryURL := c.yzBaseURL + "/apply/cjcx/cjcx.do"
 
-	req, err := http.NewRequest("POST", queryURL, bytes.NewBufferString(queryData.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", queryURL, bytes.NewBufferString(queryData.Encode()))
 	if err != nil {
 		logger.Error("Failed to create query request: %v", err)
 		return "", err
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Mobile Safari/537.36")
-	req.Header.Set("Referer", "https://yz.chsi.com.cn/apply/cjcx/t/10358.dhtml")
+	req.Header.Set("User-Agent", chsiUserAgent)
+	req.Header.Set("Referer", c.yzBaseURL+"/apply/cjcx/t/10358.dhtml")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
 		logger.Error("Failed to query score: %v", err)
 		return "", err
 	}
 	defer resp.Body.Close()
 
+	if isLoginPage(resp.Request.URL) {
+		logger.Warn("Query redirected to login page, session expired")
+		return "", ErrSessionExpired
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		logger.Error("Query returned status code: %d", resp.StatusCode)
-		return "", err
+		return "", &HTTPStatusError{StatusCode: resp.StatusCode}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -134,19 +463,19 @@ func (c *ChsiClient) QueryScore(user *model.User) (string, error) {
 }
 
 // ParseScore parses score from HTML response using Vue's cj JSON object
-func (c *ChsiClient) ParseScore(htmlContent string) (string, error) {
+func (c *ChsiClient) ParseScore(htmlContent string) (*model.ScoreResult, error) {
 	logger.Info("Parsing score from HTML response")
 
 	if htmlContent == "" {
 		logger.Warn("Score query status: Empty HTML response - possible network error or invalid session")
-		return "", nil
+		return &model.ScoreResult{Status: model.QueryStatusPending}, nil
 	}
 
 	html := string(htmlContent)
 
 	// Step 1: Extract Vue's cj object using improved regex
-	// Try pattern 1: cj : {...}
-	re := regexp.MustCompile(`(?s)\bcj\s*:\s*(\{[^}]*\}|null)`)
+	// Try pattern 1: cj : {...} or cj = {...}
+	re := regexp.MustCompile(`(?s)\bcj\s*[:=]\s*(\{[^}]*\}|null)`)
 	matches := re.FindStringSubmatch(html)
 
 	var raw string
@@ -156,7 +485,7 @@ func (c *ChsiClient) ParseScore(htmlContent string) (string, error) {
 
 	// If simple pattern failed, try more complex pattern for nested objects
 	if raw == "" {
-		re = regexp.MustCompile(`(?s)\bcj\s*:\s*(\{(?:[^{}]|(?:\{[^{}]*\}))*\}|null)`)
+		re = regexp.MustCompile(`(?s)\bcj\s*[:=]\s*(\{(?:[^{}]|(?:\{[^{}]*\}))*\}|null)`)
 		matches = re.FindStringSubmatch(html)
 		if len(matches) >= 2 {
 			raw = matches[1]
@@ -174,7 +503,7 @@ func (c *ChsiClient) ParseScore(htmlContent string) (string, error) {
 
 	if raw == "" {
 		logger.Warn("Score query status: Could not find score data structure in response")
-		return "", nil
+		return &model.ScoreResult{Status: model.QueryStatusPending}, nil
 	}
 
 	logger.Debug("Extracted raw cj data: %s", raw[:minInt(100, len(raw))])
@@ -192,17 +521,13 @@ func (c *ChsiClient) ParseScore(htmlContent string) (string, error) {
 		logger.Warn("Score query status: ⏳ No query result available - msg: %s", msg)
 
 		// Categorize the message to provide more specific status
-		if bytes.Contains([]byte(msg), []byte("信息不匹配")) {
+		if strings.Contains(msg, "信息不匹配") {
 			logger.Warn("  └─ Detailed: 信息不匹配 (User information doesn't match CHSI records)")
-			return "", nil
+			return &model.ScoreResult{Status: model.QueryStatusInfoMismatch, Message: msg}, nil
 		}
 
-		if bytes.Contains([]byte(msg), []byte("暂未")) || bytes.Contains([]byte(msg), []byte("未开放")) {
-			logger.Info("  └─ Status: Scores not yet published")
-			return "", nil
-		}
-
-		return "", nil
+		logger.Info("  └─ Status: Scores not yet published")
+		return &model.ScoreResult{Status: model.QueryStatusNotPublished, Message: msg}, nil
 	}
 
 	// Step 3: Parse cj as JSON
@@ -210,67 +535,89 @@ func (c *ChsiClient) ParseScore(htmlContent string) (string, error) {
 	if err := json.Unmarshal([]byte(raw), &scoreData); err != nil {
 		logger.Error("Score query status: Failed to parse score data as JSON: %v", err)
 		logger.Debug("Raw data was: %s", raw[:minInt(200, len(raw))])
-		return "", err
+		return nil, err
 	}
 
 	logger.Debug("Successfully parsed score JSON with %d fields", len(scoreData))
 
 	// Step 4: Extract score information
-	// Build score string from available fields
-	var scoreStr string
-
-	// Common score fields based on CHSI structure
-	scoreFields := []string{"总分", "zf", "total_score", "zsxh", "ksbh", "xm", "zymc"}
-	for _, field := range scoreFields {
-		if val, ok := scoreData[field]; ok && val != "" && val != nil {
-			scoreStr += fmt.Sprintf("%s: %v; ", field, val)
+	field := func(keys ...string) string {
+		for _, key := range keys {
+			if val, ok := scoreData[key]; ok && val != nil {
+				if s := strings.TrimSpace(fmt.Sprintf("%v", val)); s != "" {
+					return s
+				}
+			}
 		}
+		return ""
 	}
 
-	if scoreStr != "" {
-		logger.Info("Score query status: ✅ Score found - %s", scoreStr)
-		return scoreStr, nil
+	result := &model.ScoreResult{
+		CandidateName:   field("xm"),
+		ExamID:          field("ksbh"),
+		Major:           field("zymc"),
+		Politics:        model.SubjectScore{Name: field("zzllmc"), Score: field("zzllcj", "zzll", "政治")},
+		ForeignLanguage: model.SubjectScore{Name: field("wgymc"), Score: field("wgycj", "wgy", "外语")},
+		Subject1:        model.SubjectScore{Name: field("ywk1mc"), Score: field("ywk1cj", "ywk1", "业务课一")},
+		Subject2:        model.SubjectScore{Name: field("ywk2mc"), Score: field("ywk2cj", "ywk2", "业务课二")},
+		Total:           field("zf", "总分", "total_score", "zcj", "cxsj", "初试成绩", "cs_cj"),
+		Rank:            field("pm", "排名"),
+		RankTotal:       field("zrs", "总人数"),
+		Admission:       field("lqzt", "录取状态", "psyz", "拟录取"),
+		Note:            field("zsdwsm"),
+		Raw:             raw,
 	}
 
-	// Check for admission status fields
-	admissionFields := []string{"lqzt", "录取状态", "psyz", "拟录取"}
-	for _, field := range admissionFields {
-		if val, ok := scoreData[field]; ok && val != "" && val != nil {
-			valStr := fmt.Sprintf("%v", val)
-			if bytes.Contains([]byte(valStr), []byte("录取")) {
-				logger.Info("Score query status: ✅ Admission status found - %s: %v", field, val)
-				return valStr, nil
-			}
-			if bytes.Contains([]byte(valStr), []byte("体检")) {
-				logger.Info("Score query status: 📋 Physical exam stage - %s: %v", field, val)
-				return "", nil
-			}
-			if bytes.Contains([]byte(valStr), []byte("复试")) {
-				logger.Info("Score query status: 📝 Reexamination/interview stage - %s: %v", field, val)
-				return "", nil
-			}
-		}
-	}
-
-	// Check for preliminary score
-	preliminaryFields := []string{"cxsj", "初试成绩", "cs_cj"}
-	for _, field := range preliminaryFields {
-		if val, ok := scoreData[field]; ok && val != "" && val != nil {
-			logger.Info("Score query status: 📊 Preliminary score - %s: %v", field, val)
-			return fmt.Sprintf("%v", val), nil
-		}
+	// Check for admission status fields, the most advanced stage wins. A
+	// rejection ends the process, only the score is reported then, or the
+	// rejection itself when there is no score.
+	rejected := admissionRejected(result.Admission)
+	switch {
+	case rejected && result.HasScore():
+		result.Status = model.QueryStatusScoreReleased
+		logger.Info("Score query status: ❎ Not admitted - %s", result.Admission)
+	case rejected:
+		result.Status = model.QueryStatusRejected
+		logger.Info("Score query status: ❎ Not admitted, no score found - %s", result.Admission)
+	case strings.Contains(result.Admission, "录取"):
+		result.Status = model.QueryStatusAdmitted
+		logger.Info("Score query status: ✅ Admission status found - %s", result.Admission)
+	case strings.Contains(result.Admission, "体检"):
+		result.Status = model.QueryStatusPhysicalExam
+		logger.Info("Score query status: 📋 Physical exam stage - %s", result.Admission)
+	case strings.Contains(result.Admission, "复试"):
+		result.Status = model.QueryStatusReexam
+		logger.Info("Score query status: 📝 Reexamination/interview stage - %s", result.Admission)
+	case result.HasScore():
+		result.Status = model.QueryStatusScoreReleased
+		logger.Info("Score query status: ✅ Score found - %s", result.Summary())
+	default:
+		result.Status = model.QueryStatusPending
+		// Unknown status - log all fields for debugging
+		logger.Debug("Score query status: All score fields - %+v", scoreData)
+		logger.Info("Score query status: ℹ️  No definitive score or admission status detected yet")
 	}
 
 	// Check for zsdwsm (招生单位说明 - admission office note)
-	if note, ok := scoreData["zsdwsm"]; ok && note != "" && note != nil {
-		logger.Info("Score query status: 📌 Admission office note: %v", note)
+	if result.Note != "" {
+		logger.Info("Score query status: 📌 Admission office note: %s", result.Note)
 	}
 
-	// Unknown status - log all fields for debugging
-	logger.Debug("Score query status: All score fields - %+v", scoreData)
-	logger.Info("Score query status: ℹ️  No definitive score or admission status detected yet")
+	return result, nil
+}
+
+// rejectionPhrases mark an admission status that says the candidate was not
+// admitted, all of which contain "录取" themselves
+var rejectionPhrases = []string{"未录取", "不录取", "不拟录取", "不予录取", "未拟录取", "未被录取", "不予拟录取"}
 
-	return "", nil
+// admissionRejected reports whether an admission status is a rejection
+func admissionRejected(admission string) bool {
+	for _, phrase := range rejectionPhrases {
+		if strings.Contains(admission, phrase) {
+			return true
+		}
+	}
+	return false
 }
 
 // Helper function for min integer
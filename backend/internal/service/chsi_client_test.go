@@ -1,8 +1,17 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
 
+	"chsi-auto-score-query/internal/model"
 	"chsi-auto-score-query/pkg/config"
 )
 
@@ -14,10 +23,11 @@ func TestParseScoreWithJson(t *testing.T) {
 	client := NewChsiClient(cfg)
 
 	tests := []struct {
-		name        string
-		htmlContent string
-		expectScore string
-		expectError bool
+		name         string
+		htmlContent  string
+		expectStatus model.QueryStatus
+		expectTotal  string
+		expectError  bool
 	}{
 		{
 			name: "Score found",
@@ -29,8 +39,9 @@ func TestParseScoreWithJson(t *testing.T) {
 					</script>
 				</html>
 			`,
-			expectScore: "245",
-			expectError: false,
+			expectStatus: model.QueryStatusAdmitted,
+			expectTotal:  "245",
+			expectError:  false,
 		},
 		{
 			name: "Score not published (cj is null)",
@@ -42,8 +53,8 @@ func TestParseScoreWithJson(t *testing.T) {
 					</script>
 				</html>
 			`,
-			expectScore: "",
-			expectError: false,
+			expectStatus: model.QueryStatusNotPublished,
+			expectError:  false,
 		},
 		{
 			name: "Information mismatch",
@@ -55,8 +66,8 @@ func TestParseScoreWithJson(t *testing.T) {
 					</script>
 				</html>
 			`,
-			expectScore: "",
-			expectError: false,
+			expectStatus: model.QueryStatusInfoMismatch,
+			expectError:  false,
 		},
 		{
 			name: "Admission status",
@@ -67,8 +78,40 @@ func TestParseScoreWithJson(t *testing.T) {
 					</script>
 				</html>
 			`,
-			expectScore: "已录取",
-			expectError: false,
+			expectStatus: model.QueryStatusAdmitted,
+			expectError:  false,
+		},
+		{
+			name: "Not admitted",
+			htmlContent: `
+				<script>
+					var cj = {"zf": "301", "lqzt": "不拟录取", "xm": "李四"};
+				</script>
+			`,
+			expectStatus: model.QueryStatusScoreReleased,
+			expectTotal:  "301",
+			expectError:  false,
+		},
+		{
+			name: "Rejected after re-examination",
+			htmlContent: `
+				<script>
+					var cj = {"zf": "330", "lqzt": "复试不合格，不予录取", "xm": "李四"};
+				</script>
+			`,
+			expectStatus: model.QueryStatusScoreReleased,
+			expectTotal:  "330",
+			expectError:  false,
+		},
+		{
+			name: "Not admitted without score",
+			htmlContent: `
+				<script>
+					var cj = {"lqzt": "未录取", "xm": "李四"};
+				</script>
+			`,
+			expectStatus: model.QueryStatusRejected,
+			expectError:  false,
 		},
 		{
 			name: "Preliminary score",
@@ -79,24 +122,245 @@ func TestParseScoreWithJson(t *testing.T) {
 					</script>
 				</html>
 			`,
-			expectScore: "385",
-			expectError: false,
+			expectStatus: model.QueryStatusScoreReleased,
+			expectTotal:  "385",
+			expectError:  false,
+		},
+		{
+			name: "Subject scores",
+			htmlContent: `
+				<script>
+					new Vue({data: {cj: {"xm": "赵六", "zzllmc": "思想政治理论", "zzllcj": "71", "wgymc": "英语一", "wgycj": "68",
+						"ywk1mc": "数学一", "ywk1cj": "120", "ywk2mc": "408", "ywk2cj": "115", "zf": "374", "zsdwsm": "复试线另行通知"}}});
+				</script>
+			`,
+			expectStatus: model.QueryStatusScoreReleased,
+			expectTotal:  "374",
+			expectError:  false,
+		},
+		{
+			name:        "Malformed cj",
+			htmlContent: `<script>var cj = {"zf": 1,,};</script>`,
+			expectError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			score, err := client.ParseScore(tt.htmlContent)
+			result, err := client.ParseScore(tt.htmlContent)
 			if (err != nil) != tt.expectError {
 				t.Errorf("ParseScore() error = %v, expectError %v", err, tt.expectError)
 			}
-			if score == "" && tt.expectScore == "" {
-				// Both empty, that's ok
+			if err != nil {
 				return
 			}
-			if score == "" && tt.expectScore != "" {
-				t.Errorf("ParseScore() = %q, expected non-empty score", score)
+			if result.Status != tt.expectStatus {
+				t.Errorf("ParseScore() status = %q, expected %q", result.Status, tt.expectStatus)
+			}
+			if result.Total != tt.expectTotal {
+				t.Errorf("ParseScore() total = %q, expected %q", result.Total, tt.expectTotal)
+			}
+		})
+	}
+}
+
+// fakeCAS is a minimal stand-in for account.chsi.com.cn and yz.chsi.com.cn
+type fakeCAS struct {
+	username string
+	password string
+	// loginError is rendered instead of checking credentials when set
+	loginError string
+	captcha    bool
+	posted     url.Values
+	// logins counts login form posts, loginGate holds them until closed
+	logins    atomic.Int32
+	loginGate chan struct{}
+}
+
+func (f *fakeCAS) loginPage(w http.ResponseWriter, msg string) {
+	captcha := ""
+	if f.captcha {
+		captcha = `<input type="text" id="captcha" name="captcha">`
+	}
+	fmt.Fprintf(w, `<html><body>
+<div id="errorMsg" class="ct_input errors">%s</div>
+<form id="fm1" action="/passport/login?entrytype=yzgr&amp;service=x" method="post">
+	<input type="text" name="username" value="">
+	<input type="password" name="password" value="">
+	%s
+	<input type="hidden" name="lt" value="LT-1-abc">
+	<input type="hidden" name="execution" value="e1s1">
+	<input type="hidden" name="_csrf" value="tok&amp;en">
+	<input type="hidden" name="_eventId" value="submit">
+</form></body></html>`, msg, captcha)
+}
+
+func (f *fakeCAS) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /passport/login", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("CASTGC"); err == nil {
+			http.Redirect(w, r, r.URL.Query().Get("service")+"?ticket=ST-1", http.StatusFound)
+			return
+		}
+		f.loginPage(w, "")
+	})
+	mux.HandleFunc("POST /passport/login", func(w http.ResponseWriter, r *http.Request) {
+		f.logins.Add(1)
+		if f.loginGate != nil {
+			<-f.loginGate
+		}
+		r.ParseForm()
+		f.posted = r.PostForm
+		if f.loginError != "" {
+			f.loginPage(w, f.loginError)
+			return
+		}
+		if r.PostForm.Get("lt") != "LT-1-abc" || r.PostForm.Get("execution") != "e1s1" || r.PostForm.Get("_csrf") != "tok&en" {
+			f.loginPage(w, "非法请求")
+			return
+		}
+		if r.PostForm.Get("username") != f.username || r.PostForm.Get("password") != f.password {
+			f.loginPage(w, "您输入的用户名或密码有误")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "CASTGC", Value: "TGT-1", Path: "/"})
+		http.Redirect(w, r, "/j_spring_cas_security_check?ticket=ST-1", http.StatusFound)
+	})
+	mux.HandleFunc("GET /j_spring_cas_security_check", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ticket") == "ST-1" {
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "yz-session", Path: "/"})
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>研招网</html>")
+	})
+	mux.HandleFunc("GET /user/center.jsp", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != "yz-session" {
+			http.Redirect(w, r, "/passport/login?service=x", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "<html>个人中心 退出</html>")
+	})
+	return mux
+}
+
+func newTestChsiClient(t *testing.T, cas *fakeCAS) *ChsiClient {
+	t.Helper()
+	srv := httptest.NewServer(cas.handler())
+	t.Cleanup(srv.Close)
+
+	client := NewChsiClient(&config.Config{ChsiUsername: "user", ChsiPassword: "secret"})
+	client.passportBaseURL = srv.URL
+	client.yzBaseURL = srv.URL
+	return client
+}
+
+func TestLoginSuccess(t *testing.T) {
+	cas := &fakeCAS{username: "user", password: "secret"}
+	client := newTestChsiClient(t, cas)
+
+	if err := client.Login(context.Background()); err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if got := cas.posted.Get("_csrf"); got != "tok&en" {
+		t.Errorf("posted _csrf = %q, want hidden field value", got)
+	}
+
+	ok, err := client.CheckSession(context.Background())
+	if err != nil || !ok {
+		t.Errorf("CheckSession() = %v, %v; want true, nil", ok, err)
+	}
+
+	// A second login reuses the CAS ticket-granting cookie
+	cas.posted = nil
+	if err := client.Login(context.Background()); err != nil {
+		t.Fatalf("second Login() error = %v", err)
+	}
+	if cas.posted != nil {
+		t.Errorf("second Login() posted the form again")
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		cas     *fakeCAS
+		wantErr error
+	}{
+		{
+			name:    "Bad credentials",
+			cas:     &fakeCAS{username: "user", password: "other"},
+			wantErr: ErrBadCredentials,
+		},
+		{
+			name:    "Captcha on login page",
+			cas:     &fakeCAS{username: "user", password: "secret", captcha: true},
+			wantErr: ErrCaptchaRequired,
+		},
+		{
+			name:    "Captcha after submit",
+			cas:     &fakeCAS{loginError: "请输入验证码"},
+			wantErr: ErrCaptchaRequired,
+		},
+		{
+			name:    "Account locked",
+			cas:     &fakeCAS{loginError: "您的账号已被锁定，请30分钟后再试"},
+			wantErr: ErrAccountLocked,
+		},
+		{
+			name:    "Unknown message",
+			cas:     &fakeCAS{loginError: "系统繁忙"},
+			wantErr: ErrUnexpectedPage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestChsiClient(t, tt.cas)
+			err := client.Login(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+			var loginErr *LoginError
+			if !errors.As(err, &loginErr) {
+				t.Fatalf("Login() error is %T, want *LoginError", err)
 			}
 		})
 	}
 }
+
+func TestLoginUnexpectedPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>系统维护中</html>")
+	}))
+	defer srv.Close()
+
+	client := NewChsiClient(&config.Config{ChsiUsername: "user", ChsiPassword: "secret"})
+	client.passportBaseURL = srv.URL
+	client.yzBaseURL = srv.URL
+	if err := client.Login(context.Background()); !errors.Is(err, ErrUnexpectedPage) {
+		t.Fatalf("Login() error = %v, want ErrUnexpectedPage", err)
+	}
+}
+
+func TestAttemptErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{context.DeadlineExceeded, model.AttemptErrorTimeout},
+		{fmt.Errorf("query: %w", context.Canceled), model.AttemptErrorCanceled},
+		{ErrSessionExpired, model.AttemptErrorSessionExpired},
+		{&HTTPStatusError{StatusCode: 502}, model.AttemptErrorHTTPStatus},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, model.AttemptErrorNetwork},
+		{errors.New("boom"), model.AttemptErrorOther},
+	} {
+		if got := attemptErrorClass(tc.err); got != tc.want {
+			t.Errorf("attemptErrorClass(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+	if got := loginErrorClass(&LoginError{Kind: errors.New("bad password")}); got != model.AttemptErrorLogin {
+		t.Errorf("loginErrorClass() = %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// savedCookie is the persisted form of a session cookie. Domain and Path are
+// the effective values the cookie was stored under, so it can be put back into
+// a jar exactly where it came from.
+type savedCookie struct {
+	Name     string        `json:"name"`
+	Value    string        `json:"value"`
+	Domain   string        `json:"domain,omitempty"`
+	HostOnly bool          `json:"host_only,omitempty"`
+	Path     string        `json:"path,omitempty"`
+	Expires  time.Time     `json:"expires,omitempty"`
+	Secure   bool          `json:"secure,omitempty"`
+	HttpOnly bool          `json:"http_only,omitempty"`
+	SameSite http.SameSite `json:"same_site,omitempty"`
+}
+
+// key identifies the cookie the way a jar does
+func (sc savedCookie) key() string {
+	return sc.Domain + ";" + sc.Path + ";" + sc.Name
+}
+
+// url returns a location the cookie is sent to
+func (sc savedCookie) url() *url.URL {
+	return &url.URL{Scheme: "https", Host: sc.Domain, Path: sc.Path}
+}
+
+// cookie returns the Set-Cookie form of sc
+func (sc savedCookie) cookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     sc.Name,
+		Value:    sc.Value,
+		Path:     sc.Path,
+		Expires:  sc.Expires,
+		Secure:   sc.Secure,
+		HttpOnly: sc.HttpOnly,
+		SameSite: sc.SameSite,
+	}
+	if !sc.HostOnly {
+		cookie.Domain = sc.Domain
+	}
+	return cookie
+}
+
+// sessionJar is a cookie jar that remembers the attributes of the cookies it
+// stores. The standard jar only hands back names and values, which is not
+// enough to restore a session after a restart.
+type sessionJar struct {
+	*cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie
+}
+
+func newSessionJar() *sessionJar {
+	jar, _ := cookiejar.New(nil)
+	return &sessionJar{Jar: jar, cookies: make(map[string]savedCookie)}
+}
+
+// SetCookies implements http.CookieJar
+func (j *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, cookie := range cookies {
+		sc := savedCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   strings.TrimPrefix(strings.ToLower(cookie.Domain), "."),
+			Path:     cookie.Path,
+			Expires:  cookie.Expires,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+			SameSite: cookie.SameSite,
+		}
+		if sc.Domain == "" {
+			sc.Domain = strings.ToLower(u.Hostname())
+			sc.HostOnly = true
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			sc.Path = defaultCookiePath(u.Path)
+		}
+		if cookie.MaxAge > 0 {
+			sc.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+
+		key := sc.key()
+		if cookie.MaxAge < 0 || (!sc.Expires.IsZero() && !sc.Expires.After(now)) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = sc
+	}
+}
+
+// Saved returns the cookies the jar still holds, with their attributes, in
+// a stable order so unchanged cookies export to the same data
+func (j *sessionJar) Saved() []savedCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var saved []savedCookie
+	for key, sc := range j.cookies {
+		// Cookies the jar rejected or has dropped since are not kept
+		if !j.holds(sc) {
+			delete(j.cookies, key)
+			continue
+		}
+		saved = append(saved, sc)
+	}
+	sort.Slice(saved, func(a, b int) bool {
+		return saved[a].key() < saved[b].key()
+	})
+	return saved
+}
+
+// holds reports whether the underlying jar still returns sc unchanged
+func (j *sessionJar) holds(sc savedCookie) bool {
+	for _, cookie := range j.Jar.Cookies(sc.url()) {
+		if cookie.Name == sc.Name && cookie.Value == sc.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultCookiePath is the default-path of RFC 6265 section 5.1.4
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
@@ -1,83 +1,115 @@
 package service
 
 import (
-"fmt"
-"net/smtp"
+	"context"
+	netmail "net/mail"
+	"time"
 
-"chsi-auto-score-query/internal/logger"
-"chsi-auto-score-query/pkg/config"
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/mail"
+	"chsi-auto-score-query/pkg/config"
 )
 
 type EmailService struct {
-	cfg *config.Config
+	cfg       *config.Config
+	templates *mail.Templates
+	transport *mail.Transport
 }
 
 func NewEmailService(cfg *config.Config) *EmailService {
-	return &EmailService{cfg: cfg}
+	templates, err := mail.LoadTemplates(cfg.EmailTemplateDir)
+	if err != nil {
+		logger.Error("Failed to load email templates from %s, using built-in templates: %v", cfg.EmailTemplateDir, err)
+		templates, _ = mail.LoadTemplates("")
+	}
+	transport := mail.NewTransport(mail.SMTPConfig{
+		Host:        cfg.SMTPServer,
+		Port:        cfg.SMTPPort,
+		Username:    cfg.SMTPUser,
+		Password:    cfg.SMTPPass,
+		TLSMode:     cfg.SMTPTLSMode,
+		SkipVerify:  cfg.SMTPSkipVerify,
+		Auth:        cfg.SMTPAuth,
+		PoolSize:    cfg.SMTPPoolSize,
+		IdleTimeout: time.Duration(cfg.SMTPIdleTimeout) * time.Second,
+	})
+	return &EmailService{cfg: cfg, templates: templates, transport: transport}
+}
+
+// Close closes the pooled SMTP connections
+func (s *EmailService) Close() error {
+	return s.transport.Close()
+}
+
+// Render renders a notification template in the given locale
+func (s *EmailService) Render(locale, kind string, data interface{}) (*mail.Content, error) {
+	content, err := s.templates.Render(locale, kind, data)
+	if err != nil {
+		logger.Error("Failed to render %s email template: %v", kind, err)
+		return nil, err
+	}
+	return content, nil
 }
 
-// SendScore sends exam score to user email
-func (s *EmailService) SendScore(toEmail string, name string, score string) error {
-	logger.Info("Preparing to send score email to: %s", toEmail)
-
-	// Build email content
-	subject := "考研成绩已发布"
-	body := fmt.Sprintf(`<html><body>
-<h2>尊敬的 %s：</h2>
-<p>您的考研成绩已发布，请登录学信网查看详情。</p>
-<p><strong>成绩信息：</strong> %s</p>
-<p>祝贺您！</p>
-<p>此邮件由自动查询系统发送，请勿回复。</p>
-</body></html>`, name, score)
-
-	return s.sendSMTPEmail(toEmail, subject, body)
+// SendVerification sends the double opt-in link for a new submission
+func (s *EmailService) SendVerification(ctx context.Context, toEmail string, name string, locale string, link string, expiresAt time.Time) error {
+	logger.Info("Preparing to send verification email to: %s", toEmail)
+
+	content, err := s.Render(locale, mail.KindVerification, mail.VerificationData{Name: name, Link: link, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return s.sendSMTPEmail(ctx, toEmail, content)
 }
 
-// SendError sends error notification to user
-func (s *EmailService) SendError(toEmail string, errMsg string) error {
-	logger.Info("Preparing to send error email to: %s", toEmail)
+// SendRecipientVerification asks an additional recipient of owner's
+// submission to opt in to its notifications
+func (s *EmailService) SendRecipientVerification(ctx context.Context, toEmail string, owner string, locale string, link string, expiresAt time.Time) error {
+	logger.Info("Preparing to send recipient verification email to: %s", toEmail)
+
+	content, err := s.Render(locale, mail.KindRecipientVerification, mail.RecipientVerificationData{Owner: owner, Link: link, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return s.sendSMTPEmail(ctx, toEmail, content)
+}
 
-	subject := "成绩查询失败通知"
-	body := fmt.Sprintf(`<html><body>
-<h2>成绩查询失败</h2>
-<p>您的考研成绩查询失败，原因如下：</p>
-<p><strong>错误信息：</strong> %s</p>
-<p>请检查您的个人信息是否正确，或稍后重试。</p>
-<p>此邮件由自动查询系统发送，请勿回复。</p>
-</body></html>`, errMsg)
+// SendManageLink sends the magic link for managing a submission
+func (s *EmailService) SendManageLink(ctx context.Context, toEmail string, name string, locale string, link string, expiresAt time.Time) error {
+	logger.Info("Preparing to send management link to: %s", toEmail)
 
-	return s.sendSMTPEmail(toEmail, subject, body)
+	content, err := s.Render(locale, mail.KindManage, mail.ManageData{Name: name, Link: link, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	return s.sendSMTPEmail(ctx, toEmail, content)
 }
 
 // sendSMTPEmail sends email via SMTP
-func (s *EmailService) sendSMTPEmail(toEmail string, subject string, body string) error {
+func (s *EmailService) sendSMTPEmail(ctx context.Context, toEmail string, content *mail.Content) error {
 	if s.cfg.SMTPUser == "" || s.cfg.SMTPPass == "" {
 		logger.Warn("SMTP configuration incomplete, skipping email send to %s", toEmail)
 		return nil
 	}
 
 	// Build email message
-	message := fmt.Sprintf("To: %s\r\n"+
-"Subject: %s\r\n"+
-"Content-Type: text/html; charset=UTF-8\r\n"+
-"\r\n"+
-"%s", toEmail, subject, body)
-
-	// Setup SMTP server
-	host := s.cfg.SMTPServer
-	port := fmt.Sprintf(":%d", s.cfg.SMTPPort)
-	auth := smtp.PlainAuth("", s.cfg.SMTPUser, s.cfg.SMTPPass, s.cfg.SMTPServer)
-
-	// Send email
-	logger.Debug("Sending email to %s via %s:%d", toEmail, host, s.cfg.SMTPPort)
-	err := smtp.SendMail(
-host+port,
-auth,
-s.cfg.SMTPUser,
-[]string{toEmail},
-[]byte(message),
-)
+	msg := &mail.Message{
+		From:            s.from(),
+		To:              []netmail.Address{{Address: toEmail}},
+		Subject:         content.Subject,
+		Text:            content.Text,
+		HTML:            content.HTML,
+		ListUnsubscribe: content.Unsubscribe,
+	}
+	message, err := msg.Bytes()
+	if err != nil {
+		logger.Error("Failed to build email to %s: %v", toEmail, err)
+		return err
+	}
 
+	// Send email over a pooled connection
+	logger.Debug("Sending email to %s via %s:%d", toEmail, s.cfg.SMTPServer, s.cfg.SMTPPort)
+	err = s.transport.Send(ctx, msg.From.Address, []string{toEmail}, message)
 	if err != nil {
 		logger.Error("Failed to send email to %s: %v", toEmail, err)
 		return err
@@ -86,3 +118,12 @@ s.cfg.SMTPUser,
 	logger.Info("Email sent successfully to: %s", toEmail)
 	return nil
 }
+
+// from returns the sender address, defaulting to the SMTP account
+func (s *EmailService) from() netmail.Address {
+	address := s.cfg.SMTPFrom
+	if address == "" {
+		address = s.cfg.SMTPUser
+	}
+	return netmail.Address{Name: s.cfg.SMTPFromName, Address: address}
+}
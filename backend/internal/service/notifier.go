@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/mail"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+)
+
+// ChannelEmail is the default notification channel
+const ChannelEmail = "email"
+
+// NewNotifierRegistry returns the registry of all notification channels,
+// including email backed by the given EmailService.
+func NewNotifierRegistry(emailSvc *EmailService) *notify.Registry {
+	registry := notify.NewRegistry()
+	registry.Register(ChannelEmail, func(settings map[string]string) (notify.Notifier, error) {
+		to := strings.TrimSpace(settings["to"])
+		if to == "" {
+			return nil, errors.New("email channel requires setting(s): to")
+		}
+		return &emailNotifier{svc: emailSvc, to: to}, nil
+	})
+	return registry
+}
+
+// emailNotifier adapts EmailService to the Notifier interface
+type emailNotifier struct {
+	svc *EmailService
+	to  string
+}
+
+func (n *emailNotifier) Send(ctx context.Context, msg notify.Message) error {
+	logger.Info("Preparing to send %s email to: %s", msg.Event, n.to)
+	return n.svc.sendSMTPEmail(ctx, n.to, &mail.Content{
+		Subject:     msg.Title,
+		HTML:        msg.HTML,
+		Text:        msg.Text,
+		Unsubscribe: msg.Unsubscribe,
+	})
+}
+
+// ChannelSettings returns the settings of a channel. Email channels always
+// send to the verified submission email.
+func ChannelSettings(email string, channel model.NotificationChannel) map[string]string {
+	settings := channel.SettingsMap()
+	if channel.Type == ChannelEmail {
+		settings["to"] = email
+	}
+	return settings
+}
+
+// channelKey identifies a channel in Notification.Delivered
+func channelKey(channel model.NotificationChannel) string {
+	if channel.ID == 0 {
+		return channel.Type
+	}
+	return fmt.Sprintf("%s#%d", channel.Type, channel.ID)
+}
+
+// deliver sends a message through every channel chosen by the user, falling
+// back to email when none were chosen, and emails it to the confirmed
+// additional recipients of the submission. Recipients get their own
+// unsubscribe link, which only removes them. Channels and recipients that already got the
+// notification are skipped and successful ones are recorded in n, so a retry
+// only resends to the channels that failed.
+func (s *QueryService) deliver(ctx context.Context, user *model.User, msg notify.Message, n *model.Notification) error {
+	channels, err := s.userRepo.FindChannels(user.ID)
+	if err != nil {
+		return err
+	}
+	if len(channels) == 0 {
+		channels = []model.NotificationChannel{{Type: ChannelEmail}}
+	}
+
+	var errs []error
+	for _, channel := range channels {
+		key := channelKey(channel)
+		if n.IsDelivered(key) {
+			continue
+		}
+		notifier, err := s.notifiers.New(channel.Type, ChannelSettings(user.Email, channel))
+		if err == nil {
+			err = notifier.Send(ctx, msg)
+		}
+		if err != nil {
+			logger.Error("Failed to notify user %s via %s: %v", user.Email, channel.Type, err)
+			errs = append(errs, fmt.Errorf("%s: %w", channel.Type, err))
+			continue
+		}
+		n.MarkDelivered(key)
+		logger.Debug("Notified user %s via %s", user.Email, channel.Type)
+	}
+
+	// 额外收件人确认后通过邮件接收同一通知
+	recipients, err := s.userRepo.FindRecipients(user.ID)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, recipient := range recipients {
+		key := fmt.Sprintf("recipient#%d", recipient.ID)
+		if recipient.VerifiedAt == nil || n.IsDelivered(key) {
+			continue
+		}
+		recipientMsg := msg
+		recipientMsg.Unsubscribe = s.recipientUnsubscribeLink(recipient)
+		notifier, err := s.notifiers.New(ChannelEmail, map[string]string{"to": recipient.Email})
+		if err == nil {
+			err = notifier.Send(ctx, recipientMsg)
+		}
+		if err != nil {
+			logger.Error("Failed to notify recipient %s of user %d: %v", recipient.Email, user.ID, err)
+			errs = append(errs, fmt.Errorf("recipient %s: %w", recipient.Email, err))
+			continue
+		}
+		n.MarkDelivered(key)
+		logger.Debug("Notified recipient %s of user %d", recipient.Email, user.ID)
+	}
+	return errors.Join(errs...)
+}
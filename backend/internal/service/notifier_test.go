@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
+)
+
+// newTestOutbox returns an outbox whose channels may reach the local test
+// servers
+func newTestOutbox(t *testing.T, db *gorm.DB, cfg *config.Config) *Outbox {
+	notify.AllowPrivateAddresses(true)
+	t.Cleanup(func() { notify.AllowPrivateAddresses(false) })
+
+	// SMTP is not configured, so the email notifier is a no-op
+	emailSvc := NewEmailService(cfg)
+	return NewOutbox(db, cfg, &QueryService{
+		userRepo:  repo.NewUserRepo(db),
+		emailSvc:  emailSvc,
+		notifiers: NewNotifierRegistry(emailSvc),
+	})
+}
+
+// notifierFunc adapts a function to notify.Notifier
+type notifierFunc func(ctx context.Context, msg notify.Message) error
+
+func (f notifierFunc) Send(ctx context.Context, msg notify.Message) error {
+	return f(ctx, msg)
+}
+
+func TestOutboxDeliversToChosenChannels(t *testing.T) {
+	var received []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, payload)
+	}))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	userRepo := repo.NewUserRepo(db)
+	user := &model.User{Name: "张三", Email: "a@example.com", InfoHash: "h1"}
+	webhook := model.NotificationChannel{Type: "webhook"}
+	webhook.SetSettings(map[string]string{"url": srv.URL})
+	user.Channels = []model.NotificationChannel{webhook}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	// Users without channels fall back to email
+	other := &model.User{Name: "李四", Email: "b@example.com", InfoHash: "h2"}
+	if err := userRepo.Create(other); err != nil {
+		t.Fatal(err)
+	}
+
+	result := &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "400"}
+	for _, u := range []*model.User{user, other} {
+		if notify, err := userRepo.SaveResult(u, result); err != nil || !notify {
+			t.Fatalf("SaveResult() = %v, %v", notify, err)
+		}
+	}
+
+	outbox := newTestOutbox(t, db, &config.Config{NotifyMaxAttempts: 3})
+	outbox.Dispatch(context.Background())
+	if len(received) != 1 {
+		t.Fatalf("webhook received %d messages, want 1", len(received))
+	}
+	if received[0]["event"] != "score" || !strings.Contains(received[0]["text"], "总分：400") {
+		t.Errorf("unexpected payload %v", received[0])
+	}
+
+	sent, total, err := outbox.repo.List(0, model.NotificationSent, 10, 0)
+	if err != nil || total != 2 {
+		t.Fatalf("List(sent) = %d, %v; want 2", total, err)
+	}
+	if sent[0].SentAt == nil || sent[0].Attempts != 1 {
+		t.Errorf("unexpected notification %+v", sent[0])
+	}
+
+	// Sent notifications are not delivered again
+	outbox.Dispatch(context.Background())
+	if len(received) != 1 {
+		t.Errorf("webhook received %d messages after second dispatch, want 1", len(received))
+	}
+}
+
+func TestOutboxRetriesFailedChannelsAndDeadLetters(t *testing.T) {
+	var good, bad int
+	goodSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { good++ }))
+	defer goodSrv.Close()
+	badSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bad++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer badSrv.Close()
+
+	db := newTestDB(t)
+	userRepo := repo.NewUserRepo(db)
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Attempts: 3, LastQueryAt: time.Now()}
+	for _, url := range []string{goodSrv.URL, badSrv.URL} {
+		channel := model.NotificationChannel{Type: "webhook"}
+		channel.SetSettings(map[string]string{"url": url})
+		user.Channels = append(user.Channels, channel)
+	}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userRepo.UpdatePolling(user.ID, func(user *model.User) *model.Notification {
+		user.Status = model.QueryStatusFailed
+		return model.NewFailedNotification(user, "考生信息无法查询")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := newTestOutbox(t, db, &config.Config{NotifyMaxAttempts: 2, NotifyBackoffBase: 60, NotifyBackoffMax: 60})
+	outbox.Dispatch(context.Background())
+
+	n, _ := outbox.repo.FindByID(1)
+	if n.Status != model.NotificationPending || n.Attempts != 1 || n.NextAttemptAt == nil || n.LastError == "" {
+		t.Fatalf("after failed attempt: %+v", n)
+	}
+	// Not due yet
+	outbox.Dispatch(context.Background())
+	if bad != 1 {
+		t.Fatalf("bad channel called %d times before retry was due", bad)
+	}
+
+	past := time.Now().Add(-time.Second)
+	n.NextAttemptAt = &past
+	outbox.repo.Update(n)
+	outbox.Dispatch(context.Background())
+
+	n, _ = outbox.repo.FindByID(1)
+	if n.Status != model.NotificationDead || n.Attempts != 2 {
+		t.Errorf("after retry budget: status %q, attempts %d; want dead, 2", n.Status, n.Attempts)
+	}
+	if good != 1 || bad != 2 {
+		t.Errorf("good channel called %d times, bad %d; want 1 and 2", good, bad)
+	}
+
+	// A requeued notification is due right away
+	if err := outbox.repo.Requeue(n); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := outbox.repo.FindDue(time.Now(), 10); len(due) != 1 {
+		t.Errorf("FindDue() after Requeue = %d, want 1", len(due))
+	}
+}
+
+func TestOutboxFansOutToRecipientsByPreference(t *testing.T) {
+	var events []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		events = append(events, payload["event"])
+	}))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	userRepo := repo.NewUserRepo(db)
+	now := time.Now()
+	channel := model.NotificationChannel{Type: "webhook"}
+	channel.SetSettings(map[string]string{"url": srv.URL})
+	user := &model.User{
+		Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now,
+		Channels:   []model.NotificationChannel{channel},
+		Recipients: []model.Recipient{{Email: "parent@example.com", VerifiedAt: &now}, {Email: "unconfirmed@example.com"}},
+		Preference: &model.NotificationPreference{StatusChange: true, DailyDigest: true},
+	}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+
+	if notify, err := userRepo.SaveResult(user, &model.ScoreResult{Status: model.QueryStatusNotPublished}); err != nil || !notify {
+		t.Fatalf("SaveResult() = %v, %v", notify, err)
+	}
+
+	outbox := newTestOutbox(t, db, &config.Config{NotifyMaxAttempts: 3})
+	outbox.queryService.cfg = &config.Config{PublicBaseURL: "https://example.com"}
+	outbox.queryService.tokens = NewTokenSigner("test-secret")
+	emails := map[string][]notify.Message{}
+	outbox.queryService.notifiers.Register(ChannelEmail, func(settings map[string]string) (notify.Notifier, error) {
+		return notifierFunc(func(ctx context.Context, msg notify.Message) error {
+			emails[settings["to"]] = append(emails[settings["to"]], msg)
+			return nil
+		}), nil
+	})
+	outbox.Dispatch(context.Background())
+	if strings.Join(events, ",") != "status_change,digest" && strings.Join(events, ",") != "digest,status_change" {
+		t.Fatalf("webhook received events %v, want status_change and digest", events)
+	}
+	sent, _, _ := outbox.repo.List(user.ID, model.NotificationSent, 10, 0)
+	for _, n := range sent {
+		if !n.IsDelivered("recipient#1") || n.IsDelivered("recipient#2") {
+			t.Errorf("notification %s delivered to recipients %q, want only the confirmed one", n.Event, n.Delivered)
+		}
+	}
+	if len(emails["parent@example.com"]) != 2 || len(emails["unconfirmed@example.com"]) != 0 {
+		t.Fatalf("emails sent to recipients: %v", emails)
+	}
+	// The recipient's unsubscribe link only removes that recipient
+	link := emails["parent@example.com"][0].Unsubscribe
+	token := link[strings.LastIndex(link, "/")+1:]
+	if !strings.Contains(link, "/api/recipients/unsubscribe/") {
+		t.Errorf("recipient unsubscribe link = %q", link)
+	}
+	if id, err := outbox.queryService.tokens.Verify(RecipientUnsubscribeTokenPurpose, token); err != nil || id != 1 {
+		t.Errorf("recipient unsubscribe token = %d, %v; want recipient 1", id, err)
+	}
+	if _, err := outbox.queryService.tokens.Verify(UnsubscribeTokenPurpose, token); err == nil {
+		t.Error("recipient unsubscribe token stops the whole submission")
+	}
+
+	// The digest is queued once per day
+	outbox.Dispatch(context.Background())
+	outbox.lastDigest = ""
+	outbox.Dispatch(context.Background())
+	if len(events) != 2 {
+		t.Errorf("webhook received %d events after further dispatches, want 2", len(events))
+	}
+}
+
+func TestOutboxCancelsNotificationsOfStoppedUsers(t *testing.T) {
+	db := newTestDB(t)
+	userRepo := repo.NewUserRepo(db)
+	user := &model.User{Email: "a@example.com", InfoHash: "h1"}
+	if err := userRepo.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userRepo.SaveResult(user, &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "400"}); err != nil {
+		t.Fatal(err)
+	}
+	// Stopped without Stop canceling the queue, e.g. while a dispatch was running
+	db.Model(&model.User{}).Where("id = ?", user.ID).Update("status", model.QueryStatusStopped)
+
+	outbox := newTestOutbox(t, db, &config.Config{NotifyMaxAttempts: 3})
+	sent := 0
+	outbox.queryService.notifiers.Register(ChannelEmail, func(settings map[string]string) (notify.Notifier, error) {
+		return notifierFunc(func(ctx context.Context, msg notify.Message) error {
+			sent++
+			return nil
+		}), nil
+	})
+	outbox.Dispatch(context.Background())
+
+	n, _ := outbox.repo.FindByID(1)
+	if sent != 0 || n.Status != model.NotificationCanceled {
+		t.Errorf("sent %d messages, notification status %q; want none and canceled", sent, n.Status)
+	}
+}
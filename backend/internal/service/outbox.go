@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/mail"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
+)
+
+// outboxBatchSize limits the notifications attempted per dispatch round
+const outboxBatchSize = 100
+
+// Outbox delivers the notifications queued by the scheduler. Failed
+// deliveries are retried with backoff and dead-lettered once the retry budget
+// is used up; a channel outage never causes CHSI to be queried again.
+type Outbox struct {
+	repo         *repo.NotificationRepo
+	userRepo     *repo.UserRepo
+	queryService *QueryService
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	// digestHour is the local hour after which daily digests are sent, a
+	// negative hour disables them; lastDigest is the last day queued
+	digestHour int
+	lastDigest string
+	wake       chan struct{}
+}
+
+func NewOutbox(db *gorm.DB, cfg *config.Config, queryService *QueryService) *Outbox {
+	return &Outbox{
+		repo:         repo.NewNotificationRepo(db),
+		userRepo:     repo.NewUserRepo(db),
+		queryService: queryService,
+		maxAttempts:  cfg.NotifyMaxAttempts,
+		backoffBase:  time.Duration(cfg.NotifyBackoffBase) * time.Second,
+		backoffMax:   time.Duration(cfg.NotifyBackoffMax) * time.Second,
+		digestHour:   cfg.DigestHour,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake is signalled when new notifications have been queued
+func (o *Outbox) Wake() <-chan struct{} {
+	return o.wake
+}
+
+// Signal tells the dispatcher that notifications are waiting
+func (o *Outbox) Signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatch queues the daily digests once they are due and attempts all
+// notifications that are due
+func (o *Outbox) Dispatch(ctx context.Context) {
+	o.queueDigests(time.Now())
+	notifications, err := o.repo.FindDue(time.Now(), outboxBatchSize)
+	if err != nil {
+		return
+	}
+	for i := range notifications {
+		if ctx.Err() != nil {
+			return
+		}
+		o.deliver(ctx, &notifications[i])
+	}
+}
+
+// queueDigests queues today's digest for every submission that asked for
+// one, once per day after DIGEST_HOUR. The idempotency key of each digest
+// keeps a restart from sending it twice.
+func (o *Outbox) queueDigests(now time.Time) {
+	if o.digestHour < 0 || now.Hour() < o.digestHour {
+		return
+	}
+	day := now.Format("2006-01-02")
+	if o.lastDigest == day {
+		return
+	}
+
+	users, err := o.userRepo.FindDigestCandidates()
+	if err != nil {
+		return
+	}
+	for i := range users {
+		if err := o.repo.Enqueue(model.NewDigestNotification(&users[i], now)); err != nil {
+			return
+		}
+	}
+	o.lastDigest = day
+	if len(users) > 0 {
+		logger.Info("📬 Queued daily digest for %d users", len(users))
+	}
+}
+
+// deliver makes one attempt and records its outcome
+func (o *Outbox) deliver(ctx context.Context, n *model.Notification) {
+	user, err := o.userRepo.FindByID(n.UserID)
+	if err != nil {
+		return
+	}
+	if user == nil {
+		n.Status = model.NotificationDead
+		n.NextAttemptAt = nil
+		n.LastError = "user no longer exists"
+		o.repo.Update(n)
+		return
+	}
+	if user.Status == model.QueryStatusStopped {
+		// 用户已退订或暂停，排队中的通知不再发送
+		n.Status = model.NotificationCanceled
+		n.NextAttemptAt = nil
+		o.repo.Update(n)
+		return
+	}
+
+	err = o.send(ctx, user, n)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, keep the channels that succeeded and try
+		// the rest later without using an attempt
+		o.repo.Update(n)
+		return
+	}
+
+	n.Attempts++
+	if err == nil {
+		now := time.Now()
+		n.Status = model.NotificationSent
+		n.SentAt = &now
+		n.NextAttemptAt = nil
+		n.LastError = ""
+		logger.Info("Successfully sent %s notification to user: %s", n.Event, user.Email)
+	} else {
+		n.LastError = err.Error()
+		if o.maxAttempts > 0 && n.Attempts >= o.maxAttempts {
+			n.Status = model.NotificationDead
+			n.NextAttemptAt = nil
+			logger.Error("Notification %d (%s) to user %s dead-lettered after %d attempts: %v", n.ID, n.Event, user.Email, n.Attempts, err)
+		} else {
+			next := time.Now().Add(retryDelay(n.Attempts, o.backoffBase, o.backoffMax))
+			n.NextAttemptAt = &next
+			logger.Warn("Notification %d (%s) to user %s failed (attempt %d), retrying at %s: %v",
+				n.ID, n.Event, user.Email, n.Attempts, next.Format("2006-01-02 15:04:05"), err)
+		}
+	}
+	o.repo.Update(n)
+}
+
+// send renders the notification in the user's locale and sends it to the
+// channels that have not received it yet
+func (o *Outbox) send(ctx context.Context, user *model.User, n *model.Notification) error {
+	if n.Result == nil && n.Event != model.NotificationEventFailed && n.Event != model.NotificationEventDigest {
+		return errors.New("notification has no result")
+	}
+
+	var event, kind string
+	var data interface{}
+	switch n.Event {
+	case model.NotificationEventScore:
+		event, kind = notify.EventScore, mail.KindScore
+		data = mail.ScoreData{Name: user.Name, Result: n.Result}
+	case model.NotificationEventInfoMismatch:
+		event, kind = notify.EventInfoMismatch, mail.KindInfoMismatch
+		data = mail.InfoMismatchData{Name: user.Name, Message: n.Result.Message}
+	case model.NotificationEventFailed:
+		event, kind = notify.EventFailed, mail.KindError
+		data = mail.ErrorData{Name: user.Name, Reason: n.Reason, Attempts: n.QueryAttempts}
+	case model.NotificationEventStatusChange:
+		event, kind = notify.EventStatusChange, mail.KindStatusChange
+		data = mail.StatusChangeData{
+			Name:     user.Name,
+			Previous: mail.StatusLabel(user.Locale, n.PreviousStatus),
+			Status:   mail.StatusLabel(user.Locale, n.Result.Status),
+			Message:  n.Result.Message,
+		}
+	case model.NotificationEventDigest:
+		event, kind = notify.EventDigest, mail.KindDigest
+		data = mail.DigestData{
+			Name:        user.Name,
+			Status:      mail.StatusLabel(user.Locale, user.Status),
+			LastQueryAt: user.LastQueryAt,
+			SubmittedAt: user.CreatedAt,
+		}
+	default:
+		return fmt.Errorf("unknown notification event %q", n.Event)
+	}
+	msg, err := o.queryService.message(user, event, kind, data)
+	if err != nil {
+		return err
+	}
+	return o.queryService.deliver(ctx, user, msg, n)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+)
+
+// runPurge enforces the data retention policy: unverified submissions are
+// removed after the verification TTL, and personal data of delivered, failed
+// or deleted submissions is anonymized or deleted after the retention period.
+// Query history older than QUERY_HISTORY_RETENTION is removed as well.
+func (s *Scheduler) runPurge() {
+	s.purgeUnverified()
+	s.purgeDelivered()
+	s.purgeQueryHistory()
+}
+
+// purgeQueryHistory removes query attempts past the history retention
+func (s *Scheduler) purgeQueryHistory() {
+	if s.historyRetention <= 0 {
+		return
+	}
+	count, err := s.attempts.DeleteBefore(time.Now().Add(-s.historyRetention))
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		logger.Info("Purged %d query attempt(s) older than %v", count, s.historyRetention)
+	}
+}
+
+// purgeUnverified removes submissions whose email was never verified
+func (s *Scheduler) purgeUnverified() {
+	if s.unverifiedTTL <= 0 {
+		return
+	}
+	count, err := s.userRepo.PurgeUnverified(time.Now().Add(-s.unverifiedTTL))
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		logger.Info("Purged %d unverified submission(s) older than %v", count, s.unverifiedTTL)
+	}
+}
+
+// purgeDelivered removes personal data once it is no longer needed
+func (s *Scheduler) purgeDelivered() {
+	if s.retention < 0 {
+		return
+	}
+	users, err := s.userRepo.FindPurgeCandidates(time.Now().Add(-s.retention))
+	if err != nil {
+		return
+	}
+
+	purged := 0
+	for _, user := range users {
+		reason := model.PurgeReasonDelivered
+		switch {
+		case user.DeletedAt.Valid:
+			reason = model.PurgeReasonDeleted
+		case user.Status == model.QueryStatusFailed:
+			reason = model.PurgeReasonFailed
+		case user.Status == model.QueryStatusRejected:
+			reason = model.PurgeReasonRejected
+		}
+
+		if err := s.userRepo.Purge(&user, s.purgeMode, reason); err != nil {
+			continue
+		}
+		purged++
+		logger.Info("Purged personal data of user %d (mode: %s, reason: %s)", user.ID, s.purgeMode, reason)
+	}
+
+	if purged > 0 {
+		logger.Info("Purged personal data of %d user(s)", purged)
+	}
+}
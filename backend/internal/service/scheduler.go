@@ -1,78 +1,269 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
 	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/internal/secure"
 	"chsi-auto-score-query/pkg/config"
 
 	"gorm.io/gorm"
 )
 
 type Scheduler struct {
-	db           *gorm.DB
-	userRepo     *repo.UserRepo
-	queryService *QueryService
-	interval     time.Duration
-	stopChan     chan struct{}
-	isRunning    bool
+	db            *gorm.DB
+	userRepo      *repo.UserRepo
+	queryService  *QueryService
+	webhooks      *WebhookService
+	outbox        *Outbox
+	interval      time.Duration
+	workers       int
+	userTimeout   time.Duration
+	maxAttempts   int
+	backoffBase   time.Duration
+	backoffMax    time.Duration
+	unverifiedTTL time.Duration
+	retention     time.Duration
+	// historyRetention is how long query attempts are kept
+	historyRetention time.Duration
+	attempts         *repo.QueryAttemptRepo
+	purgeMode        string
+	purgeInterval    time.Duration
+	// webhookInterval and outboxInterval are how often due webhook and
+	// notification retries are sent
+	webhookInterval time.Duration
+	outboxInterval  time.Duration
+	// stopChan stops the ticker loop and the dispatch of further users,
+	// cancel aborts queries that are still in flight.
+	stopChan chan struct{}
+	doneChan chan struct{}
+	// triggerChan requests a batch outside the regular interval
+	triggerChan  chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+	isRunning    atomic.Bool
+	batchRunning atomic.Bool
+	// loginMu guards the backoff after failed CHSI logins. The login is
+	// shared by all users, so its failures pause querying as a whole instead
+	// of counting against every user's retry budget.
+	loginMu       sync.Mutex
+	loginFailures int
+	loginRetryAt  time.Time
 }
 
 func NewScheduler(db *gorm.DB, cfg *config.Config) *Scheduler {
+	workers := cfg.QueryWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	purgeInterval := time.Duration(cfg.PurgeInterval) * time.Second
+	if purgeInterval <= 0 {
+		purgeInterval = time.Hour
+	}
+	purgeMode := model.PurgeModeAnonymize
+	if cfg.PIIPurgeMode == model.PurgeModeDelete {
+		purgeMode = model.PurgeModeDelete
+	}
+
+	webhookInterval := time.Duration(cfg.WebhookDispatchInterval) * time.Second
+	if webhookInterval <= 0 {
+		webhookInterval = 30 * time.Second
+	}
+	outboxInterval := time.Duration(cfg.NotifyDispatchInterval) * time.Second
+	if outboxInterval <= 0 {
+		outboxInterval = 30 * time.Second
+	}
+
+	queryService := NewQueryService(db, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Scheduler{
-		db:           db,
-		userRepo:     repo.NewUserRepo(db),
-		queryService: NewQueryService(cfg),
-		interval:     time.Duration(cfg.QueryInterval) * time.Second,
-		stopChan:     make(chan struct{}),
-		isRunning:    false,
+		db:               db,
+		userRepo:         repo.NewUserRepo(db),
+		queryService:     queryService,
+		webhooks:         NewWebhookService(db, cfg),
+		outbox:           NewOutbox(db, cfg, queryService),
+		interval:         time.Duration(cfg.QueryInterval) * time.Second,
+		workers:          workers,
+		userTimeout:      time.Duration(cfg.QueryTimeout) * time.Second,
+		maxAttempts:      cfg.QueryMaxAttempts,
+		backoffBase:      time.Duration(cfg.QueryBackoffBase) * time.Second,
+		backoffMax:       time.Duration(cfg.QueryBackoffMax) * time.Second,
+		unverifiedTTL:    time.Duration(cfg.UnverifiedTTL) * time.Second,
+		retention:        time.Duration(cfg.PIIRetention) * time.Second,
+		historyRetention: time.Duration(cfg.QueryHistoryRetention) * time.Second,
+		attempts:         repo.NewQueryAttemptRepo(db),
+		purgeMode:        purgeMode,
+		purgeInterval:    purgeInterval,
+		webhookInterval:  webhookInterval,
+		outboxInterval:   outboxInterval,
+		stopChan:         make(chan struct{}),
+		doneChan:         make(chan struct{}),
+		triggerChan:      make(chan struct{}, 1),
+		ctx:              ctx,
+		cancel:           cancel,
 	}
 }
 
 // Start begins the background query scheduler
 func (s *Scheduler) Start() {
-	if s.isRunning {
+	if !s.isRunning.CompareAndSwap(false, true) {
 		logger.Warn("Scheduler is already running")
 		return
 	}
 
-	s.isRunning = true
-	logger.Info("Background scheduler started with interval: %v", s.interval)
+	logger.Info("Background scheduler started with interval: %v, workers: %d", s.interval, s.workers)
+
+	webhooksDone := make(chan struct{})
+	go s.runDispatcher(webhooksDone, s.webhookInterval, s.webhooks.Wake(), s.webhooks.Dispatch)
+	outboxDone := make(chan struct{})
+	go s.runDispatcher(outboxDone, s.outboxInterval, s.outbox.Wake(), s.outbox.Dispatch)
 
 	go func() {
+		defer close(s.doneChan)
+		defer func() {
+			<-webhooksDone
+			<-outboxDone
+		}()
+
 		ticker := time.NewTicker(s.interval)
 		defer ticker.Stop()
+		purgeTicker := time.NewTicker(s.purgeInterval)
+		defer purgeTicker.Stop()
 
-		// Run first query immediately
-		s.queryPendingUsers()
+		// Run first purge and query immediately
+		s.runPurge()
+		s.runBatch()
 
 		for {
 			select {
 			case <-ticker.C:
-				s.queryPendingUsers()
+				s.runBatch()
+			case <-s.triggerChan:
+				s.runBatch()
+			case <-purgeTicker.C:
+				s.runPurge()
 			case <-s.stopChan:
 				logger.Info("Background scheduler stopped")
-				s.isRunning = false
 				return
 			}
 		}
 	}()
 }
 
-// Stop stops the background query scheduler
-func (s *Scheduler) Stop() {
-	if !s.isRunning {
+// runDispatcher delivers queued webhook events or notifications as soon as
+// they are queued and retries failed ones on every tick until the scheduler
+// stops.
+func (s *Scheduler) runDispatcher(done chan struct{}, interval time.Duration, wake <-chan struct{}, dispatch func(context.Context)) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Deliver what was left over from before a restart
+	dispatch(s.ctx)
+	for {
+		select {
+		case <-ticker.C:
+		case <-wake:
+		case <-s.stopChan:
+			return
+		}
+		dispatch(s.ctx)
+	}
+}
+
+// Stop stops the background query scheduler. No further users are
+// dispatched and the queries already in flight are allowed to finish until
+// ctx expires; after that they are cancelled and their users keep the state
+// of their last completed query.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if !s.isRunning.CompareAndSwap(true, false) {
 		logger.Warn("Scheduler is not running")
-		return
+		return nil
 	}
 	close(s.stopChan)
+
+	defer s.queryService.Close()
+
+	select {
+	case <-s.doneChan:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		logger.Warn("Scheduler drain timed out, cancelling in-flight queries")
+		s.cancel()
+		<-s.doneChan
+		return ctx.Err()
+	}
 }
 
-// queryPendingUsers queries scores for all pending users
-func (s *Scheduler) queryPendingUsers() {
+// Trigger asks the scheduler to query all pending users now instead of
+// waiting for the next tick. It reports false if the scheduler is not running.
+func (s *Scheduler) Trigger() bool {
+	if !s.isRunning.Load() {
+		return false
+	}
+	select {
+	case s.triggerChan <- struct{}{}:
+	default:
+		// A triggered batch is already waiting
+	}
+	return true
+}
+
+// ErrNotQueryable is returned by QueryUser for users that are not being
+// polled: unverified, purged, paused or finished submissions
+var ErrNotQueryable = errors.New("user is not being queried")
+
+// QueryUser queries one polled user right away, whatever its backoff, and
+// returns the stored outcome. The query runs on the scheduler's context, so
+// it is not cut short by the caller but is cancelled on shutdown. It
+// returns nil if the user does not exist.
+func (s *Scheduler) QueryUser(id uint) (*model.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if user.VerifiedAt == nil || user.PurgedAt != nil || !user.Status.Polling() {
+		return nil, ErrNotQueryable
+	}
+	logger.Info("Forced query for user: %s (%s)", secure.MaskName(user.Name), user.Email)
+	s.processUser(s.ctx, user)
+	return s.userRepo.FindByID(id)
+}
+
+// runBatch runs one batch unless the previous one is still in progress. The
+// batch may run for at most one interval so it never overlaps the next tick;
+// users not reached in time are picked up by the next batch.
+func (s *Scheduler) runBatch() {
+	if !s.batchRunning.CompareAndSwap(false, true) {
+		logger.Warn("Previous score query batch still running, skipping this tick")
+		return
+	}
+	defer s.batchRunning.Store(false)
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.interval)
+	defer cancel()
+	s.queryPendingUsers(ctx)
+}
+
+// queryPendingUsers queries scores for all pending users with a bounded pool of workers
+func (s *Scheduler) queryPendingUsers(ctx context.Context) {
 	logger.Info("=== Starting background score query batch ===")
 
+	if retryAt := s.loginRetryTime(); time.Now().Before(retryAt) {
+		logger.Warn("CHSI login is failing, querying paused until %s", retryAt.Format("2006-01-02 15:04:05"))
+		return
+	}
+
 	// Get all users with pending scores
 	users, err := s.userRepo.FindPending()
 	if err != nil {
@@ -87,36 +278,211 @@ func (s *Scheduler) queryPendingUsers() {
 
 	logger.Info("Found %d pending user(s) to process", len(users))
 
-	successCount := 0
-	failureCount := 0
-
-	// Query score for each user
-	for i, user := range users {
-		logger.Info("[%d/%d] Processing user: %s (%s)", i+1, len(users), user.Name, user.Email)
-
-		// Query and email result
-		if err := s.queryService.QueryAndEmail(&user); err != nil {
-			failureCount++
-			logger.Error("     ❌ Query result: Failed - %v", err)
-			// Update notice field with error message
-			user.Notice = "查询失败：" + err.Error()
-		} else {
-			successCount++
-			// Mark user as queried by setting LastQueryAt
-			user.LastQueryAt = time.Now()
-			if user.Score != "" {
-				logger.Info("     ✅ Query result: Score found and email sent")
-			} else {
-				logger.Info("     ⏳ Query result: Score not yet available or pending")
+	var successCount, failureCount, skippedCount atomic.Int64
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				user := users[i]
+				// The login failed for an earlier user, the rest waits for a later batch
+				if time.Now().Before(s.loginRetryTime()) {
+					skippedCount.Add(1)
+					continue
+				}
+				logger.Info("[%d/%d] Processing user: %s (%s)", i+1, len(users), secure.MaskName(user.Name), user.Email)
+				if s.processUser(ctx, &user) {
+					successCount.Add(1)
+				} else {
+					failureCount.Add(1)
+				}
 			}
+		}()
+	}
+
+dispatch:
+	for i := range users {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			skippedCount.Add(int64(len(users) - i))
+			logger.Warn("Batch deadline reached, %d user(s) left for the next batch", len(users)-i)
+			break dispatch
+		case <-s.stopChan:
+			skippedCount.Add(int64(len(users) - i))
+			logger.Info("Scheduler stopping, waiting for in-flight queries; %d user(s) not started", len(users)-i)
+			break dispatch
 		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	logger.Info("=== Background score query batch completed [Success: %d, Failed: %d, Skipped: %d, Total: %d] ===",
+		successCount.Load(), failureCount.Load(), skippedCount.Load(), len(users))
+}
+
+// processUser queries a single user under its own deadline and persists the
+// outcome. It reports whether the query succeeded.
+func (s *Scheduler) processUser(batchCtx context.Context, user *model.User) bool {
+	ctx := batchCtx
+	if s.userTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.userTimeout)
+		defer cancel()
+	}
+
+	// Query result
+	result, err := s.queryService.Query(ctx, user)
+	if err != nil {
+		logger.Error("     ❌ Query result: Failed - %v", err)
+		s.recordFailure(batchCtx, user, err)
+		return false
+	}
 
-		// Update user record
-		if err := s.userRepo.Update(&user); err != nil {
+	s.recordLogin(true)
+
+	if result == nil {
+		// Mark user as queried by setting LastQueryAt
+		if _, err := s.userRepo.UpdatePolling(user.ID, func(current *model.User) *model.Notification {
+			current.LastQueryAt = time.Now()
+			current.Attempts = 0
+			current.NextAttemptAt = nil
+			return nil
+		}); err != nil {
 			logger.Error("     ⚠️  Failed to update user record: %v", err)
 		}
+		logger.Info("     ⏳ Query result: Score page could not be interpreted")
+		return true
+	}
+
+	// Persist result and queue the notification in one transaction
+	previous := user.Status
+	notify, err := s.userRepo.SaveResult(user, result)
+	if err != nil {
+		logger.Error("     ⚠️  Failed to save query result: %v", err)
+		return false
+	}
+	s.publishResult(user, previous, notify)
+	if notify {
+		s.outbox.Signal()
 	}
 
-	logger.Info("=== Background score query batch completed [Success: %d, Failed: %d, Total: %d] ===",
-		successCount, failureCount, len(users))
+	switch {
+	case user.Status.Released():
+		logger.Info("     ✅ Query result: %s, notified: %v", user.Status, notify)
+	case user.Status == model.QueryStatusInfoMismatch:
+		logger.Info("     🚫 Query result: Information mismatch, polling stopped")
+	case user.Status == model.QueryStatusRejected:
+		logger.Info("     ❎ Query result: Not admitted, polling stopped")
+	default:
+		logger.Info("     ⏳ Query result: Score not yet available or pending")
+	}
+	return true
+}
+
+// recordFailure counts a failed attempt and schedules the next one with
+// exponential backoff. Once the retry budget is used up the user moves to the
+// failed state and one final notification is queued.
+func (s *Scheduler) recordFailure(batchCtx context.Context, user *model.User, err error) {
+	// Attempts cut short by the batch deadline are not the user's fault
+	if batchCtx.Err() != nil && errors.Is(err, batchCtx.Err()) {
+		logger.Warn("     ⏭️  Query interrupted, will retry in the next batch")
+		return
+	}
+	// Login failures hit every user alike and pause the whole batch instead
+	if loginFailure(err) {
+		retryAt := s.recordLogin(false)
+		logger.Warn("     🔐 CHSI login failed, attempt not counted; querying paused until %s", retryAt.Format("2006-01-02 15:04:05"))
+		return
+	}
+
+	var previous model.QueryStatus
+	final := false
+	updated, updateErr := s.userRepo.UpdatePolling(user.ID, func(current *model.User) *model.Notification {
+		// Update notice field with error message
+		previous = current.Status
+		current.Notice = "查询失败：" + err.Error()
+		current.LastQueryAt = time.Now()
+		current.Attempts++
+
+		final = s.maxAttempts > 0 && current.Attempts >= s.maxAttempts
+		if !final {
+			next := time.Now().Add(retryDelay(current.Attempts, s.backoffBase, s.backoffMax))
+			current.NextAttemptAt = &next
+			return nil
+		}
+		// Persist the failed state and queue the final notification together
+		current.Status = model.QueryStatusFailed
+		current.NextAttemptAt = nil
+		return model.NewFailedNotification(current, failureNotice(err))
+	})
+	if updateErr != nil {
+		logger.Error("     ⚠️  Failed to update user record: %v", updateErr)
+		return
+	}
+	if updated == nil {
+		logger.Info("     ⏭️  User changed during the query, result discarded")
+		return
+	}
+	*user = *updated
+
+	if !final {
+		logger.Info("     🔁 Attempt %d/%d failed, next attempt at %s", user.Attempts, s.maxAttempts, user.NextAttemptAt.Format("2006-01-02 15:04:05"))
+		return
+	}
+	logger.Warn("     🛑 Giving up on user %s after %d attempts", user.Email, user.Attempts)
+	s.publish(user, model.WebhookEventQueryFailed, previous)
+	s.outbox.Signal()
+}
+
+// recordLogin tracks whether the shared CHSI login works. Each failure in a
+// row pauses querying for an exponentially growing delay, a completed query
+// ends the pause. It returns the end of the pause.
+func (s *Scheduler) recordLogin(ok bool) time.Time {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if ok {
+		s.loginFailures = 0
+		s.loginRetryAt = time.Time{}
+		return s.loginRetryAt
+	}
+	// Queries that were already running when the pause began fail alike
+	if time.Now().Before(s.loginRetryAt) {
+		return s.loginRetryAt
+	}
+	s.loginFailures++
+	s.loginRetryAt = time.Now().Add(retryDelay(s.loginFailures, s.backoffBase, s.backoffMax))
+	return s.loginRetryAt
+}
+
+// loginRetryTime returns when querying may resume after failed logins
+func (s *Scheduler) loginRetryTime() time.Time {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	return s.loginRetryAt
+}
+
+// publishResult queues the webhook events for a persisted result
+func (s *Scheduler) publishResult(user *model.User, previous model.QueryStatus, notified bool) {
+	if previous == "" {
+		previous = model.QueryStatusPending
+	}
+	if user.Status != previous {
+		s.publish(user, model.WebhookEventStatusChanged, previous)
+	}
+	if notified && user.Status.Released() {
+		s.publish(user, model.WebhookEventScoreReleased, previous)
+	}
+}
+
+func (s *Scheduler) publish(user *model.User, event string, previous model.QueryStatus) {
+	if previous == "" {
+		previous = model.QueryStatusPending
+	}
+	if err := s.webhooks.Publish(user, event, previous); err != nil {
+		logger.Error("     ⚠️  Failed to queue %s webhook: %v", event, err)
+	}
 }
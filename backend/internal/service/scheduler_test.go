@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+)
+
+func TestLoginFailurePausesQuerying(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.Config{QueryInterval: 60, QueryWorkers: 1, QueryMaxAttempts: 1, QueryBackoffBase: 60, QueryBackoffMax: 3600}
+	s := NewScheduler(db, cfg)
+	// The CHSI account password was changed, every login fails
+	client := newTestChsiClient(t, &fakeCAS{username: "user", password: "changed"})
+	s.queryService.chsiClient = client
+	s.queryService.sessions = NewSessionManager(client, repo.NewSessionRepo(db), cfg)
+
+	now := time.Now()
+	userRepo := repo.NewUserRepo(db)
+	for _, u := range []*model.User{
+		{Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now},
+		{Name: "李四", Email: "b@example.com", InfoHash: "h2", VerifiedAt: &now},
+	} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.queryPendingUsers(context.Background())
+	s.queryPendingUsers(context.Background())
+
+	// One login was tried, then the rest of the batch and the next one paused
+	var attempts, notifications int64
+	db.Model(&model.QueryAttempt{}).Count(&attempts)
+	if attempts != 1 {
+		t.Errorf("%d queries made, want 1 before pausing", attempts)
+	}
+	if retryAt := s.loginRetryTime(); !retryAt.After(now) {
+		t.Errorf("querying not paused, retry at %v", retryAt)
+	}
+	users, _ := userRepo.FindPending()
+	if len(users) != 2 {
+		t.Fatalf("%d users still polling, want 2", len(users))
+	}
+	for _, u := range users {
+		if u.Attempts != 0 || u.NextAttemptAt != nil {
+			t.Errorf("user %d charged for the login failure: %d attempts, next at %v", u.ID, u.Attempts, u.NextAttemptAt)
+		}
+	}
+	db.Model(&model.Notification{}).Count(&notifications)
+	if notifications != 0 {
+		t.Errorf("%d failure notifications queued for a login failure", notifications)
+	}
+
+	// Any completed query ends the pause
+	s.recordLogin(true)
+	if !s.loginRetryTime().IsZero() {
+		t.Error("pause not lifted")
+	}
+}
+
+func TestQueryUser(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.Config{QueryMaxAttempts: 3, QueryBackoffBase: 60, QueryBackoffMax: 3600}
+	s := NewScheduler(db, cfg)
+	client := newTestChsiClient(t, &fakeCAS{username: "user", password: "secret"})
+	s.queryService.chsiClient = client
+	s.queryService.sessions = NewSessionManager(client, repo.NewSessionRepo(db), cfg)
+
+	now := time.Now()
+	userRepo := repo.NewUserRepo(db)
+	polling := &model.User{Name: "张三", Email: "a@example.com", InfoHash: "h1", VerifiedAt: &now, Attempts: 1, NextAttemptAt: &now}
+	unverified := &model.User{Email: "b@example.com", InfoHash: "h2"}
+	purged := &model.User{Email: "c@example.com", InfoHash: "h3", VerifiedAt: &now, PurgedAt: &now}
+	stopped := &model.User{Email: "d@example.com", InfoHash: "h4", VerifiedAt: &now, Status: model.QueryStatusStopped}
+	failed := &model.User{Email: "e@example.com", InfoHash: "h5", VerifiedAt: &now, Status: model.QueryStatusFailed, Attempts: 3}
+	for _, u := range []*model.User{polling, unverified, purged, stopped, failed} {
+		if err := userRepo.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, u := range []*model.User{unverified, purged, stopped, failed} {
+		if queried, err := s.QueryUser(u.ID); !errors.Is(err, ErrNotQueryable) || queried != nil {
+			t.Errorf("QueryUser(%s) = %v, %v; want ErrNotQueryable", u.Email, queried, err)
+		}
+	}
+	if missing, err := s.QueryUser(999); missing != nil || err != nil {
+		t.Errorf("QueryUser(unknown) = %v, %v", missing, err)
+	}
+	var count int64
+	db.Model(&model.QueryAttempt{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d queries made for users that are not polled", count)
+	}
+
+	// The fake CHSI has no score page, so the forced query fails and counts
+	queried, err := s.QueryUser(polling.ID)
+	if err != nil || queried == nil {
+		t.Fatalf("QueryUser() = %v, %v", queried, err)
+	}
+	if queried.Attempts != 2 || queried.Status != model.QueryStatusPending || queried.LastQueryAt.IsZero() {
+		t.Errorf("queried user = %q after %d attempts, last query %v", queried.Status, queried.Attempts, queried.LastQueryAt)
+	}
+	db.Model(&model.QueryAttempt{}).Where("user_id = ?", polling.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("%d queries recorded, want 1", count)
+	}
+	db.Model(&model.Notification{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d notifications queued", count)
+	}
+}
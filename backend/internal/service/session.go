@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+)
+
+// SessionManager keeps one CHSI login alive across scheduler ticks and
+// restarts. Cookies are persisted in the database and only replaced when a
+// probe or a query shows the session has expired.
+type SessionManager struct {
+	client        *ChsiClient
+	sessionRepo   *repo.SessionRepo
+	probeInterval time.Duration
+
+	mu          sync.Mutex
+	restored    bool
+	expired     bool
+	validatedAt time.Time
+	loggedInAt  time.Time
+	// refresh is the probe or login in progress, nil when there is none
+	refresh *sessionRefresh
+
+	// saveMu serializes writes of the cookies, saved is the last one written
+	saveMu sync.Mutex
+	saved  string
+}
+
+// sessionRefresh is a probe or login shared by every caller of Ensure that
+// arrives while it runs
+type sessionRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+func NewSessionManager(client *ChsiClient, sessionRepo *repo.SessionRepo, cfg *config.Config) *SessionManager {
+	return &SessionManager{
+		client:        client,
+		sessionRepo:   sessionRepo,
+		probeInterval: time.Duration(cfg.SessionProbeInterval) * time.Second,
+	}
+}
+
+// Ensure makes sure the client holds a valid session. It restores persisted
+// cookies on first use, probes them at most once per probe interval and logs
+// in again only when the session turns out to be invalid. Only one probe or
+// login runs at a time, concurrent callers wait for its outcome.
+func (m *SessionManager) Ensure(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		if !m.restored {
+			m.restore()
+			m.restored = true
+		}
+		if !m.expired && !m.validatedAt.IsZero() && time.Since(m.validatedAt) < m.probeInterval {
+			m.mu.Unlock()
+			return nil
+		}
+
+		if refresh := m.refresh; refresh != nil {
+			m.mu.Unlock()
+			select {
+			case <-refresh.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// 上一次登录因调用方超时中断时自行重试，其他错误直接共享
+			if refresh.err != nil && !errors.Is(refresh.err, context.Canceled) && !errors.Is(refresh.err, context.DeadlineExceeded) {
+				return refresh.err
+			}
+			continue
+		}
+
+		refresh := &sessionRefresh{done: make(chan struct{})}
+		m.refresh = refresh
+		expired := m.expired
+		m.mu.Unlock()
+
+		// 网络请求不持有锁，其他调用方等待本次结果
+		refresh.err = m.revalidate(ctx, expired)
+
+		m.mu.Lock()
+		m.refresh = nil
+		m.mu.Unlock()
+		close(refresh.done)
+		return refresh.err
+	}
+}
+
+// revalidate probes the session unless it is known to have expired and logs
+// in again when it is no longer valid
+func (m *SessionManager) revalidate(ctx context.Context, expired bool) error {
+	if !expired {
+		ok, err := m.client.CheckSession(ctx)
+		if err != nil {
+			return err
+		}
+		if ok {
+			logger.Debug("CHSI session is still valid")
+			m.mu.Lock()
+			m.validatedAt = time.Now()
+			m.mu.Unlock()
+			return nil
+		}
+		logger.Info("CHSI session expired, logging in again")
+	}
+
+	if err := m.client.Login(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	m.expired = false
+	m.validatedAt = now
+	m.loggedInAt = now
+	m.mu.Unlock()
+	m.Persist()
+	return nil
+}
+
+// Invalidate marks the session as expired, e.g. after a query was redirected
+// back to the login page. The next Ensure logs in without probing.
+func (m *SessionManager) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expired = true
+	m.validatedAt = time.Time{}
+}
+
+// restore loads persisted cookies into the client
+func (m *SessionManager) restore() {
+	session, err := m.sessionRepo.FindByAccount(m.client.username)
+	if err != nil || session == nil {
+		return
+	}
+	if err := m.client.ImportCookies(session.Cookies); err != nil {
+		logger.Warn("Failed to restore CHSI session cookies: %v", err)
+		return
+	}
+	m.loggedInAt = session.LoggedInAt
+	logger.Info("Restored CHSI session from %s", session.LoggedInAt.Format("2006-01-02 15:04:05"))
+}
+
+// Persist saves the current cookies of the client. CHSI rotates cookies
+// on ordinary requests too, so it is called after every successful query;
+// unchanged cookies are not written again.
+func (m *SessionManager) Persist() {
+	cookies, err := m.client.ExportCookies()
+	if err != nil {
+		logger.Warn("Failed to export CHSI session cookies: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	loggedInAt := m.loggedInAt
+	m.mu.Unlock()
+
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if cookies == m.saved {
+		return
+	}
+
+	session, err := m.sessionRepo.FindByAccount(m.client.username)
+	if err != nil {
+		return
+	}
+	if session == nil {
+		session = &model.ChsiSession{Account: m.client.username}
+	}
+	session.Cookies = cookies
+	session.LoggedInAt = loggedInAt
+
+	if err := m.sessionRepo.Save(session); err != nil {
+		logger.Warn("Failed to persist CHSI session: %v", err)
+		return
+	}
+	m.saved = cookies
+}
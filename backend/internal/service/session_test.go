@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	database "chsi-auto-score-query/internal/db"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	if err := db.AutoMigrate(database.Models()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestSessionManagerReusesPersistedSession(t *testing.T) {
+	db := newTestDB(t)
+	sessionRepo := repo.NewSessionRepo(db)
+	cfg := &config.Config{SessionProbeInterval: 300}
+	cas := &fakeCAS{username: "user", password: "secret"}
+
+	first := newTestChsiClient(t, cas)
+	m := NewSessionManager(first, sessionRepo, cfg)
+	if err := m.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if cas.posted == nil {
+		t.Fatalf("Ensure() did not log in")
+	}
+
+	// Within the probe interval no request is needed at all
+	cas.posted = nil
+	if err := m.Ensure(context.Background()); err != nil || cas.posted != nil {
+		t.Fatalf("second Ensure() = %v, posted = %v", err, cas.posted)
+	}
+
+	// A fresh client (restart) restores the cookies instead of logging in
+	second := NewChsiClient(&config.Config{ChsiUsername: "user", ChsiPassword: "secret"})
+	second.passportBaseURL = first.passportBaseURL
+	second.yzBaseURL = first.yzBaseURL
+	restarted := NewSessionManager(second, sessionRepo, cfg)
+	if err := restarted.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure() after restart error = %v", err)
+	}
+	if cas.posted != nil {
+		t.Errorf("Ensure() after restart logged in again")
+	}
+
+	// An invalidated session logs in again without probing
+	restarted.Invalidate()
+	if err := restarted.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure() after Invalidate error = %v", err)
+	}
+}
+
+func TestSessionManagerSharesOneLogin(t *testing.T) {
+	cas := &fakeCAS{username: "user", password: "secret", loginGate: make(chan struct{})}
+	m := NewSessionManager(newTestChsiClient(t, cas), repo.NewSessionRepo(newTestDB(t)), &config.Config{SessionProbeInterval: 300})
+	m.Invalidate()
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- m.Ensure(context.Background()) }()
+	}
+	for deadline := time.Now().Add(time.Second); cas.logins.Load() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Ensure() did not log in")
+		}
+	}
+
+	// The login runs without the lock, so the manager stays usable meanwhile
+	invalidated := make(chan struct{})
+	go func() {
+		m.Invalidate()
+		close(invalidated)
+	}()
+	select {
+	case <-invalidated:
+	case <-time.After(time.Second):
+		t.Fatalf("Invalidate() blocked behind the login")
+	}
+
+	close(cas.loginGate)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Ensure() error = %v", err)
+		}
+	}
+	if logins := cas.logins.Load(); logins != 1 {
+		t.Errorf("%d logins for concurrent Ensure() calls, want 1", logins)
+	}
+}
+
+func TestSessionManagerPersistsRotatedCookies(t *testing.T) {
+	sessionRepo := repo.NewSessionRepo(newTestDB(t))
+	client := newTestChsiClient(t, &fakeCAS{username: "user", password: "secret"})
+	m := NewSessionManager(client, sessionRepo, &config.Config{SessionProbeInterval: 300})
+	if err := m.Ensure(context.Background()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	stored, _ := sessionRepo.FindByAccount("user")
+	if stored == nil {
+		t.Fatalf("session not persisted after login")
+	}
+	loggedInAt := stored.LoggedInAt
+
+	// A query response replaced the session cookie
+	yz, _ := url.Parse(client.yzBaseURL + "/apply/cjcx/")
+	client.jar.SetCookies(yz, []*http.Cookie{{Name: "JSESSIONID", Value: "rotated", Path: "/"}})
+	m.Persist()
+
+	stored, _ = sessionRepo.FindByAccount("user")
+	if stored == nil || !strings.Contains(stored.Cookies, "rotated") {
+		t.Fatalf("rotated cookie not persisted: %+v", stored)
+	}
+	if !stored.LoggedInAt.Equal(loggedInAt) {
+		t.Errorf("LoggedInAt = %v, want the login time %v", stored.LoggedInAt, loggedInAt)
+	}
+}
+
+func TestExportCookiesKeepsAttributes(t *testing.T) {
+	first := NewChsiClient(&config.Config{})
+	login, _ := url.Parse("https://account.chsi.com.cn/passport/login")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	first.jar.SetCookies(login, []*http.Cookie{
+		{Name: "CASTGC", Value: "TGT-1", Domain: ".chsi.com.cn", Path: "/", Expires: expires, Secure: true, HttpOnly: true},
+		{Name: "JSESSIONID", Value: "passport-session"},
+		{Name: "stale", Value: "1", Path: "/"},
+	})
+	first.jar.SetCookies(login, []*http.Cookie{{Name: "stale", Path: "/", MaxAge: -1}})
+
+	data, err := first.ExportCookies()
+	if err != nil {
+		t.Fatalf("ExportCookies() error = %v", err)
+	}
+	second := NewChsiClient(&config.Config{})
+	if err := second.ImportCookies(data); err != nil {
+		t.Fatalf("ImportCookies() error = %v", err)
+	}
+
+	saved := map[string]savedCookie{}
+	for _, sc := range second.jar.Saved() {
+		saved[sc.Name] = sc
+	}
+	if len(saved) != 2 {
+		t.Fatalf("restored cookies = %v, want CASTGC and JSESSIONID", saved)
+	}
+	castgc := saved["CASTGC"]
+	if castgc.Domain != "chsi.com.cn" || castgc.HostOnly || castgc.Path != "/" || !castgc.Expires.Equal(expires) ||
+		!castgc.Secure || !castgc.HttpOnly {
+		t.Errorf("restored CASTGC = %+v", castgc)
+	}
+	if session := saved["JSESSIONID"]; session.Domain != "account.chsi.com.cn" || !session.HostOnly || session.Path != "/passport" {
+		t.Errorf("restored JSESSIONID = %+v", session)
+	}
+
+	// The domain cookie is sent to other CHSI hosts again, but only over HTTPS
+	yz, _ := url.Parse("https://yz.chsi.com.cn/apply/cjcx/")
+	if cookies := second.jar.Cookies(yz); len(cookies) != 1 || cookies[0].Value != "TGT-1" {
+		t.Errorf("cookies for %s = %v", yz, cookies)
+	}
+	yz.Scheme = "http"
+	if cookies := second.jar.Cookies(yz); len(cookies) != 0 {
+		t.Errorf("secure cookie sent over http: %v", cookies)
+	}
+}
+
+func TestImportCookiesSkipsExpired(t *testing.T) {
+	client := NewChsiClient(&config.Config{})
+	err := client.ImportCookies(`[{"name":"CASTGC","value":"TGT-1","domain":"chsi.com.cn","path":"/","expires":"2020-01-01T00:00:00Z"}]`)
+	if err != nil {
+		t.Fatalf("ImportCookies() error = %v", err)
+	}
+	if saved := client.jar.Saved(); len(saved) != 0 {
+		t.Errorf("expired cookies restored: %v", saved)
+	}
+}
+
+func TestImportLegacyCookies(t *testing.T) {
+	client := NewChsiClient(&config.Config{})
+	legacy := `{"https://yz.chsi.com.cn/":[{"name":"JSESSIONID","value":"yz-session"}]}`
+	if err := client.ImportCookies(legacy); err != nil {
+		t.Fatalf("ImportCookies() error = %v", err)
+	}
+	u, _ := url.Parse("https://yz.chsi.com.cn/apply/cjcx/")
+	if cookies := client.jar.Cookies(u); len(cookies) != 1 || cookies[0].Value != "yz-session" {
+		t.Errorf("legacy cookies for %s = %v", u, cookies)
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer := NewTokenSigner("secret")
+
+	token := signer.Sign("verify", 42, time.Hour)
+	if id, err := signer.Verify("verify", token); err != nil || id != 42 {
+		t.Fatalf("Verify() = %d, %v; want 42, nil", id, err)
+	}
+
+	if _, err := signer.Verify("manage", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with other purpose error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := NewTokenSigner("other").Verify("verify", token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with other secret error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := signer.Verify("verify", token+"x"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with tampered token error = %v, want ErrInvalidToken", err)
+	}
+
+	expired := signer.Sign("verify", 42, -time.Minute)
+	if _, err := signer.Verify("verify", expired); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Verify() with expired token error = %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestManageToken(t *testing.T) {
+	token, hash := NewManageToken()
+	other, _ := NewManageToken()
+	if token == other || strings.Contains(token, ".") {
+		t.Errorf("NewManageToken() = %q, %q", token, other)
+	}
+	if HashManageToken(token) != hash || hash == token {
+		t.Errorf("HashManageToken() does not match the returned hash")
+	}
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chsi-auto-score-query/internal/logger"
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+	"gorm.io/gorm"
+)
+
+// Headers sent with every webhook delivery. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookBatchSize limits the deliveries attempted per dispatch round
+const webhookBatchSize = 100
+
+// WebhookEvent is the JSON body of a webhook delivery
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEventData `json:"data"`
+}
+
+// WebhookEventData describes the user's state. Personal data such as the
+// name, ID card or exam ID is never included; receivers identify submissions
+// by user_id.
+type WebhookEventData struct {
+	UserID         uint               `json:"user_id"`
+	Status         model.QueryStatus  `json:"status"`
+	PreviousStatus model.QueryStatus  `json:"previous_status,omitempty"`
+	Score          *model.ScoreResult `json:"score,omitempty"`
+	Notice         string             `json:"notice,omitempty"`
+	Attempts       int                `json:"attempts,omitempty"`
+}
+
+// WebhookService records state change events and delivers them to the
+// global and per-submission webhook URLs with retries.
+type WebhookService struct {
+	repo *repo.WebhookRepo
+	// client posts to the global URLs set by the operator, endpointClient
+	// to the URLs registered by submitters, which must be public
+	client         *http.Client
+	endpointClient *http.Client
+	globalURLs     []string
+	secret         string
+	maxAttempts    int
+	backoffBase    time.Duration
+	backoffMax     time.Duration
+	wake           chan struct{}
+}
+
+func NewWebhookService(db *gorm.DB, cfg *config.Config) *WebhookService {
+	var urls []string
+	for _, u := range strings.Split(cfg.WebhookURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	secret := cfg.WebhookSecret
+	if secret == "" {
+		secret = cfg.AppSecret
+	}
+	return &WebhookService{
+		repo:           repo.NewWebhookRepo(db),
+		client:         &http.Client{Timeout: 15 * time.Second},
+		endpointClient: notify.NewHTTPClient(15 * time.Second),
+		globalURLs:     urls,
+		secret:         secret,
+		maxAttempts:    cfg.WebhookMaxAttempts,
+		backoffBase:    time.Duration(cfg.WebhookBackoffBase) * time.Second,
+		backoffMax:     time.Duration(cfg.WebhookBackoffMax) * time.Second,
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// NewWebhookSecret returns a random signing secret for a webhook endpoint
+func NewWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// SignWebhook computes the signature header value for a delivery
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Wake is signalled when new deliveries have been queued
+func (s *WebhookService) Wake() <-chan struct{} {
+	return s.wake
+}
+
+// Publish queues an event about the user's current state for every webhook
+// URL that applies to the user.
+func (s *WebhookService) Publish(user *model.User, eventType string, previous model.QueryStatus) error {
+	endpoints, err := s.repo.FindEndpoints(user.ID)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 && len(s.globalURLs) == 0 {
+		return nil
+	}
+
+	event := WebhookEvent{
+		ID:        newEventID(),
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data: WebhookEventData{
+			UserID:         user.ID,
+			Status:         user.Status,
+			PreviousStatus: previous,
+			Notice:         user.Notice,
+			Attempts:       user.Attempts,
+		},
+	}
+	if user.Score != nil {
+		// The name, exam ID and raw CHSI JSON identify the candidate
+		score := *user.Score
+		score.CandidateName = ""
+		score.ExamID = ""
+		score.Raw = ""
+		event.Data.Score = &score
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	newDelivery := func(endpointID uint, url string) model.WebhookDelivery {
+		return model.WebhookDelivery{
+			EventID:    event.ID,
+			Event:      eventType,
+			UserID:     user.ID,
+			EndpointID: endpointID,
+			URL:        url,
+			Payload:    string(payload),
+			Status:     model.DeliveryPending,
+		}
+	}
+	var deliveries []model.WebhookDelivery
+	if s.secret != "" {
+		for _, url := range s.globalURLs {
+			deliveries = append(deliveries, newDelivery(0, url))
+		}
+	} else if len(s.globalURLs) > 0 {
+		logger.Warn("WEBHOOK_SECRET and APP_SECRET are empty, not sending unsigned webhooks")
+	}
+	for _, endpoint := range endpoints {
+		// 早期注册、没有签名密钥的地址不再投递
+		if endpoint.Secret != "" {
+			deliveries = append(deliveries, newDelivery(endpoint.ID, endpoint.URL))
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := s.repo.CreateDeliveries(deliveries); err != nil {
+		return err
+	}
+
+	logger.Debug("Queued %s webhook event for user %d to %d URL(s)", eventType, user.ID, len(deliveries))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Dispatch attempts all deliveries that are due
+func (s *WebhookService) Dispatch(ctx context.Context) {
+	deliveries, err := s.repo.FindDueDeliveries(time.Now(), webhookBatchSize)
+	if err != nil {
+		return
+	}
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		s.deliver(ctx, &deliveries[i])
+	}
+}
+
+// Replay sends a logged delivery again right away, whatever its state, and
+// gives it a fresh retry budget. It returns nil if the delivery is unknown.
+func (s *WebhookService) Replay(ctx context.Context, id uint) (*model.WebhookDelivery, error) {
+	delivery, err := s.repo.FindDelivery(id)
+	if err != nil || delivery == nil {
+		return nil, err
+	}
+	delivery.Status = model.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = nil
+	s.deliver(ctx, delivery)
+	return delivery, nil
+}
+
+// ListDeliveries returns the delivery log, see WebhookRepo.ListDeliveries
+func (s *WebhookService) ListDeliveries(userID uint, status string, limit, offset int) ([]model.WebhookDelivery, int64, error) {
+	return s.repo.ListDeliveries(userID, status, limit, offset)
+}
+
+// deliver makes one attempt and records its outcome
+func (s *WebhookService) deliver(ctx context.Context, delivery *model.WebhookDelivery) {
+	code, err := s.post(ctx, delivery)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, try again later without using an attempt
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	if err == nil {
+		now := time.Now()
+		delivery.Status = model.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		delivery.LastError = ""
+		logger.Info("Webhook %s delivered to %s", delivery.Event, delivery.URL)
+	} else {
+		delivery.LastError = err.Error()
+		if s.maxAttempts > 0 && delivery.Attempts >= s.maxAttempts {
+			delivery.Status = model.DeliveryFailed
+			delivery.NextAttemptAt = nil
+			logger.Error("Webhook %s to %s failed after %d attempts: %v", delivery.Event, delivery.URL, delivery.Attempts, err)
+		} else {
+			next := time.Now().Add(retryDelay(delivery.Attempts, s.backoffBase, s.backoffMax))
+			delivery.NextAttemptAt = &next
+			logger.Warn("Webhook %s to %s failed (attempt %d), retrying at %s: %v",
+				delivery.Event, delivery.URL, delivery.Attempts, next.Format("2006-01-02 15:04:05"), err)
+		}
+	}
+	s.repo.UpdateDelivery(delivery)
+}
+
+// post sends the signed payload and returns the HTTP status code. Global
+// URLs are signed with the operator's secret, registered endpoints with their
+// own, so a submitter never sees a signature made with the operator's key.
+func (s *WebhookService) post(ctx context.Context, delivery *model.WebhookDelivery) (int, error) {
+	secret := s.secret
+	client := s.client
+	if delivery.EndpointID != 0 {
+		client = s.endpointClient
+		endpoint, err := s.repo.FindEndpoint(delivery.EndpointID)
+		if err != nil {
+			return 0, err
+		}
+		if endpoint == nil {
+			return 0, errors.New("webhook endpoint no longer exists")
+		}
+		secret = endpoint.Secret
+	}
+	if secret == "" {
+		return 0, errors.New("webhook has no signing secret")
+	}
+
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chsi-auto-score-query")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookIDHeader, delivery.EventID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// newEventID returns a random event identifier
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
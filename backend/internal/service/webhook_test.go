@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chsi-auto-score-query/internal/model"
+	"chsi-auto-score-query/internal/notify"
+	"chsi-auto-score-query/internal/repo"
+	"chsi-auto-score-query/pkg/config"
+)
+
+type webhookReceiver struct {
+	mu     sync.Mutex
+	status int
+	events []WebhookEvent
+	errs   []string
+}
+
+func (rcv *webhookReceiver) handler(secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if got := r.Header.Get(WebhookSignatureHeader); got != SignWebhook(secret, ts, body) {
+			rcv.errs = append(rcv.errs, "bad signature "+got)
+		}
+		var event WebhookEvent
+		json.Unmarshal(body, &event)
+		if r.Header.Get(WebhookEventHeader) != event.Type || r.Header.Get(WebhookIDHeader) != event.ID {
+			rcv.errs = append(rcv.errs, "headers do not match payload")
+		}
+		rcv.events = append(rcv.events, event)
+		if rcv.status != 0 {
+			w.WriteHeader(rcv.status)
+		}
+	}
+}
+
+func TestWebhookPublishAndDispatch(t *testing.T) {
+	global := &webhookReceiver{}
+	globalSrv := httptest.NewServer(global.handler("global-secret"))
+	defer globalSrv.Close()
+	own := &webhookReceiver{}
+	ownSrv := httptest.NewServer(own.handler("own-secret"))
+	defer ownSrv.Close()
+
+	db := newTestDB(t)
+	user := &model.User{
+		Email:    "a@example.com",
+		InfoHash: "h1",
+		Status:   model.QueryStatusScoreReleased,
+		Score: &model.ScoreResult{Status: model.QueryStatusScoreReleased, Total: "400", CandidateName: "张三",
+			ExamID: "100010000000001", Raw: `{"xm":"张三"}`},
+		// The second endpoint predates generated secrets and is not sent to
+		Webhooks: []model.WebhookEndpoint{{URL: ownSrv.URL, Secret: "own-secret"}, {URL: ownSrv.URL}},
+	}
+	if err := repo.NewUserRepo(db).Create(user); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewWebhookService(db, &config.Config{WebhookURLs: globalSrv.URL, WebhookSecret: "global-secret", WebhookMaxAttempts: 3})
+	if err := svc.Publish(user, model.WebhookEventScoreReleased, model.QueryStatusNotPublished); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	select {
+	case <-svc.Wake():
+	default:
+		t.Error("Publish() did not wake the dispatcher")
+	}
+	svc.Dispatch(context.Background())
+
+	// The submitter's URL resolves to loopback and is refused until allowed
+	if len(own.events) != 0 {
+		t.Fatalf("own receiver on loopback got %d events", len(own.events))
+	}
+	failed, _, _ := svc.ListDeliveries(user.ID, model.DeliveryPending, 10, 0)
+	if len(failed) != 1 || !strings.Contains(failed[0].LastError, notify.ErrPrivateAddress.Error()) {
+		t.Fatalf("pending deliveries = %+v, want the refused one", failed)
+	}
+	notify.AllowPrivateAddresses(true)
+	defer notify.AllowPrivateAddresses(false)
+	svc.Dispatch(context.Background())
+
+	for name, rcv := range map[string]*webhookReceiver{"global": global, "own": own} {
+		if len(rcv.errs) > 0 {
+			t.Errorf("%s receiver: %v", name, rcv.errs)
+		}
+		if len(rcv.events) != 1 {
+			t.Fatalf("%s receiver got %d events, want 1", name, len(rcv.events))
+		}
+		data := rcv.events[0].Data
+		if rcv.events[0].Type != model.WebhookEventScoreReleased || data.UserID != user.ID ||
+			data.PreviousStatus != model.QueryStatusNotPublished || data.Score == nil || data.Score.Total != "400" {
+			t.Errorf("%s receiver got unexpected event %+v", name, rcv.events[0])
+		}
+		if data.Score != nil && (data.Score.Raw != "" || data.Score.CandidateName != "" || data.Score.ExamID != "") {
+			t.Errorf("%s receiver got personal data: %+v", name, data.Score)
+		}
+	}
+
+	deliveries, total, err := svc.ListDeliveries(user.ID, model.DeliveryDelivered, 10, 0)
+	if err != nil || total != 2 || len(deliveries) != 2 {
+		t.Errorf("ListDeliveries() = %d/%d, %v, want 2 delivered", len(deliveries), total, err)
+	}
+}
+
+func TestWebhookNeedsSecret(t *testing.T) {
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv.handler(""))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Status: model.QueryStatusFailed}
+	if err := repo.NewUserRepo(db).Create(user); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewWebhookService(db, &config.Config{WebhookURLs: srv.URL, WebhookMaxAttempts: 2})
+	if err := svc.Publish(user, model.WebhookEventQueryFailed, model.QueryStatusPending); err != nil {
+		t.Fatal(err)
+	}
+	if deliveries, total, _ := svc.ListDeliveries(user.ID, "", 10, 0); total != 0 {
+		t.Errorf("queued %d deliveries without a signing secret: %+v", total, deliveries)
+	}
+
+	// Deliveries queued before the secret was removed are refused as well
+	delivery := &model.WebhookDelivery{UserID: user.ID, URL: srv.URL, Payload: "{}", Status: model.DeliveryPending}
+	db.Create(delivery)
+	svc.Dispatch(context.Background())
+	if len(rcv.events) != 0 {
+		t.Errorf("receiver got %d unsigned events", len(rcv.events))
+	}
+}
+
+func TestWebhookRetryAndReplay(t *testing.T) {
+	rcv := &webhookReceiver{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rcv.handler("secret"))
+	defer srv.Close()
+
+	db := newTestDB(t)
+	user := &model.User{Email: "a@example.com", InfoHash: "h1", Status: model.QueryStatusFailed}
+	if err := repo.NewUserRepo(db).Create(user); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewWebhookService(db, &config.Config{WebhookURLs: srv.URL, AppSecret: "secret", WebhookMaxAttempts: 2, WebhookBackoffBase: 60, WebhookBackoffMax: 60})
+	if err := svc.Publish(user, model.WebhookEventQueryFailed, model.QueryStatusPending); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.Dispatch(context.Background())
+	webhookRepo := repo.NewWebhookRepo(db)
+	delivery, _ := webhookRepo.FindDelivery(1)
+	if delivery.Status != model.DeliveryPending || delivery.Attempts != 1 || delivery.ResponseCode != 500 || delivery.NextAttemptAt == nil {
+		t.Fatalf("after first failure: %+v", delivery)
+	}
+
+	// Not due yet
+	svc.Dispatch(context.Background())
+	if len(rcv.events) != 1 {
+		t.Fatalf("retry sent before backoff elapsed")
+	}
+
+	past := time.Now().Add(-time.Second)
+	delivery.NextAttemptAt = &past
+	webhookRepo.UpdateDelivery(delivery)
+	svc.Dispatch(context.Background())
+	delivery, _ = webhookRepo.FindDelivery(1)
+	if delivery.Status != model.DeliveryFailed || delivery.Attempts != 2 {
+		t.Fatalf("after retry budget: %+v", delivery)
+	}
+
+	rcv.status = http.StatusOK
+	replayed, err := svc.Replay(context.Background(), delivery.ID)
+	if err != nil || replayed.Status != model.DeliveryDelivered || replayed.DeliveredAt == nil {
+		t.Fatalf("Replay() = %+v, %v", replayed, err)
+	}
+	if len(rcv.events) != 3 || rcv.events[2].ID != rcv.events[0].ID {
+		t.Errorf("replay should resend the same event")
+	}
+	if missing, err := svc.Replay(context.Background(), 99); missing != nil || err != nil {
+		t.Errorf("Replay(unknown) = %v, %v", missing, err)
+	}
+}
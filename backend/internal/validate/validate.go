@@ -0,0 +1,250 @@
+// Package validate checks submitted candidate data before it is stored, so
+// that obviously wrong entries are rejected instead of costing a CHSI query
+// that ends in "信息不匹配".
+package validate
+
+import (
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Error codes returned with a FieldError
+const (
+	CodeRequired          = "required"
+	CodeInvalidName       = "invalid_name"
+	CodeInvalidIDCard     = "invalid_id_card"
+	CodeIDCardChecksum    = "id_card_checksum"
+	CodeIDCardBirthDate   = "id_card_birth_date"
+	CodeInvalidExamID     = "invalid_exam_id"
+	CodeExamIDSchoolCode  = "exam_id_school_code_mismatch"
+	CodeInvalidSchoolCode = "invalid_school_code"
+	CodeInvalidEmail      = "invalid_email"
+	CodeTooManyRecipients = "too_many_recipients"
+)
+
+// MaxRecipients is the number of additional recipients of a submission
+const MaxRecipients = 5
+
+// Identity document types recognised by IDCard
+const (
+	DocResident = "resident" // 居民身份证（含港澳台居民居住证）
+	DocPassport = "passport" // 护照
+	DocHKMacau  = "hk_macau" // 港澳居民来往内地通行证
+	DocTaiwan   = "taiwan"   // 台湾居民来往大陆通行证
+)
+
+// FieldError describes why one field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors collects the field errors of a submission
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+var (
+	residentPattern   = regexp.MustCompile(`^[1-8][0-9]{16}[0-9X]$`)
+	hkMacauPattern    = regexp.MustCompile(`^[HM][0-9]{8}([0-9]{2})?$`)
+	taiwanPattern     = regexp.MustCompile(`^([0-9]{8}|[A-Z][0-9]{9})$`)
+	passportPattern   = regexp.MustCompile(`^[A-Z][A-Z0-9]{4,16}$`)
+	examIDPattern     = regexp.MustCompile(`^[0-9]{15}$`)
+	schoolCodePattern = regexp.MustCompile(`^[0-9]{5}$`)
+	domainLabel       = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+)
+
+// GB 11643 weights of the first 17 digits and the check characters by
+// weighted sum modulo 11
+var (
+	residentWeights = [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	residentCheck   = "10X98765432"
+)
+
+// Submission holds the fields of a submission to validate
+type Submission struct {
+	Name       string
+	IDCard     string
+	ExamID     string
+	Email      string
+	SchoolCode string
+}
+
+// Check validates every field and returns Errors, or nil if all are valid
+func (s Submission) Check() error {
+	var errs Errors
+	add := func(err *FieldError) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	add(Name(s.Name))
+	if _, err := IDCard(s.IDCard); err != nil {
+		add(err)
+	}
+	add(SchoolCode(s.SchoolCode))
+	add(ExamID(s.ExamID, s.SchoolCode))
+	add(Email(s.Email))
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Name checks that a name is present and of a plausible length
+func Name(name string) *FieldError {
+	if name == "" {
+		return required("name")
+	}
+	if utf8.RuneCountInString(name) > 64 {
+		return &FieldError{Field: "name", Code: CodeInvalidName, Message: "name is too long"}
+	}
+	return nil
+}
+
+// NormalizeIDCard trims the number and upper-cases letters such as the
+// check character X
+func NormalizeIDCard(idCard string) string {
+	return strings.ToUpper(strings.TrimSpace(idCard))
+}
+
+// IDCard validates an identity document number and returns its type. An
+// 18-character number of digits (and a final X) must be a resident ID with a
+// valid GB 11643 checksum and birth date; other numbers are matched against
+// the HK/Macau and Taiwan permit and passport formats.
+func IDCard(idCard string) (string, *FieldError) {
+	idCard = NormalizeIDCard(idCard)
+	if idCard == "" {
+		return "", required("id_card")
+	}
+
+	if len(idCard) == 18 && residentPattern.MatchString(idCard) {
+		return DocResident, checkResident(idCard)
+	}
+	if len(idCard) == 18 && strings.Trim(idCard[:17], "0123456789") == "" {
+		return "", &FieldError{Field: "id_card", Code: CodeInvalidIDCard, Message: "resident ID card number is malformed"}
+	}
+
+	switch {
+	case hkMacauPattern.MatchString(idCard):
+		return DocHKMacau, nil
+	case taiwanPattern.MatchString(idCard):
+		return DocTaiwan, nil
+	case passportPattern.MatchString(idCard):
+		return DocPassport, nil
+	}
+	return "", &FieldError{Field: "id_card", Code: CodeInvalidIDCard, Message: "not a valid resident ID, passport or HK/Macau/Taiwan permit number"}
+}
+
+// checkResident verifies the birth date and check character of an
+// 18-digit resident ID number
+func checkResident(idCard string) *FieldError {
+	birth, err := time.Parse("20060102", idCard[6:14])
+	if err != nil || birth.Year() < 1900 || birth.After(time.Now()) {
+		return &FieldError{Field: "id_card", Code: CodeIDCardBirthDate, Message: "birth date in the ID card number is invalid"}
+	}
+
+	sum := 0
+	for i, w := range residentWeights {
+		sum += int(idCard[i]-'0') * w
+	}
+	if idCard[17] != residentCheck[sum%11] {
+		return &FieldError{Field: "id_card", Code: CodeIDCardChecksum, Message: "ID card number checksum does not match"}
+	}
+	return nil
+}
+
+// SchoolCode checks the 5-digit code of the institution applied to
+func SchoolCode(code string) *FieldError {
+	if code == "" {
+		return required("school_code")
+	}
+	if !schoolCodePattern.MatchString(code) {
+		return &FieldError{Field: "school_code", Code: CodeInvalidSchoolCode, Message: "school code must be 5 digits"}
+	}
+	return nil
+}
+
+// ExamID checks the 15-digit exam ID, whose first 5 digits are the code of
+// the institution applied to
+func ExamID(examID, schoolCode string) *FieldError {
+	if examID == "" {
+		return required("exam_id")
+	}
+	if !examIDPattern.MatchString(examID) {
+		return &FieldError{Field: "exam_id", Code: CodeInvalidExamID, Message: "exam ID must be 15 digits"}
+	}
+	if schoolCodePattern.MatchString(schoolCode) && examID[:5] != schoolCode {
+		return &FieldError{Field: "exam_id", Code: CodeExamIDSchoolCode, Message: "the first 5 digits of the exam ID must match the school code"}
+	}
+	return nil
+}
+
+// Email checks the syntax of a bare RFC 5322 address with a fully qualified
+// domain. The domain is not looked up.
+func Email(email string) *FieldError {
+	if email == "" {
+		return required("email")
+	}
+	invalid := &FieldError{Field: "email", Code: CodeInvalidEmail, Message: "email address is invalid"}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email || len(email) > 254 {
+		return invalid
+	}
+	local, domain, _ := strings.Cut(addr.Address, "@")
+	if len(local) > 64 || strings.HasPrefix(domain, "[") {
+		return invalid
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return invalid
+	}
+	for _, label := range labels {
+		if !domainLabel.MatchString(label) {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// Recipients checks the additional email recipients of a submission and
+// returns them without duplicates and without the submission's own address
+func Recipients(primary string, emails []string) ([]string, *FieldError) {
+	seen := map[string]bool{strings.ToLower(primary): true}
+	recipients := make([]string, 0, len(emails))
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		if err := Email(email); err != nil {
+			return nil, &FieldError{Field: "recipients", Code: CodeInvalidEmail, Message: "recipient " + err.Message}
+		}
+		if seen[strings.ToLower(email)] {
+			continue
+		}
+		seen[strings.ToLower(email)] = true
+		recipients = append(recipients, email)
+	}
+	if len(recipients) > MaxRecipients {
+		return nil, &FieldError{Field: "recipients", Code: CodeTooManyRecipients, Message: "at most " + strconv.Itoa(MaxRecipients) + " additional recipients are allowed"}
+	}
+	return recipients, nil
+}
+
+func required(field string) *FieldError {
+	return &FieldError{Field: field, Code: CodeRequired, Message: field + " is required"}
+}
@@ -0,0 +1,91 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIDCard(t *testing.T) {
+	tests := []struct {
+		idCard string
+		doc    string
+		code   string
+	}{
+		{"11010519491231002X", DocResident, ""},
+		{"11010519491231002x", DocResident, ""},
+		{"110101199003076632", DocResident, ""},
+		{"810000199001011230", DocResident, ""},
+		{"110105194912310021", DocResident, CodeIDCardChecksum},
+		{"110105194913310023", DocResident, CodeIDCardBirthDate},
+		{"11010519491231002Y", "", CodeInvalidIDCard},
+		{"H12345678", DocHKMacau, ""},
+		{"M1234567890", DocHKMacau, ""},
+		{"12345678", DocTaiwan, ""},
+		{"A123456789", DocTaiwan, ""},
+		{"123456789", "", CodeInvalidIDCard},
+		{"1234567890", "", CodeInvalidIDCard},
+		{"1234567890A", "", CodeInvalidIDCard},
+		{"E12345678", DocPassport, ""},
+		{"1234", "", CodeInvalidIDCard},
+		{"", "", CodeRequired},
+	}
+	for _, tt := range tests {
+		doc, err := IDCard(tt.idCard)
+		code := ""
+		if err != nil {
+			code = err.Code
+		}
+		if code != tt.code || (tt.code == "" && doc != tt.doc) {
+			t.Errorf("IDCard(%q) = %q, %q, want %q, %q", tt.idCard, doc, code, tt.doc, tt.code)
+		}
+	}
+}
+
+func TestExamIDAndSchoolCode(t *testing.T) {
+	if err := ExamID("103586210002651", "10358"); err != nil {
+		t.Errorf("ExamID() = %v", err)
+	}
+	if err := ExamID("103586210002651", "10001"); err == nil || err.Code != CodeExamIDSchoolCode {
+		t.Errorf("mismatched school code: %v", err)
+	}
+	if err := ExamID("10358621000265", "10358"); err == nil || err.Code != CodeInvalidExamID {
+		t.Errorf("14 digits: %v", err)
+	}
+	if err := SchoolCode("1035A"); err == nil || err.Code != CodeInvalidSchoolCode {
+		t.Errorf("SchoolCode() = %v", err)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	valid := []string{"a@example.com", "first.last+tag@mail.example.cn", "x@a-b.io"}
+	invalid := []string{"a@example", "Name <a@example.com>", "a@@example.com", "a@-example.com", "a@[127.0.0.1]", "a example@x.com"}
+	for _, email := range valid {
+		if err := Email(email); err != nil {
+			t.Errorf("Email(%q) = %v", email, err)
+		}
+	}
+	for _, email := range invalid {
+		if err := Email(email); err == nil {
+			t.Errorf("Email(%q) accepted", email)
+		}
+	}
+}
+
+func TestSubmissionCheck(t *testing.T) {
+	err := Submission{Name: "张三", IDCard: "110101199001011234", ExamID: "100010000000001", SchoolCode: "10002"}.Check()
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("Check() = %v", err)
+	}
+	fields := map[string]string{}
+	for _, e := range errs {
+		fields[e.Field] = e.Code
+	}
+	if fields["id_card"] != CodeIDCardChecksum || fields["exam_id"] != CodeExamIDSchoolCode || fields["email"] != CodeRequired {
+		t.Errorf("field codes = %v", fields)
+	}
+
+	if err := (Submission{Name: "张三", IDCard: "110101199001011237", ExamID: "100010000000001", SchoolCode: "10001", Email: "a@example.com"}).Check(); err != nil {
+		t.Errorf("valid submission: %v", err)
+	}
+}
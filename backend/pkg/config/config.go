@@ -11,23 +11,101 @@ type Config struct {
 	// 服务器配置
 	Port     string
 	LogLevel string
+	// 优雅退出等待时间（秒）
+	ShutdownTimeout int
+	// 对外访问地址（用于邮件中的链接）和签名密钥
+	PublicBaseURL string
+	AppSecret     string
+	// 是否信任反向代理的 X-Forwarded-For（取最右侧地址）/ X-Real-IP 头（用于按IP限流）
+	TrustProxy bool
+	// 成绩查询接口每个IP每分钟允许的请求数（0表示不限制）
+	ScoreLookupRateLimit int
+	// 管理链接接口每个IP每分钟允许的请求数（0表示不限制）
+	ManageLinkRateLimit int
 
 	// CHSI登录配置
 	ChsiUsername string
 	ChsiPassword string
+	// 会话有效性探测间隔（秒）
+	SessionProbeInterval int
+	// 全局请求速率上限（次/秒，0表示不限制）
+	ChsiRateLimit float64
 
 	// 邮件配置
 	SMTPServer string
 	SMTPPort   int
 	SMTPUser   string
 	SMTPPass   string
+	// 加密方式（auto / tls / starttls / none）、证书校验和认证方式（auto / plain / login / cram-md5）
+	SMTPTLSMode     string
+	SMTPSkipVerify  bool
+	SMTPAuth        string
+	SMTPPoolSize    int
+	SMTPIdleTimeout int
+	// 发件人地址（默认SMTP_USER）和显示名称
+	SMTPFrom     string
+	SMTPFromName string
+	// 邮件模板目录，为空或缺少文件时使用内置模板
+	EmailTemplateDir string
+
+	// 邮箱验证配置
+	EmailVerification bool
+	VerifyTokenTTL    int
+	UnverifiedTTL     int
+	// 自助管理链接有效期
+	ManageLinkTTL int
+
+	// 敏感字段加密配置
+	FieldEncryptionKeys       string
+	FieldEncryptionKeyFile    string
+	FieldEncryptionPrimaryKey string
+	InfoHashKey               string
+
+	// 个人信息保留策略
+	PIIRetention  int
+	PIIPurgeMode  string
+	PurgeInterval int
+
+	// Webhook配置：全局地址（逗号分隔）、签名密钥和投递重试
+	WebhookURLs             string
+	WebhookSecret           string
+	WebhookMaxAttempts      int
+	WebhookBackoffBase      int
+	WebhookBackoffMax       int
+	WebhookDispatchInterval int
+
+	// 通知发件箱：投递重试次数与退避间隔
+	NotifyMaxAttempts      int
+	NotifyBackoffBase      int
+	NotifyBackoffMax       int
+	NotifyDispatchInterval int
+	// 每日汇总的发送时刻（本地时间0-23点），负数关闭每日汇总
+	DigestHour int
+	// 是否允许通知渠道和提交者的Webhook访问内网、回环等非公网地址
+	NotifyAllowPrivate bool
+
+	// 管理接口认证：Bearer令牌（逗号分隔可配置多个）或Basic认证用户名密码，均为空时关闭管理接口
+	AdminToken    string
+	AdminUsername string
+	AdminPassword string
 
 	// 数据库配置
 	DatabaseDSN string
 
 	// 查询配置
-	QueryInterval      int
-	ClearDBOnStart     bool
+	QueryInterval int
+	QueryWorkers  int
+	QueryTimeout  int
+	// 失败重试：最大次数、退避基数和上限（秒）
+	QueryMaxAttempts int
+	QueryBackoffBase int
+	QueryBackoffMax  int
+	// 查询历史：每个用户保留的条数、保留时长（秒，0为不限）以及是否保存压缩的响应页面
+	QueryHistoryLimit     int
+	QueryHistoryRetention int
+	QuerySnapshots        bool
+	ClearDBOnStart        bool
+	// 启动时导入的用户：CSV/JSONL文件路径或内联条目
 	InitialUserEntries string
 }
 
@@ -36,18 +114,68 @@ func Load() (*Config, error) {
 	_ = godotenv.Load()
 
 	cfg := &Config{
-		Port:               getEnv("PORT", "8080"),
-		LogLevel:           getEnv("LOG_LEVEL", "info"),
-		ChsiUsername:       os.Getenv("CHSI_USERNAME"),
-		ChsiPassword:       os.Getenv("CHSI_PASSWORD"),
-		SMTPServer:         getEnv("SMTP_SERVER", "smtp.gmail.com"),
-		SMTPPort:           getEnvInt("SMTP_PORT", 587),
-		SMTPUser:           getEnv("SMTP_USER", ""),
-		SMTPPass:           getEnv("SMTP_PASSWORD", ""),
-		DatabaseDSN:        getEnv("DATABASE_DSN", "./data/chsi.db"),
-		QueryInterval:      getEnvInt("QUERY_INTERVAL", 3600),
-		ClearDBOnStart:     getEnvBool("CLEAR_DB_ON_START", false),
-		InitialUserEntries: getEnv("INITIAL_USER_ENTRIES", ""),
+		Port:                      getEnv("PORT", "8080"),
+		LogLevel:                  getEnv("LOG_LEVEL", "info"),
+		ShutdownTimeout:           getEnvInt("SHUTDOWN_TIMEOUT", 30),
+		PublicBaseURL:             getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		AppSecret:                 getEnv("APP_SECRET", ""),
+		TrustProxy:                getEnvBool("TRUST_PROXY", false),
+		ScoreLookupRateLimit:      getEnvInt("SCORE_LOOKUP_RATE_LIMIT", 10),
+		ManageLinkRateLimit:       getEnvInt("MANAGE_LINK_RATE_LIMIT", 5),
+		ChsiUsername:              os.Getenv("CHSI_USERNAME"),
+		ChsiPassword:              os.Getenv("CHSI_PASSWORD"),
+		SessionProbeInterval:      getEnvInt("CHSI_SESSION_PROBE_INTERVAL", 300),
+		ChsiRateLimit:             getEnvFloat("CHSI_RATE_LIMIT", 2),
+		SMTPServer:                getEnv("SMTP_SERVER", "smtp.gmail.com"),
+		SMTPPort:                  getEnvInt("SMTP_PORT", 587),
+		SMTPUser:                  getEnv("SMTP_USER", ""),
+		SMTPTLSMode:               getEnv("SMTP_TLS_MODE", "auto"),
+		SMTPSkipVerify:            getEnvBool("SMTP_TLS_SKIP_VERIFY", false),
+		SMTPAuth:                  getEnv("SMTP_AUTH", "auto"),
+		SMTPPoolSize:              getEnvInt("SMTP_POOL_SIZE", 2),
+		SMTPIdleTimeout:           getEnvInt("SMTP_IDLE_TIMEOUT", 30),
+		SMTPFrom:                  getEnv("SMTP_FROM", ""),
+		SMTPFromName:              getEnv("SMTP_FROM_NAME", "考研成绩自动查询"),
+		EmailTemplateDir:          getEnv("EMAIL_TEMPLATE_DIR", ""),
+		SMTPPass:                  getEnv("SMTP_PASSWORD", ""),
+		EmailVerification:         getEnvBool("EMAIL_VERIFICATION", true),
+		VerifyTokenTTL:            getEnvInt("VERIFY_TOKEN_TTL", 86400),
+		UnverifiedTTL:             getEnvInt("UNVERIFIED_TTL", 172800),
+		ManageLinkTTL:             getEnvInt("MANAGE_LINK_TTL", 3600),
+		FieldEncryptionKeys:       getEnv("FIELD_ENCRYPTION_KEYS", ""),
+		FieldEncryptionKeyFile:    getEnv("FIELD_ENCRYPTION_KEY_FILE", ""),
+		FieldEncryptionPrimaryKey: getEnv("FIELD_ENCRYPTION_PRIMARY_KEY", ""),
+		InfoHashKey:               getEnv("INFO_HASH_KEY", ""),
+		PIIRetention:              getEnvInt("PII_RETENTION", 604800),
+		PIIPurgeMode:              getEnv("PII_PURGE_MODE", "anonymize"),
+		PurgeInterval:             getEnvInt("PURGE_INTERVAL", 3600),
+		WebhookURLs:               getEnv("WEBHOOK_URLS", ""),
+		WebhookSecret:             getEnv("WEBHOOK_SECRET", ""),
+		WebhookMaxAttempts:        getEnvInt("WEBHOOK_MAX_ATTEMPTS", 6),
+		WebhookBackoffBase:        getEnvInt("WEBHOOK_BACKOFF_BASE", 30),
+		WebhookBackoffMax:         getEnvInt("WEBHOOK_BACKOFF_MAX", 3600),
+		WebhookDispatchInterval:   getEnvInt("WEBHOOK_DISPATCH_INTERVAL", 30),
+		NotifyMaxAttempts:         getEnvInt("NOTIFY_MAX_ATTEMPTS", 8),
+		NotifyBackoffBase:         getEnvInt("NOTIFY_BACKOFF_BASE", 60),
+		NotifyBackoffMax:          getEnvInt("NOTIFY_BACKOFF_MAX", 3600),
+		NotifyDispatchInterval:    getEnvInt("NOTIFY_DISPATCH_INTERVAL", 30),
+		NotifyAllowPrivate:        getEnvBool("NOTIFY_ALLOW_PRIVATE", false),
+		DigestHour:                getEnvInt("DIGEST_HOUR", 9),
+		AdminToken:                getEnv("ADMIN_TOKEN", ""),
+		AdminUsername:             getEnv("ADMIN_USERNAME", ""),
+		AdminPassword:             getEnv("ADMIN_PASSWORD", ""),
+		DatabaseDSN:               getEnv("DATABASE_DSN", "./data/chsi.db"),
+		QueryInterval:             getEnvInt("QUERY_INTERVAL", 3600),
+		QueryWorkers:              getEnvInt("QUERY_WORKERS", 4),
+		QueryTimeout:              getEnvInt("QUERY_TIMEOUT", 120),
+		QueryMaxAttempts:          getEnvInt("QUERY_MAX_ATTEMPTS", 8),
+		QueryBackoffBase:          getEnvInt("QUERY_BACKOFF_BASE", 60),
+		QueryBackoffMax:           getEnvInt("QUERY_BACKOFF_MAX", 21600),
+		QueryHistoryLimit:         getEnvInt("QUERY_HISTORY_LIMIT", 200),
+		QueryHistoryRetention:     getEnvInt("QUERY_HISTORY_RETENTION", 2592000),
+		QuerySnapshots:            getEnvBool("QUERY_SNAPSHOTS", false),
+		ClearDBOnStart:            getEnvBool("CLEAR_DB_ON_START", false),
+		InitialUserEntries:        getEnv("INITIAL_USER_ENTRIES", ""),
 	}
 
 	return cfg, nil
@@ -72,6 +200,18 @@ func getEnvInt(key string, defaultValue int) int {
 	return value
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {